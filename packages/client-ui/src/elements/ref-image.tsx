@@ -5,14 +5,28 @@ export interface RefImageProps extends React.HTMLAttributes<HTMLImageElement> {
   src?: FileRef;
 }
 export default function RefImage(props: RefImageProps): React.ReactElement | null {
-  return props.src ? (
+  if (!props.src) {
+    return null;
+  }
+
+  // Remote images are served from Knossos' image cache. If that fails (i.e. because the cache is disabled), we fall
+  // back to loading them directly.
+  const webUrl =
+    props.src.urls.length > 0 && props.src.urls[0].indexOf('file://') !== 0 ? props.src.urls[0] : null;
+
+  return (
     <img
       {...props}
-      src={
-        props.src.urls.length > 0 && props.src.urls[0].indexOf('file://') !== 0
-          ? props.src.urls[0]
-          : API_URL + '/ref/' + props.src.fileid
+      src={API_URL + '/ref/' + props.src.fileid}
+      onError={
+        webUrl
+          ? (e) => {
+              if (e.currentTarget.src !== webUrl) {
+                e.currentTarget.src = webUrl;
+              }
+            }
+          : props.onError
       }
     />
-  ) : null;
+  );
 }
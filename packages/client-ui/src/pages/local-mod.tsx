@@ -112,6 +112,34 @@ async function changeDepSnapshot(
 
     if (!result.response.success) {
       gs.launchOverlay(ErrorDialog, { message: 'Failed to save the changed dependency!' });
+      return;
+    }
+
+    const flags = result.response.flags;
+    if (flags && !flags.valid) {
+      const issues = [
+        ['Unknown', flags.unknown],
+        ['Removed', flags.removed],
+        ['Duplicated', flags.duplicated],
+      ] as const;
+
+      gs.toaster.show({
+        icon: 'warning-sign',
+        intent: 'warning',
+        timeout: 0,
+        message: (
+          <div>
+            The command line has issues with {version}:
+            {issues.map(([label, list]) =>
+              list.length > 0 ? (
+                <div key={label}>
+                  {label}: {list.join(', ')}
+                </div>
+              ) : null,
+            )}
+          </div>
+        ),
+      });
     }
   } catch (e) {
     console.error(e);
@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"path/filepath"
 	"strings"
 	"unsafe"
 
@@ -27,10 +26,10 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"github.com/ngld/knossos/packages/api/client"
-	"github.com/ngld/knossos/packages/api/common"
 	"github.com/ngld/knossos/packages/libarchive"
 	"github.com/ngld/knossos/packages/libknossos/pkg/api"
 	"github.com/ngld/knossos/packages/libknossos/pkg/helpers"
+	"github.com/ngld/knossos/packages/libknossos/pkg/mods"
 	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
 	"github.com/ngld/knossos/packages/libknossos/pkg/twirp"
 )
@@ -131,15 +130,7 @@ func serveRequest(ctx context.Context, twirpResp *memoryResponse, req *http.Requ
 }
 
 //nolint:golint // golint doesn't understand cgo
-func handleLocalFile(fileRef *common.FileRef) (*C.KnossosResponse, error) {
-	localPath := ""
-	for _, item := range fileRef.Urls {
-		if strings.HasPrefix(item, "file://") {
-			localPath = filepath.FromSlash(item[7:])
-			break
-		}
-	}
-
+func handleLocalFile(localPath string) (*C.KnossosResponse, error) {
 	if localPath != "" {
 		data, err := ioutil.ReadFile(localPath)
 		if err != nil {
@@ -185,14 +176,15 @@ func KnossosHandleRequest(urlPtr *C.char, urlLen C.int, bodyPtr unsafe.Pointer,
 
 	var err error
 	if strings.HasPrefix(reqURL, "https://api.client.fsnebula.org/ref/") {
+		var localPath string
+		fileId := reqURL[36:]
+		// Remote images might have to be downloaded first so we can only cancel the context afterwards
+		localPath, err = mods.ResolveImage(ctx, fileId)
 		cancel()
 
-		var fileRef *common.FileRef
-		fileId := reqURL[36:]
-		fileRef, err = storage.GetFile(ctx, fileId)
 		if err == nil {
 			var resp *C.KnossosResponse
-			resp, err = handleLocalFile(fileRef)
+			resp, err = handleLocalFile(localPath)
 			if err == nil {
 				return resp
 			}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/ngld/knossos/packages/api/client"
 	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/mods"
 	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
 	"github.com/ngld/knossos/packages/libknossos/pkg/twirp"
 )
@@ -159,23 +160,15 @@ func wsHandler(rw http.ResponseWriter, r *http.Request) {
 func refHandler(rw http.ResponseWriter, r *http.Request) {
 	// /ref/
 	fileID := r.URL.Path[5:]
-	fileRef, err := storage.GetFile(r.Context(), fileID)
+	localPath, err := mods.ResolveImage(r.Context(), fileID)
 	if err != nil {
-		log.Error().Err(err).Msgf("Failed to look up file ref %s", fileID)
-		rw.WriteHeader(500)
-		return
-	}
-
-	localPath := ""
-	for _, item := range fileRef.Urls {
-		if strings.HasPrefix(item, "file://") {
-			localPath = filepath.FromSlash(item[7:])
-			break
+		if eris.Is(err, mods.ErrImageNotAvailable) {
+			rw.WriteHeader(404)
+			return
 		}
-	}
 
-	if localPath == "" {
-		rw.WriteHeader(404)
+		log.Error().Err(err).Msgf("Failed to look up file ref %s", fileID)
+		rw.WriteHeader(500)
 		return
 	}
 
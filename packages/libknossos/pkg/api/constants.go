@@ -1,7 +1,10 @@
 package api
 
+import "strings"
+
 var (
 	releaseBuild = "false"
+	syncKeys     = ""
 	// Version contains the compiles libknossos version
 	Version = "0.0.1"
 	// Commit contains a short hash pointing to the commit that was used to compile libknossos
@@ -15,3 +18,9 @@ var (
 
 // ReleaseBuild indicates whether this build is a release build (true) or a debug build (false)
 var ReleaseBuild = releaseBuild == "true"
+
+// SyncKeys contains the base64 encoded ed25519 public keys which are trusted to sign modsync files. Builds set them
+// through -ldflags "-X github.com/ngld/knossos/packages/libknossos/pkg/api.syncKeys=<key1>,<key2>". Only pin a key once
+// Nebula signs with it and keep the old key around until Nebula stops signing with it to allow key rotation. Release
+// builds refuse to configure without a key.
+var SyncKeys = strings.FieldsFunc(syncKeys, func(r rune) bool { return r == ',' })
@@ -302,15 +302,20 @@ func ProgressCopier(ctx context.Context, stepInfo TaskStep, length int64, input
 	}
 }
 
-// RunTask updates the context with the necessary task info and handles errors as well as panics from the task.
-func RunTask(ctx context.Context, ref uint32, task func(context.Context) error) {
+// DetachedContext returns a new context with the same Knossos parameters as the passed context. Unlike the passed
+// context, it won't be cancelled once the current request finishes which makes it suitable for background work.
+func DetachedContext(ctx context.Context) context.Context {
 	knCtx, ok := ctx.Value(knKey{}).(KnossosCtxParams)
 	if !ok {
 		panic("wrong type in knossos context")
 	}
 
-	taskCtx := WithKnossosContext(context.Background(), knCtx)
-	taskCtx = WithTaskContext(taskCtx, TaskCtxParams{Ref: ref})
+	return WithKnossosContext(context.Background(), knCtx)
+}
+
+// RunTask updates the context with the necessary task info and handles errors as well as panics from the task.
+func RunTask(ctx context.Context, ref uint32, task func(context.Context) error) {
+	taskCtx := WithTaskContext(DetachedContext(ctx), TaskCtxParams{Ref: ref})
 	taskCtx, cancel := context.WithCancel(taskCtx)
 	taskCancels[ref] = cancel
 
@@ -0,0 +1,218 @@
+package fsointerop
+
+import (
+	"strings"
+)
+
+type iniLineKind int
+
+const (
+	iniOther iniLineKind = iota
+	iniSection
+	iniKey
+)
+
+// iniLine is a single line of an ini file. Only the value of key lines is ever modified; everything else is written
+// back as it was read.
+type iniLine struct {
+	raw  string
+	cr   bool
+	kind iniLineKind
+	// section name for section lines and the containing section for key lines
+	section string
+	key     string
+	value   string
+	// valueStart and valueEnd mark the value in raw; everything around it (including comments) is preserved
+	valueStart int
+	valueEnd   int
+}
+
+// iniDocument is a line-based model of an ini file which keeps unknown sections, keys, comments and the original
+// formatting. An unmodified document serialises to exactly the bytes it was parsed from.
+type iniDocument struct {
+	lines []*iniLine
+}
+
+func parseINILine(raw string, section string) *iniLine {
+	line := &iniLine{raw: raw, section: section}
+	if strings.HasSuffix(raw, "\r") {
+		line.raw = raw[:len(raw)-1]
+		line.cr = true
+	}
+
+	trimmed := strings.TrimSpace(line.raw)
+	switch {
+	case trimmed == "", trimmed[0] == '#', trimmed[0] == ';':
+		line.kind = iniOther
+	case trimmed[0] == '[':
+		end := strings.IndexRune(trimmed, ']')
+		if end < 0 {
+			line.kind = iniOther
+			break
+		}
+
+		line.kind = iniSection
+		line.section = trimmed[1:end]
+	default:
+		sep := strings.IndexRune(line.raw, '=')
+		if sep < 0 {
+			line.kind = iniOther
+			break
+		}
+
+		line.kind = iniKey
+		line.key = strings.TrimSpace(line.raw[:sep])
+
+		// Comments can follow the value on the same line
+		rest := line.raw[sep+1:]
+		if pos := strings.IndexAny(rest, "#;"); pos > -1 {
+			rest = rest[:pos]
+		}
+
+		line.valueStart = sep + 1 + len(rest) - len(strings.TrimLeft(rest, " \t"))
+		line.valueEnd = sep + 1 + len(strings.TrimRight(rest, " \t"))
+		if line.valueEnd < line.valueStart {
+			// the value consists of whitespace only
+			line.valueEnd = line.valueStart
+		}
+		line.value = line.raw[line.valueStart:line.valueEnd]
+	}
+
+	return line
+}
+
+func parseINIDocument(data string) *iniDocument {
+	doc := &iniDocument{}
+	if data == "" {
+		return doc
+	}
+
+	section := ""
+	for _, raw := range strings.Split(data, "\n") {
+		line := parseINILine(raw, section)
+		if line.kind == iniSection {
+			section = line.section
+		}
+
+		doc.lines = append(doc.lines, line)
+	}
+
+	return doc
+}
+
+func (d *iniDocument) newline() string {
+	if len(d.lines) > 0 && d.lines[0].cr {
+		return "\r\n"
+	}
+
+	return "\n"
+}
+
+// String returns the serialised document
+func (d *iniDocument) String() string {
+	buffer := strings.Builder{}
+	for idx, line := range d.lines {
+		if idx > 0 {
+			buffer.WriteString("\n")
+		}
+
+		buffer.WriteString(line.raw)
+		if line.cr {
+			buffer.WriteString("\r")
+		}
+	}
+
+	return buffer.String()
+}
+
+func (d *iniDocument) findKey(section, key string) *iniLine {
+	for _, line := range d.lines {
+		if line.kind == iniKey && line.section == section && line.key == key {
+			return line
+		}
+	}
+
+	return nil
+}
+
+// Get returns the value of the given key
+func (d *iniDocument) Get(section, key string) (string, bool) {
+	line := d.findKey(section, key)
+	if line == nil {
+		return "", false
+	}
+
+	return line.value, true
+}
+
+// Each calls cb for every key in the document in order
+func (d *iniDocument) Each(cb func(section, key, value string)) {
+	for _, line := range d.lines {
+		if line.kind == iniKey {
+			cb(line.section, line.key, line.value)
+		}
+	}
+}
+
+func (d *iniDocument) insertLine(pos int, line *iniLine) {
+	d.lines = append(d.lines, nil)
+	copy(d.lines[pos+1:], d.lines[pos:])
+	d.lines[pos] = line
+}
+
+// Set changes the value of the given key. Missing keys are appended to their section and missing sections are
+// appended to the document.
+func (d *iniDocument) Set(section, key, value string) {
+	line := d.findKey(section, key)
+	if line != nil {
+		if line.value != value {
+			line.raw = line.raw[:line.valueStart] + value + line.raw[line.valueEnd:]
+			line.valueEnd = line.valueStart + len(value)
+			line.value = value
+		}
+		return
+	}
+
+	cr := d.newline() == "\r\n"
+	newLine := parseINILine(key+"="+value, section)
+	newLine.cr = cr
+
+	// Insert the key after the last non-blank line of its section
+	sectionFound := false
+	insertPos := -1
+	for idx, line := range d.lines {
+		if line.kind == iniSection {
+			if sectionFound {
+				break
+			}
+			if line.section == section {
+				sectionFound = true
+				insertPos = idx + 1
+			}
+			continue
+		}
+
+		if sectionFound && strings.TrimSpace(line.raw) != "" {
+			insertPos = idx + 1
+		}
+	}
+
+	if sectionFound {
+		d.insertLine(insertPos, newLine)
+		return
+	}
+
+	// Drop the empty line following a trailing newline so that we can restore it after the new section.
+	trailingNewline := len(d.lines) > 0 && d.lines[len(d.lines)-1].raw == "" && !d.lines[len(d.lines)-1].cr
+	if trailingNewline {
+		d.lines = d.lines[:len(d.lines)-1]
+	}
+
+	if len(d.lines) > 0 {
+		d.lines = append(d.lines, &iniLine{cr: cr})
+	}
+	d.lines = append(d.lines, &iniLine{raw: "[" + section + "]", cr: cr, kind: iniSection, section: section}, newLine)
+
+	// Always end the file with a newline
+	d.lines = append(d.lines, &iniLine{})
+}
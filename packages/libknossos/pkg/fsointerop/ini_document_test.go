@@ -0,0 +1,272 @@
+package fsointerop
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+func testContext() context.Context {
+	return api.WithKnossosContext(context.Background(), api.KnossosCtxParams{
+		LogCallback: func(api.LogLevel, string, ...interface{}) {},
+	})
+}
+
+func TestINIRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"simple", "[Default]\nLanguage=English\n"},
+		{"no trailing newline", "[Default]\nLanguage=English"},
+		{"crlf", "[Default]\r\nLanguage=English\r\n\r\n[Sound]\r\nQuality=2\r\n"},
+		{"comments", "# generated by FSO\n[Default]\n; a comment\nLanguage = English # inline\n"},
+		{"unknown section", "[Default]\nLanguage=English\n\n[Custom]\nFoo=bar\n"},
+		{"keys before sections", "Foo=bar\n[Default]\nLanguage=English\n"},
+		{"whitespace", "  [Default]  \n\tLanguage\t=\tEnglish\t\n\n\n"},
+		{"broken lines", "[Default\nno equals sign\n=\n"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			result := parseINIDocument(test.input).String()
+			if result != test.input {
+				t.Fatalf("expected %q but got %q", test.input, result)
+			}
+		})
+	}
+}
+
+func TestINIGet(t *testing.T) {
+	t.Parallel()
+
+	doc := parseINIDocument("[Default]\nLanguage = English # inline\nEmpty=\n[Sound]\r\nQuality=2\r\n")
+	tests := []struct {
+		section string
+		key     string
+		value   string
+		found   bool
+	}{
+		{"Default", "Language", "English", true},
+		{"Default", "Empty", "", true},
+		{"Sound", "Quality", "2", true},
+		{"Default", "Quality", "", false},
+		{"Video", "Display", "", false},
+	}
+
+	for _, test := range tests {
+		value, found := doc.Get(test.section, test.key)
+		if value != test.value || found != test.found {
+			t.Errorf("Get(%s, %s): expected (%q, %v) but got (%q, %v)", test.section, test.key, test.value, test.found, value, found)
+		}
+	}
+}
+
+func TestINISet(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		section  string
+		key      string
+		value    string
+		expected string
+	}{
+		{
+			name:     "unchanged value",
+			input:    "[Default]\nLanguage = English # inline\n",
+			section:  "Default",
+			key:      "Language",
+			value:    "English",
+			expected: "[Default]\nLanguage = English # inline\n",
+		},
+		{
+			name:     "changed value keeps comment",
+			input:    "[Default]\nLanguage = English # inline\n",
+			section:  "Default",
+			key:      "Language",
+			value:    "German",
+			expected: "[Default]\nLanguage = German # inline\n",
+		},
+		{
+			name:     "empty value",
+			input:    "[Default]\nLastPlayer=\n",
+			section:  "Default",
+			key:      "LastPlayer",
+			value:    "Alpha1",
+			expected: "[Default]\nLastPlayer=Alpha1\n",
+		},
+		{
+			name:     "new key in existing section",
+			input:    "[Default]\nLanguage=English\n\n[Sound]\nQuality=2\n",
+			section:  "Default",
+			key:      "MaxFPS",
+			value:    "60",
+			expected: "[Default]\nLanguage=English\nMaxFPS=60\n\n[Sound]\nQuality=2\n",
+		},
+		{
+			name:     "new key in last section",
+			input:    "[Default]\nLanguage=English\n",
+			section:  "Default",
+			key:      "MaxFPS",
+			value:    "60",
+			expected: "[Default]\nLanguage=English\nMaxFPS=60\n",
+		},
+		{
+			name:     "new section",
+			input:    "[Default]\nLanguage=English\n",
+			section:  "Video",
+			key:      "Display",
+			value:    "1",
+			expected: "[Default]\nLanguage=English\n\n[Video]\nDisplay=1\n",
+		},
+		{
+			name:     "new section without trailing newline",
+			input:    "[Default]\nLanguage=English",
+			section:  "Video",
+			key:      "Display",
+			value:    "1",
+			expected: "[Default]\nLanguage=English\n\n[Video]\nDisplay=1\n",
+		},
+		{
+			name:     "new section in empty document",
+			input:    "",
+			section:  "Video",
+			key:      "Display",
+			value:    "1",
+			expected: "[Video]\nDisplay=1\n",
+		},
+		{
+			name:     "crlf",
+			input:    "[Default]\r\nLanguage=English\r\n",
+			section:  "Sound",
+			key:      "Quality",
+			value:    "2",
+			expected: "[Default]\r\nLanguage=English\r\n\r\n[Sound]\r\nQuality=2\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc := parseINIDocument(test.input)
+			doc.Set(test.section, test.key, test.value)
+
+			result := doc.String()
+			if result != test.expected {
+				t.Fatalf("expected %q but got %q", test.expected, result)
+			}
+
+			value, _ := parseINIDocument(result).Get(test.section, test.key)
+			if value != test.value {
+				t.Fatalf("expected %q after reparsing but got %q", test.value, value)
+			}
+		})
+	}
+}
+
+func TestSaveSettings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		update   func(*client.FSOSettings)
+		expected string
+	}{
+		{
+			name:     "unchanged",
+			input:    "; keep me\n[Default]\nLanguage=English\nUnknownKey=42\n\n[Custom]\nFoo=bar\n",
+			update:   func(*client.FSOSettings) {},
+			expected: "; keep me\n[Default]\nLanguage=English\nUnknownKey=42\n\n[Custom]\nFoo=bar\n",
+		},
+		{
+			name:  "changed value",
+			input: "; keep me\n[Default]\nLanguage=English\nUnknownKey=42\n\n[Custom]\nFoo=bar\n",
+			update: func(settings *client.FSOSettings) {
+				settings.Default.Language = "German"
+			},
+			expected: "; keep me\n[Default]\nLanguage=German\nUnknownKey=42\n\n[Custom]\nFoo=bar\n",
+		},
+		{
+			name:  "bool and number",
+			input: "[Default]\nSpeechTechroom=0\nMaxFPS=60\n",
+			update: func(settings *client.FSOSettings) {
+				settings.Default.SpeechTechroom = true
+				settings.Default.MaxFPS = 144
+			},
+			expected: "[Default]\nSpeechTechroom=1\nMaxFPS=144\n",
+		},
+		{
+			name:  "new key",
+			input: "[Default]\nLanguage=English\n",
+			update: func(settings *client.FSOSettings) {
+				settings.Sound.PlaybackDevice = "OpenAL Soft"
+			},
+			expected: "[Default]\nLanguage=English\n\n[Sound]\nPlaybackDevice=OpenAL Soft\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := testContext()
+			prefPath := t.TempDir()
+			iniPath := filepath.Join(prefPath, "fs2_open.ini")
+			err := os.WriteFile(iniPath, []byte(test.input), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			settings, err := LoadSettings(ctx, prefPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.update(settings)
+			err = SaveSettings(ctx, prefPath, settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := os.ReadFile(iniPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(result) != test.expected {
+				t.Fatalf("expected %q but got %q", test.expected, string(result))
+			}
+		})
+	}
+}
+
+func TestSaveSettingsRejectsNil(t *testing.T) {
+	t.Parallel()
+
+	prefPath := t.TempDir()
+	err := SaveSettings(testContext(), prefPath, nil)
+	if err == nil {
+		t.Fatal("expected an error for nil settings")
+	}
+
+	_, err = os.Stat(filepath.Join(prefPath, "fs2_open.ini"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected no fs2_open.ini to be written but got %v", err)
+	}
+}
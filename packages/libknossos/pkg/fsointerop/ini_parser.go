@@ -3,7 +3,6 @@ package fsointerop
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -15,238 +14,133 @@ import (
 	"github.com/rotisserie/eris"
 )
 
-func readUntil(f io.RuneScanner, stop rune) (string, error) {
-	buffer := make([]rune, 0, 32)
-	for {
-		char, _, err := f.ReadRune()
-		if err != nil {
-			return "", eris.Wrap(err, "failed to read rune")
-		}
+func findSection(dest reflect.Value, name string) (reflect.Value, bool) {
+	fieldType, ok := dest.Type().FieldByName(name)
+	if !ok || !fieldType.IsExported() || fieldType.Type.Kind() != reflect.Ptr {
+		return reflect.Value{}, false
+	}
 
-		if char == stop {
-			return string(buffer), nil
-		}
+	return dest.FieldByIndex(fieldType.Index), true
+}
 
-		buffer = append(buffer, char)
+func findField(section reflect.Type, key string) (reflect.StructField, bool) {
+	fieldType, ok := section.FieldByName(key)
+	if ok && fieldType.IsExported() {
+		return fieldType, true
 	}
-}
 
-func skipWhitespace(f io.RuneScanner) error {
-	for {
-		char, _, err := f.ReadRune()
-		if err != nil {
-			if eris.Is(err, io.EOF) {
-				// There's no point in wrapping this error
-				//nolint:wrapcheck
-				return err
-			}
-			return eris.Wrap(err, "failed to read rune")
+	for idx := 0; idx < section.NumField(); idx++ {
+		field := section.Field(idx)
+		if field.IsExported() && strings.SplitN(field.Tag.Get("json"), ",", 2)[0] == key {
+			return field, true
 		}
+	}
 
-		switch char {
-		case ' ', '\t', '\n', '\r':
-			// do nothing
-		default:
-			err = f.UnreadRune()
-			if err != nil {
-				return eris.Wrap(err, "failed to queue rune back")
-			}
+	return reflect.StructField{}, false
+}
 
-			return nil
+// iniKeyForField returns the key used for the passed field in the document. Existing keys that match the field's JSON
+// name are reused, new keys are named after the field.
+func iniKeyForField(doc *iniDocument, section string, field reflect.StructField) string {
+	jsonName := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if jsonName != "" && jsonName != field.Name {
+		if _, ok := doc.Get(section, jsonName); ok {
+			return jsonName
 		}
 	}
+
+	return field.Name
 }
 
-func parseFile(ctx context.Context, f io.RuneScanner, dest interface{}) error {
+func loadDocument(ctx context.Context, doc *iniDocument, dest *client.FSOSettings) {
 	destVal := reflect.ValueOf(dest).Elem()
-	if destVal.Kind() != reflect.Struct {
-		panic("expected dest to be a struct")
-	}
 
-	var section reflect.Value
-	for {
-		char, _, err := f.ReadRune()
-		if err != nil {
-			if eris.Is(err, io.EOF) {
-				return nil
-			}
-			return eris.Wrap(err, "failed to read rune")
+	doc.Each(func(sectionName, key, value string) {
+		section, ok := findSection(destVal, sectionName)
+		if !ok {
+			api.Log(ctx, api.LogWarn, "fs2_open.ini: found unknown section %s", sectionName)
+			return
 		}
 
-		switch char {
-		case '[':
-			label, err := readUntil(f, ']')
-			if err != nil {
-				return err
-			}
-
-			section = destVal.FieldByName(label)
-			if !section.IsValid() {
-				api.Log(ctx, api.LogWarn, "fs2_open.ini: found unknown key %s", label)
-				skipWhitespace(f)
-				continue
-			}
+		if section.IsNil() {
+			section.Set(reflect.New(section.Type().Elem()))
+		}
+		section = section.Elem()
 
-			if section.IsNil() {
-				section.Set(reflect.New(section.Type().Elem()))
-			}
-			section = section.Elem()
+		fieldType, ok := findField(section.Type(), key)
+		if !ok {
+			api.Log(ctx, api.LogWarn, "fs2_open.ini: found unknown key %s", key)
+			return
+		}
 
-			err = skipWhitespace(f)
-			if err != nil {
-				return err
-			}
-		case '#', ';':
-			_, err = readUntil(f, '\n')
-			if err != nil {
-				return err
-			}
-		case ' ', '\t', '\n', '\r':
-			err = skipWhitespace(f)
+		field := section.FieldByIndex(fieldType.Index)
+		switch field.Type().Kind() {
+		case reflect.String:
+			field.Set(reflect.ValueOf(value))
+		case reflect.Uint32:
+			num, err := strconv.Atoi(value)
 			if err != nil {
-				if eris.Is(err, io.EOF) {
-					return nil
+				if value != "No Joystick" {
+					api.Log(ctx, api.LogWarn, "fs2_open.ini: failed to parse value %s for key %s", value, key)
 				}
-				return err
+			} else {
+				field.Set(reflect.ValueOf(uint32(num)))
 			}
-		default:
-			err = f.UnreadRune()
+		case reflect.Bool:
+			num, err := strconv.Atoi(value)
 			if err != nil {
-				return eris.Wrap(err, "failed to push rune back on stack")
-			}
-
-			line, err := readUntil(f, '\n')
-			if err != nil {
-				if eris.Is(err, io.EOF) {
-					return nil
-				}
-				return err
-			}
-
-			if !section.IsValid() {
-				return eris.Errorf("found line \"%s\" before any section", line)
-			}
-
-			parts := strings.SplitN(line, "=", 2)
-			key := strings.Trim(parts[0], " \r\n\t")
-			value := parts[1]
-			pos := strings.Index(value, "#")
-			if pos > -1 {
-				value = value[:pos]
-			}
-
-			pos = strings.Index(value, ";")
-			if pos > -1 {
-				value = value[:pos]
-			}
-
-			value = strings.Trim(value, " \r\n\t")
-
-			st := section.Type()
-			fieldType, ok := st.FieldByName(key)
-			if !ok {
-				for idx := 0; idx < st.NumField(); idx++ {
-					field := st.Field(idx)
-					if strings.SplitN(field.Tag.Get("json"), ",", 2)[0] == key {
-						fieldType = field
-						ok = true
-						break
-					}
-				}
-
-				if !ok {
-					api.Log(ctx, api.LogWarn, "fs2_open.ini: found unknown key %s", key)
-					continue
-				}
-			}
-
-			field := section.FieldByName(fieldType.Name)
-			switch field.Type().Kind() {
-			case reflect.String:
-				field.Set(reflect.ValueOf(value))
-			case reflect.Uint32:
-				num, err := strconv.Atoi(value)
-				if err != nil {
-					if value != "No Joystick" {
-						api.Log(ctx, api.LogWarn, "fs2_open.ini: failed to parse value %s for key %s", value, key)
-					}
-				} else {
-					field.Set(reflect.ValueOf(uint32(num)))
-				}
-			case reflect.Bool:
-				num, err := strconv.Atoi(value)
-				if err != nil {
-					api.Log(ctx, api.LogWarn, "fs2_open.ini: failed to parse value %s for key %s", value, key)
-				} else {
-					field.Set(reflect.ValueOf(num > 0))
-				}
-			default:
-				panic(fmt.Sprintf("unexpected type %s for field %s", field.Type().Name(), fieldType.Name))
+				api.Log(ctx, api.LogWarn, "fs2_open.ini: failed to parse value %s for key %s", value, key)
+			} else {
+				field.Set(reflect.ValueOf(num > 0))
 			}
+		default:
+			panic(fmt.Sprintf("unexpected type %s for field %s", field.Type().Name(), fieldType.Name))
 		}
-	}
+	})
 }
 
-func LoadSettings(ctx context.Context) (*client.FSOSettings, error) {
-	var settings client.FSOSettings
-	// assign defaults
-
-	settings.Default = &client.FSOSettings_DefaultSettings{
-		GammaD3D:      "1.0",
-		Language:      "English",
-		SpeechVolume:  100,
-		TextureFilter: 1,
-	}
-	settings.Sound = &client.FSOSettings_SoundSettings{
-		SampleRate: "441000",
-	}
-	settings.ForceFeedback = &client.FSOSettings_ForceFeedbackSettings{
-		Strength: 100,
-	}
-	settings.PXO = &client.FSOSettings_PXOSettings{}
-
-	iniPath := filepath.Join(GetPrefPath(ctx), "fs2_open.ini")
-	data, err := os.ReadFile(iniPath)
-	if err != nil {
-		// If the file doesn't exist, just return the default settings.
-		if eris.Is(err, os.ErrNotExist) {
-			return &settings, nil
+func formatINIValue(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case int32, uint32:
+		return fmt.Sprintf("%d", value), true
+	case bool:
+		if value {
+			return "1", true
 		}
-
-		return nil, eris.Wrapf(err, "failed to read %s", iniPath)
+		return "0", true
+	default:
+		return "", false
 	}
+}
 
-	buffer := strings.NewReader(string(data))
-
-	err = parseFile(ctx, buffer, &settings)
-	if err != nil {
-		return nil, eris.Wrapf(err, "failed to parse %s", iniPath)
+// updateDocument writes every value that differs between current and settings to the document. If writeAll is set,
+// all values are written regardless.
+func updateDocument(doc *iniDocument, current, settings *client.FSOSettings, writeAll bool) error {
+	if settings == nil || current == nil {
+		return eris.New("missing settings")
 	}
 
-	return &settings, nil
-}
-
-func SaveSettings(ctx context.Context, settings *client.FSOSettings) error {
-	buffer := strings.Builder{}
 	value := reflect.ValueOf(settings).Elem()
+	currentValue := reflect.ValueOf(current).Elem()
 	settingsType := value.Type()
 
 	for idx := 0; idx < settingsType.NumField(); idx++ {
 		sectionField := settingsType.Field(idx)
-		if !sectionField.IsExported() {
+		if !sectionField.IsExported() || sectionField.Type.Kind() != reflect.Ptr {
 			continue
 		}
 
 		sectionValues := value.Field(idx).Elem()
 		if !sectionValues.IsValid() {
-			api.Log(ctx, api.LogWarn, "Couldn't read %s", sectionField.Name)
 			continue
 		}
 
-		if idx > 0 {
-			buffer.WriteString("\n")
+		currentValues := currentValue.Field(idx).Elem()
+		if !currentValues.IsValid() {
+			currentValues = reflect.New(sectionField.Type.Elem()).Elem()
 		}
-		buffer.WriteString(fmt.Sprintf("[%s]\n", sectionField.Name))
 
 		sectionType := sectionValues.Type()
 		for f := 0; f < sectionType.NumField(); f++ {
@@ -255,29 +149,92 @@ func SaveSettings(ctx context.Context, settings *client.FSOSettings) error {
 				continue
 			}
 
-			buffer.WriteString(field.Name)
-			buffer.WriteString("=")
-
-			switch value := sectionValues.Field(f).Interface().(type) {
-			case string:
-				buffer.WriteString(value)
-			case int32, uint32:
-				buffer.WriteString(fmt.Sprintf("%d", value))
-			case bool:
-				if value {
-					buffer.WriteString("1")
-				} else {
-					buffer.WriteString("0")
-				}
-			default:
+			newValue, ok := formatINIValue(sectionValues.Field(f).Interface())
+			if !ok {
 				return eris.Errorf("discovered unsupported type %s in field %s in section %s", sectionValues.Field(f).String(), field.Name, sectionField.Name)
 			}
-			buffer.WriteString("\n")
+
+			oldValue, _ := formatINIValue(currentValues.Field(f).Interface())
+			if writeAll || newValue != oldValue {
+				doc.Set(sectionField.Name, iniKeyForField(doc, sectionField.Name, field), newValue)
+			}
+		}
+	}
+
+	return nil
+}
+
+func defaultSettings() *client.FSOSettings {
+	return &client.FSOSettings{
+		Default: &client.FSOSettings_DefaultSettings{
+			GammaD3D:      "1.0",
+			Language:      "English",
+			SpeechVolume:  100,
+			TextureFilter: 1,
+		},
+		Sound: &client.FSOSettings_SoundSettings{
+			SampleRate: "441000",
+		},
+		ForceFeedback: &client.FSOSettings_ForceFeedbackSettings{
+			Strength: 100,
+		},
+		PXO: &client.FSOSettings_PXOSettings{},
+	}
+}
+
+// LoadSettings reads the fs2_open.ini in the passed pref path
+func LoadSettings(ctx context.Context, prefPath string) (*client.FSOSettings, error) {
+	settings := defaultSettings()
+
+	iniPath := filepath.Join(prefPath, "fs2_open.ini")
+	data, err := os.ReadFile(iniPath)
+	if err != nil {
+		// If the file doesn't exist, just return the default settings.
+		if eris.Is(err, os.ErrNotExist) {
+			return settings, nil
 		}
+
+		return nil, eris.Wrapf(err, "failed to read %s", iniPath)
+	}
+
+	loadDocument(ctx, parseINIDocument(string(data)), settings)
+	return settings, nil
+}
+
+// SaveSettings writes the passed settings to the fs2_open.ini in the passed pref path. Only values that changed are
+// updated; unknown sections, keys and comments are preserved.
+func SaveSettings(ctx context.Context, prefPath string, settings *client.FSOSettings) error {
+	if settings == nil {
+		return eris.New("no settings passed")
+	}
+
+	iniPath := filepath.Join(prefPath, "fs2_open.ini")
+	data, err := os.ReadFile(iniPath)
+	if err != nil && !eris.Is(err, os.ErrNotExist) {
+		return eris.Wrapf(err, "failed to read %s", iniPath)
+	}
+
+	doc := parseINIDocument(string(data))
+	current := defaultSettings()
+	loadDocument(ctx, doc, current)
+
+	// A new file should contain all settings, not just the ones that differ from the defaults.
+	err = updateDocument(doc, current, settings, len(data) == 0)
+	if err != nil {
+		return err
+	}
+
+	encoded := doc.String()
+	if len(data) > 0 && encoded == string(data) {
+		return nil
+	}
+
+	err = os.MkdirAll(prefPath, 0o770)
+	if err != nil {
+		return eris.Wrapf(err, "failed to create %s", prefPath)
 	}
 
-	iniPath := filepath.Join(GetPrefPath(ctx), "fs2_open.ini")
-	err := os.WriteFile(iniPath, []byte(buffer.String()), 0o600)
+	err = os.WriteFile(iniPath, []byte(encoded), 0o600)
 	if err != nil {
 		return eris.Wrapf(err, "failed to write %s", iniPath)
 	}
@@ -0,0 +1,175 @@
+package fsointerop
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+const (
+	// number of lines before and after a match that are included in an issue's excerpt
+	excerptContext = 2
+	// number of lines at the end of the log that are returned with every diagnosis
+	logTailLines = 30
+	// stop collecting issues after this many to keep the response readable
+	maxLogIssues = 20
+)
+
+type logPattern struct {
+	pattern *regexp.Regexp
+	kind    client.CrashDiagnosis_Issue_Kind
+	summary string
+}
+
+// The order matters: the first matching pattern determines the kind of an issue.
+var logPatterns = []logPattern{
+	{
+		pattern: regexp.MustCompile(`(?i)(opengl|gl context|glad|sdl_gl).*(fail|unable|could ?n[o']t|error|not supported)|(fail|unable|could ?n[o']t).*(opengl|gl context)`),
+		kind:    client.CrashDiagnosis_Issue_OPENGL_INIT,
+		summary: "OpenGL could not be initialised. Make sure your graphics drivers are up to date.",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)\.(tbl|tbm)\(line ?\d+\)|required token|error parsing|parse error|missing required token`),
+		kind:    client.CrashDiagnosis_Issue_TABLE_ERROR,
+		summary: "A table file could not be parsed. This usually means that a mod is broken or incompatible with the selected engine version.",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)assert(ion)?( failed)?:|assertion failed`),
+		kind:    client.CrashDiagnosis_Issue_ASSERTION,
+		summary: "FSO hit a failed assertion. This is a bug in either the engine or the mod.",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)(unable to|could ?n[o']t|failed to) (find|open|load|locate)|file not found|no such file|missing file`),
+		kind:    client.CrashDiagnosis_Issue_MISSING_FILE,
+		summary: "A file could not be found. The mod might be incomplete; try verifying its integrity.",
+	},
+}
+
+// GetDebugLogPath returns the path to the fs2_open.log written by FSO into the passed pref path
+func GetDebugLogPath(prefPath string) string {
+	return filepath.Join(prefPath, "data", "fs2_open.log")
+}
+
+// AnalyzeLog scans the FSO log at the passed path for known error patterns
+func AnalyzeLog(ctx context.Context, logPath string) (*client.CrashDiagnosis, error) {
+	diag := &client.CrashDiagnosis{
+		LogPath: logPath,
+		Issues:  make([]*client.CrashDiagnosis_Issue, 0),
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		if eris.Is(err, os.ErrNotExist) {
+			return diag, nil
+		}
+
+		return nil, eris.Wrapf(err, "failed to open %s", logPath)
+	}
+	defer f.Close()
+
+	diag.LogFound = true
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s", logPath)
+	}
+
+	seen := make(map[string]bool)
+	for idx, line := range lines {
+		if len(diag.Issues) >= maxLogIssues {
+			api.Log(ctx, api.LogInfo, "Found more than %d issues in %s, skipping the rest", maxLogIssues, logPath)
+			break
+		}
+
+		for _, pat := range logPatterns {
+			if !pat.pattern.MatchString(line) {
+				continue
+			}
+
+			// Some messages are repeated for every frame; only report them once.
+			key := strings.TrimSpace(line)
+			if seen[key] {
+				break
+			}
+			seen[key] = true
+
+			start := idx - excerptContext
+			if start < 0 {
+				start = 0
+			}
+			end := idx + excerptContext + 1
+			if end > len(lines) {
+				end = len(lines)
+			}
+
+			diag.Issues = append(diag.Issues, &client.CrashDiagnosis_Issue{
+				Kind:    pat.kind,
+				Summary: pat.summary,
+				Excerpt: strings.Join(lines[start:end], "\n"),
+				Line:    uint32(idx + 1),
+			})
+			break
+		}
+	}
+
+	tailStart := len(lines) - logTailLines
+	if tailStart < 0 {
+		tailStart = 0
+	}
+	diag.LogTail = strings.Join(lines[tailStart:], "\n")
+
+	return diag, nil
+}
+
+// FindCrashDumps returns all crash dumps in the passed folders that were written after since
+func FindCrashDumps(ctx context.Context, folders []string, since time.Time) []string {
+	result := make([]string, 0)
+	for _, folder := range folders {
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			if !eris.Is(err, os.ErrNotExist) {
+				api.Log(ctx, api.LogWarn, "Failed to look for crash dumps in %s: %s", folder, err)
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			name := entry.Name()
+			switch strings.ToLower(filepath.Ext(name)) {
+			case ".mdmp", ".dmp":
+			default:
+				if name != "core" && !strings.HasPrefix(name, "core.") {
+					continue
+				}
+			}
+
+			info, err := entry.Info()
+			if err != nil || info.ModTime().Before(since) {
+				continue
+			}
+
+			result = append(result, filepath.Join(folder, name))
+		}
+	}
+
+	return result
+}
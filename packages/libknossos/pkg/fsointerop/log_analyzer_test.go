@@ -0,0 +1,231 @@
+package fsointerop
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+// cleanLogStart is the beginning of a log written by a successful FSO startup. None of these lines should be reported.
+const cleanLogStart = `FreeSpace 2 Open version: 21.4.1
+Passed cmdline options:
+  -mod mediavps_2022
+  -window
+Building file index...
+Found root pack 'mediavps_2022/MV_Core.vp' with a checksum of 0xd5c8bfcb
+Searching root pack 'mediavps_2022/MV_Core.vp' ... 2712 files
+Initializing OpenGL graphics device at 1920x1080 with 32-bit color...
+  Requested SDL Pixel values = R: 8, G: 8, B: 8, depth: 24, stencil: 8, double-buffer: 1, FSAA: 0
+  OpenGL Vendor    : NVIDIA Corporation
+  OpenGL Renderer  : NVIDIA GeForce GTX 1070/PCIe/SSE2
+  OpenGL Version   : 4.6.0 NVIDIA 470.199.02
+  Using extension "GL_ARB_texture_compression_bptc".
+Initializing OpenAL...
+  OpenAL Vendor     : OpenAL Community
+  Sample rate       : 44100 (44100)
+`
+
+func TestAnalyzeLog(t *testing.T) {
+	t.Parallel()
+
+	type issue struct {
+		kind    client.CrashDiagnosis_Issue_Kind
+		line    uint32
+		excerpt string
+	}
+
+	tests := []struct {
+		name   string
+		log    string
+		issues []issue
+	}{
+		{
+			name: "clean log",
+			log:  cleanLogStart + "Game exited cleanly.\n",
+		},
+		{
+			name: "OpenGL context",
+			log: `Initializing OpenGL graphics device at 1920x1080 with 32-bit color...
+  Requested SDL Pixel values = R: 8, G: 8, B: 8, depth: 24, stencil: 8, double-buffer: 1, FSAA: 0
+Could not create OpenGL Context: Couldn't find matching GLX visual
+SDL: Quitting subsystems
+`,
+			issues: []issue{{
+				kind: client.CrashDiagnosis_Issue_OPENGL_INIT,
+				line: 3,
+				excerpt: `Initializing OpenGL graphics device at 1920x1080 with 32-bit color...
+  Requested SDL Pixel values = R: 8, G: 8, B: 8, depth: 24, stencil: 8, double-buffer: 1, FSAA: 0
+Could not create OpenGL Context: Couldn't find matching GLX visual
+SDL: Quitting subsystems`,
+			}},
+		},
+		{
+			name: "table error",
+			log: `Loading ships.tbl
+TBM  =>  Starting parse of 'mv_core-shp.tbm' ...
+Error: mv_core-shp.tbm(line 1287):
+Error: Required token = [$Name:], found [$Nmae:   GTF Ulysses].
+ERROR: Table parse failed, aborting.
+`,
+			issues: []issue{
+				{
+					kind: client.CrashDiagnosis_Issue_TABLE_ERROR,
+					line: 3,
+					excerpt: `Loading ships.tbl
+TBM  =>  Starting parse of 'mv_core-shp.tbm' ...
+Error: mv_core-shp.tbm(line 1287):
+Error: Required token = [$Name:], found [$Nmae:   GTF Ulysses].
+ERROR: Table parse failed, aborting.`,
+				},
+				{
+					kind: client.CrashDiagnosis_Issue_TABLE_ERROR,
+					line: 4,
+					excerpt: `TBM  =>  Starting parse of 'mv_core-shp.tbm' ...
+Error: mv_core-shp.tbm(line 1287):
+Error: Required token = [$Name:], found [$Nmae:   GTF Ulysses].
+ERROR: Table parse failed, aborting.`,
+				},
+			},
+		},
+		{
+			name: "assertion",
+			log: `Starting mission 'sm1-01.fs2'
+ASSERTION: "sip->model_num >= 0" at ship.cpp:6123
+`,
+			issues: []issue{{
+				kind: client.CrashDiagnosis_Issue_ASSERTION,
+				line: 2,
+				excerpt: `Starting mission 'sm1-01.fs2'
+ASSERTION: "sip->model_num >= 0" at ship.cpp:6123`,
+			}},
+		},
+		{
+			name: "missing file",
+			log: `Loading model 'fighter2t-05.pof' into slot '12'
+Warning: Unable to find texture 'fighter2t-05-glow' for model 'fighter2t-05.pof'
+Loading model 'bomber2t-01.pof' into slot '13'
+`,
+			issues: []issue{{
+				kind: client.CrashDiagnosis_Issue_MISSING_FILE,
+				line: 2,
+				excerpt: `Loading model 'fighter2t-05.pof' into slot '12'
+Warning: Unable to find texture 'fighter2t-05-glow' for model 'fighter2t-05.pof'
+Loading model 'bomber2t-01.pof' into slot '13'`,
+			}},
+		},
+		{
+			name: "first matching pattern wins",
+			log:  "Error: weapons.tbl(line 42): Unable to find weapon 'Subach HL-9'\n",
+			issues: []issue{{
+				kind:    client.CrashDiagnosis_Issue_TABLE_ERROR,
+				line:    1,
+				excerpt: "Error: weapons.tbl(line 42): Unable to find weapon 'Subach HL-9'",
+			}},
+		},
+		{
+			name: "repeated lines are reported once",
+			log: `Frame 1
+Warning: Unable to find texture 'nebula01' for model 'nebula.pof'
+Frame 2
+  Warning: Unable to find texture 'nebula01' for model 'nebula.pof'
+`,
+			issues: []issue{{
+				kind: client.CrashDiagnosis_Issue_MISSING_FILE,
+				line: 2,
+				excerpt: `Frame 1
+Warning: Unable to find texture 'nebula01' for model 'nebula.pof'
+Frame 2
+  Warning: Unable to find texture 'nebula01' for model 'nebula.pof'`,
+			}},
+		},
+		{
+			name: "crlf",
+			log:  "Starting mission 'sm1-01.fs2'\r\nASSERTION: \"objp != nullptr\" at object.cpp:812\r\n",
+			issues: []issue{{
+				kind:    client.CrashDiagnosis_Issue_ASSERTION,
+				line:    2,
+				excerpt: "Starting mission 'sm1-01.fs2'\nASSERTION: \"objp != nullptr\" at object.cpp:812",
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			logPath := filepath.Join(t.TempDir(), "fs2_open.log")
+			err := os.WriteFile(logPath, []byte(test.log), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diag, err := AnalyzeLog(testContext(), logPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !diag.LogFound {
+				t.Fatal("expected the log to be found")
+			}
+
+			if len(diag.Issues) != len(test.issues) {
+				t.Fatalf("expected %d issues but got %v", len(test.issues), diag.Issues)
+			}
+
+			for idx, expected := range test.issues {
+				got := diag.Issues[idx]
+				if got.Kind != expected.kind {
+					t.Errorf("issue %d: expected kind %s but got %s", idx, expected.kind, got.Kind)
+				}
+				if got.Line != expected.line {
+					t.Errorf("issue %d: expected line %d but got %d", idx, expected.line, got.Line)
+				}
+				if got.Excerpt != expected.excerpt {
+					t.Errorf("issue %d: expected excerpt %q but got %q", idx, expected.excerpt, got.Excerpt)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeLogTail(t *testing.T) {
+	t.Parallel()
+
+	lines := make([]string, logTailLines+10)
+	for idx := range lines {
+		lines[idx] = "Frame " + strings.Repeat("x", idx)
+	}
+
+	logPath := filepath.Join(t.TempDir(), "fs2_open.log")
+	err := os.WriteFile(logPath, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diag, err := AnalyzeLog(testContext(), logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join(lines[len(lines)-logTailLines:], "\n")
+	if diag.LogTail != expected {
+		t.Fatalf("expected tail %q but got %q", expected, diag.LogTail)
+	}
+}
+
+func TestAnalyzeLogMissing(t *testing.T) {
+	t.Parallel()
+
+	diag, err := AnalyzeLog(testContext(), filepath.Join(t.TempDir(), "fs2_open.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diag.LogFound || len(diag.Issues) != 0 {
+		t.Fatalf("expected an empty diagnosis but got %v", diag)
+	}
+}
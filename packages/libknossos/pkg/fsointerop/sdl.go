@@ -2,13 +2,119 @@ package fsointerop
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
+	"github.com/rotisserie/eris"
 	"github.com/veandco/go-sdl2/sdl"
+
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
 )
 
+// PortableModeFlag makes FSO store its prefs in the working directory instead of the user's data folder
+const PortableModeFlag = "-portable_mode"
+
+// GetPrefPath returns the pref path shared by all mods which don't use an isolated profile
 func GetPrefPath(ctx context.Context) string {
 	// TODO: support portable mode
 
 	// See https://github.com/scp-fs2open/fs2open.github.com/blob/18754fafc138591d2edfd0bc88ae02a6807091b7/code/osapi/osapi.cpp#L44
 	return sdl.GetPrefPath("HardLightProductions", "FreeSpaceOpen")
 }
+
+// ValidatePrefProfile makes sure that the passed profile name can be used as a folder name
+func ValidatePrefProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	if profile == "." || profile == ".." || strings.ContainsAny(profile, "/\\:") {
+		return eris.Errorf("invalid profile name %q", profile)
+	}
+
+	return nil
+}
+
+// usePortableMode returns true if pref profiles have to use FSO's portable mode on this platform. SDL asks the OS for
+// the user's data folder on Windows and macOS and ignores the environment there.
+func usePortableMode() bool {
+	return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+}
+
+func getProfileDataHome(ctx context.Context, profile string) string {
+	return filepath.Join(api.SettingsPath(ctx), "prefs", profile)
+}
+
+// ListPrefProfiles returns the names of all profiles which have their own pref folder
+func ListPrefProfiles(ctx context.Context) ([]string, error) {
+	folder := filepath.Join(api.SettingsPath(ctx), "prefs")
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		if eris.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, eris.Wrapf(err, "failed to list %s", folder)
+	}
+
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			result = append(result, entry.Name())
+		}
+	}
+
+	return result, nil
+}
+
+// GetProfilePrefPath returns the pref path FSO uses when it's launched for the given profile. An empty profile
+// selects the shared pref path.
+func GetProfilePrefPath(ctx context.Context, profile string) string {
+	if profile == "" {
+		return GetPrefPath(ctx)
+	}
+
+	if usePortableMode() {
+		// In portable mode, FSO stores everything in its working directory
+		return getProfileDataHome(ctx, profile)
+	}
+
+	// This mirrors SDL_GetPrefPath()'s behaviour on Linux
+	return filepath.Join(getProfileDataHome(ctx, profile), "HardLightProductions", "FreeSpaceOpen")
+}
+
+// ProfileLaunchOptions describes how FSO has to be launched to use a pref profile
+type ProfileLaunchOptions struct {
+	// Env contains additional environment variables
+	Env []string
+	// Args contains additional command line arguments which have to be passed before any other arguments
+	Args []string
+	// Dir replaces FSO's working directory if it's not empty. FSO resolves the paths passed to -mod relative to it.
+	Dir string
+}
+
+// GetProfileLaunchOptions returns the options that make FSO use the pref path for the given profile. On Linux, FSO
+// is pointed to the profile through XDG_DATA_HOME. Other platforms use FSO's portable mode which keeps the prefs in
+// the working directory.
+func GetProfileLaunchOptions(ctx context.Context, profile string) (*ProfileLaunchOptions, error) {
+	if profile == "" {
+		return &ProfileLaunchOptions{}, nil
+	}
+
+	err := ValidatePrefProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if usePortableMode() {
+		return &ProfileLaunchOptions{
+			Args: []string{PortableModeFlag},
+			Dir:  getProfileDataHome(ctx, profile),
+		}, nil
+	}
+
+	return &ProfileLaunchOptions{
+		Env: []string{"XDG_DATA_HOME=" + getProfileDataHome(ctx, profile)},
+	}, nil
+}
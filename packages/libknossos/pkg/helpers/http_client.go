@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/cookiejar"
+	"strings"
 	"time"
 
 	"github.com/ngld/knossos/packages/libknossos/pkg/api"
@@ -34,6 +35,8 @@ func Init(ctx context.Context) error {
 	return nil
 }
 
+// CachedGet sends a conditional GET request for url if we've fetched it before. If the server responds with 304, the
+// caller should reuse the data from the previous response. The cache entry is only updated for successful responses.
 func CachedGet(ctx context.Context, url string) (*http.Response, error) {
 	cacheEntry, err := storage.GetHTTPCacheEntryForURL(ctx, url)
 	if err != nil {
@@ -46,21 +49,49 @@ func CachedGet(ctx context.Context, url string) (*http.Response, error) {
 	}
 
 	if cacheEntry != nil {
-		req.Header.Set("If-None-Match", cacheEntry.ETag)
-		req.Header.Set("If-Modified-Since", cacheEntry.FetchDate)
+		if cacheEntry.ETag != "" {
+			req.Header.Set("If-None-Match", cacheEntry.ETag)
+		}
+		if cacheEntry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cacheEntry.LastModified)
+		}
 	}
 
 	res, err := HTTPDo(ctx, req)
 	if err != nil {
+		storage.RecordHTTPCacheResult(storage.HTTPCacheError)
 		return nil, err
 	}
 
-	err = storage.SetHTTPCacheEntryForURL(ctx, url, &storage.HTTPCacheEntry{
-		ETag:      res.Header.Get("ETag"),
-		FetchDate: res.Header.Get("Date"),
-	})
-	if err != nil {
-		return nil, err
+	switch {
+	case res.StatusCode == http.StatusNotModified:
+		storage.RecordHTTPCacheResult(storage.HTTPCacheHit)
+	case res.StatusCode == http.StatusOK:
+		storage.RecordHTTPCacheResult(storage.HTTPCacheMiss)
+
+		etag := res.Header.Get("ETag")
+		lastModified := res.Header.Get("Last-Modified")
+		if (etag == "" && lastModified == "") || strings.Contains(res.Header.Get("Cache-Control"), "no-store") {
+			// Don't keep validators which don't match the current response
+			if cacheEntry != nil {
+				err = storage.DeleteHTTPCacheEntryForURL(ctx, url)
+			}
+		} else {
+			now := time.Now()
+			err = storage.SetHTTPCacheEntryForURL(ctx, url, &storage.HTTPCacheEntry{
+				LastAccessed: now,
+				Fetched:      now,
+				LastModified: lastModified,
+				ETag:         etag,
+			})
+		}
+
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	default:
+		storage.RecordHTTPCacheResult(storage.HTTPCacheError)
 	}
 
 	return res, nil
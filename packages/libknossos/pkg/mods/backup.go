@@ -0,0 +1,481 @@
+package mods
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rotisserie/eris"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/fsointerop"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+// LibraryBackupVersion is the format version written by ExportLibrary. Backups with a higher version are rejected.
+const LibraryBackupVersion = 1
+
+// Names of the files inside a library backup
+const (
+	backupStateFile    = "backup.json"
+	backupManifestFile = "manifest.json"
+	backupINIFile      = "fs2_open.ini"
+	// the fs2_open.ini of each isolated pref profile is stored as prefs/<profile>/fs2_open.ini
+	backupProfilePrefix = "prefs/"
+)
+
+func buildLibraryBackup(ctx context.Context, includeManifest bool) (*client.LibraryBackup, *client.LibraryManifest, error) {
+	settings, err := storage.GetSettings(ctx)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to load settings")
+	}
+
+	customEngines, err := storage.GetCustomEngines(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	profiles, err := storage.GetFSOSettingsProfiles(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backup := &client.LibraryBackup{
+		FormatVersion:       LibraryBackupVersion,
+		Created:             timestamppb.Now(),
+		Settings:            settings,
+		Mods:                make([]*client.LibraryBackup_ModState, 0),
+		CustomEngines:       customEngines,
+		FsoSettingsProfiles: profiles,
+	}
+
+	var manifest *client.LibraryManifest
+	if includeManifest {
+		manifest = &client.LibraryManifest{
+			Releases: make([]*client.LibraryManifest_Release, 0),
+		}
+	}
+
+	isCustomEngine := make(map[string]bool)
+	for _, engine := range customEngines {
+		isCustomEngine[engine.Modid] = true
+	}
+
+	releases, err := storage.LocalMods.GetAllReleases(ctx)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to load local releases")
+	}
+
+	for _, rel := range releases {
+		userSettings, err := storage.GetUserSettingsForMod(ctx, rel.Modid, rel.Version)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		backup.Mods = append(backup.Mods, &client.LibraryBackup_ModState{
+			Modid:              rel.Modid,
+			Version:            rel.Version,
+			UserSettings:       userSettings,
+			DependencySnapshot: rel.DependencySnapshot,
+			SnapshotModified:   rel.SnapshotModified,
+		})
+
+		// Custom engines are local builds which can't be installed; their registrations are part of the backup instead.
+		if manifest != nil && !isCustomEngine[rel.Modid] {
+			pkgs := make([]string, len(rel.Packages))
+			for idx, pkg := range rel.Packages {
+				pkgs[idx] = pkg.Name
+			}
+
+			manifest.Releases = append(manifest.Releases, &client.LibraryManifest_Release{
+				Modid:    rel.Modid,
+				Version:  rel.Version,
+				Packages: pkgs,
+			})
+		}
+	}
+
+	return backup, manifest, nil
+}
+
+func writeZIPEntry(archive *zip.Writer, name string, data []byte) error {
+	writer, err := archive.Create(name)
+	if err != nil {
+		return eris.Wrapf(err, "failed to add %s to backup", name)
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		return eris.Wrapf(err, "failed to write %s to backup", name)
+	}
+
+	return nil
+}
+
+// readINIFiles returns the fs2_open.ini of the shared pref path (as "") and of every pref profile
+func readINIFiles(ctx context.Context) (map[string][]byte, error) {
+	profiles, err := fsointerop.ListPrefProfiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte)
+	for _, profile := range append([]string{""}, profiles...) {
+		iniPath := filepath.Join(fsointerop.GetProfilePrefPath(ctx, profile), "fs2_open.ini")
+		data, err := os.ReadFile(iniPath)
+		if err == nil {
+			result[profile] = data
+		} else if !eris.Is(err, os.ErrNotExist) {
+			return nil, eris.Wrapf(err, "failed to read %s", iniPath)
+		}
+	}
+
+	return result, nil
+}
+
+// ExportLibrary writes the client state (settings, per-mod settings, dependency snapshots, custom engines, FSO settings
+// profiles and the fs2_open.ini of the shared pref path and every pref profile) to a ZIP archive at path. If
+// includeManifest is set, the archive also lists all installed releases.
+func ExportLibrary(ctx context.Context, path string, includeManifest bool) error {
+	backup, manifest, err := buildLibraryBackup(ctx, includeManifest)
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string][]byte)
+	entries[backupStateFile], err = json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return eris.Wrap(err, "failed to serialise backup")
+	}
+
+	if manifest != nil {
+		entries[backupManifestFile], err = json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return eris.Wrap(err, "failed to serialise manifest")
+		}
+	}
+
+	iniFiles, err := readINIFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	entryNames := []string{backupStateFile, backupManifestFile, backupINIFile}
+	profileEntries := make([]string, 0, len(iniFiles))
+	for profile, data := range iniFiles {
+		name := backupINIFile
+		if profile != "" {
+			name = backupProfilePrefix + profile + "/" + backupINIFile
+			profileEntries = append(profileEntries, name)
+		}
+		entries[name] = data
+	}
+	sort.Strings(profileEntries)
+	entryNames = append(entryNames, profileEntries...)
+
+	// Write to a temporary file first to avoid leaving a broken backup behind
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return eris.Wrapf(err, "failed to create %s", tmpPath)
+	}
+	defer os.Remove(tmpPath)
+
+	archive := zip.NewWriter(out)
+	for _, name := range entryNames {
+		data, ok := entries[name]
+		if !ok {
+			continue
+		}
+
+		err = writeZIPEntry(archive, name, data)
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		out.Close()
+		return eris.Wrapf(err, "failed to finish %s", tmpPath)
+	}
+
+	err = out.Close()
+	if err != nil {
+		return eris.Wrapf(err, "failed to close %s", tmpPath)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return eris.Wrapf(err, "failed to move backup to %s", path)
+	}
+
+	api.Log(ctx, api.LogInfo, "Saved state for %d releases to %s", len(backup.Mods), path)
+	return nil
+}
+
+func readZIPEntry(archive *zip.ReadCloser, name string) ([]byte, error) {
+	handle, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	data, err := io.ReadAll(handle)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s from backup", name)
+	}
+
+	return data, nil
+}
+
+// readBackupINIFiles returns the fs2_open.ini files in the backup indexed by their profile. The shared one uses "".
+func readBackupINIFiles(archive *zip.ReadCloser) (map[string][]byte, error) {
+	result := make(map[string][]byte)
+	for _, file := range archive.File {
+		var profile string
+		switch {
+		case file.Name == backupINIFile:
+		case strings.HasPrefix(file.Name, backupProfilePrefix) && strings.HasSuffix(file.Name, "/"+backupINIFile):
+			profile = strings.TrimSuffix(strings.TrimPrefix(file.Name, backupProfilePrefix), "/"+backupINIFile)
+			if profile == "" || strings.Contains(profile, "/") {
+				continue
+			}
+		default:
+			continue
+		}
+
+		data, err := readZIPEntry(archive, file.Name)
+		if err != nil {
+			return nil, err
+		}
+		result[profile] = data
+	}
+
+	return result, nil
+}
+
+// ReadLibraryBackup parses the backup at path. The manifest is nil if the backup doesn't contain one. The returned map
+// contains the backed up fs2_open.ini files indexed by their pref profile; the shared one uses "" as its key.
+func ReadLibraryBackup(path string) (*client.LibraryBackup, *client.LibraryManifest, map[string][]byte, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, nil, eris.Wrapf(err, "failed to open %s", path)
+	}
+	defer archive.Close()
+
+	data, err := readZIPEntry(archive, backupStateFile)
+	if err != nil {
+		return nil, nil, nil, eris.Wrapf(err, "%s is not a Knossos backup", path)
+	}
+
+	backup := new(client.LibraryBackup)
+	err = json.Unmarshal(data, backup)
+	if err != nil {
+		return nil, nil, nil, eris.Wrapf(err, "failed to parse %s in %s", backupStateFile, path)
+	}
+
+	if backup.FormatVersion < 1 || backup.FormatVersion > LibraryBackupVersion {
+		return nil, nil, nil, eris.Errorf("unsupported backup version %d (this Knossos supports up to %d)",
+			backup.FormatVersion, LibraryBackupVersion)
+	}
+
+	var manifest *client.LibraryManifest
+	data, err = readZIPEntry(archive, backupManifestFile)
+	if err == nil {
+		manifest = new(client.LibraryManifest)
+		err = json.Unmarshal(data, manifest)
+		if err != nil {
+			return nil, nil, nil, eris.Wrapf(err, "failed to parse %s in %s", backupManifestFile, path)
+		}
+	} else if !eris.Is(err, os.ErrNotExist) {
+		return nil, nil, nil, err
+	}
+
+	iniFiles, err := readBackupINIFiles(archive)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return backup, manifest, iniFiles, nil
+}
+
+// restoreINIFiles writes the fs2_open.ini files from a backup to the matching pref paths
+func restoreINIFiles(ctx context.Context, iniFiles map[string][]byte) error {
+	for profile, data := range iniFiles {
+		if profile != "" {
+			err := fsointerop.ValidatePrefProfile(profile)
+			if err != nil {
+				api.Log(ctx, api.LogWarn, "Skipping fs2_open.ini of pref profile %s: %s", profile, err)
+				continue
+			}
+		}
+
+		prefPath := fsointerop.GetProfilePrefPath(ctx, profile)
+		err := os.MkdirAll(prefPath, 0o770)
+		if err != nil {
+			return eris.Wrapf(err, "failed to create %s", prefPath)
+		}
+
+		iniPath := filepath.Join(prefPath, "fs2_open.ini")
+		err = os.WriteFile(iniPath, data, 0o600)
+		if err != nil {
+			return eris.Wrapf(err, "failed to write %s", iniPath)
+		}
+	}
+
+	return nil
+}
+
+// planManifestInstall returns an install request for all releases in the manifest which are missing locally. nil is
+// returned if everything is installed.
+func planManifestInstall(ctx context.Context, manifest *client.LibraryManifest) *client.InstallModRequest {
+	req := &client.InstallModRequest{
+		Mods: make([]*client.InstallModRequest_Mod, 0),
+	}
+
+	for _, item := range manifest.Releases {
+		_, err := storage.LocalMods.GetModRelease(ctx, item.Modid, item.Version)
+		if err == nil {
+			continue
+		}
+
+		_, err = storage.RemoteMods.GetModRelease(ctx, item.Modid, item.Version)
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "%s %s is missing and not available from Nebula", item.Modid, item.Version)
+			continue
+		}
+
+		req.Mods = append(req.Mods, &client.InstallModRequest_Mod{
+			Modid:    item.Modid,
+			Version:  item.Version,
+			Packages: item.Packages,
+		})
+	}
+
+	if len(req.Mods) == 0 {
+		return nil
+	}
+
+	return req
+}
+
+// applyModStates restores the per-mod settings and dependency snapshots for all releases which are installed
+func applyModStates(ctx context.Context, states []*client.LibraryBackup_ModState) error {
+	for _, state := range states {
+		rel, err := storage.LocalMods.GetModRelease(ctx, state.Modid, state.Version)
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "Skipping settings for %s %s since it's not installed", state.Modid,
+				state.Version)
+			continue
+		}
+
+		if state.UserSettings != nil {
+			err = storage.SaveUserSettingsForMod(ctx, state.Modid, state.Version, state.UserSettings)
+			if err != nil {
+				return err
+			}
+		}
+
+		if state.SnapshotModified {
+			rel.DependencySnapshot = state.DependencySnapshot
+			rel.SnapshotModified = true
+			err = SaveLocalModRelease(ctx, rel)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ImportLibrary restores the client state from the backup at path, relinks the mods in the library folder and
+// optionally installs the releases from the manifest which are missing. If libraryPath is empty, the library folder
+// from the backup is used.
+func ImportLibrary(ctx context.Context, path, libraryPath string, installMissing bool) error {
+	backup, manifest, iniFiles, err := ReadLibraryBackup(path)
+	if err != nil {
+		return err
+	}
+
+	settings := backup.Settings
+	if settings == nil {
+		settings = new(client.Settings)
+	}
+	if libraryPath != "" {
+		settings.LibraryPath = libraryPath
+	}
+
+	api.Log(ctx, api.LogInfo, "Restoring settings")
+	err = storage.SaveSettings(ctx, settings)
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range backup.FsoSettingsProfiles {
+		err = storage.SaveFSOSettingsProfile(ctx, profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = restoreINIFiles(ctx, iniFiles)
+	if err != nil {
+		return err
+	}
+
+	for _, engine := range backup.CustomEngines {
+		_, err = os.Stat(engine.Path)
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "Skipping custom engine %s since %s is missing", engine.Title, engine.Path)
+			continue
+		}
+
+		err = storage.SaveCustomEngine(ctx, engine)
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.LibraryPath == "" {
+		api.Log(ctx, api.LogWarn, "No library folder configured, skipping mods")
+		return nil
+	}
+
+	api.Log(ctx, api.LogInfo, "Scanning library folder %s", settings.LibraryPath)
+	err = ReloadLocalMods(ctx)
+	if err != nil {
+		return err
+	}
+
+	if installMissing && manifest != nil {
+		req := planManifestInstall(ctx, manifest)
+		if req != nil {
+			api.Log(ctx, api.LogInfo, "Installing %d missing releases", len(req.Mods))
+			err = InstallMod(ctx, req)
+			if err != nil {
+				return eris.Wrap(err, "failed to install missing releases")
+			}
+		} else {
+			api.Log(ctx, api.LogInfo, "All releases are already installed")
+		}
+	}
+
+	api.Log(ctx, api.LogInfo, "Restoring mod settings")
+	err = applyModStates(ctx, backup.Mods)
+	if err != nil {
+		return err
+	}
+
+	api.Log(ctx, api.LogInfo, "Restored backup from %s", path)
+	return nil
+}
@@ -0,0 +1,69 @@
+package mods
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/fsointerop"
+)
+
+var (
+	lastDiagnosisLock = sync.Mutex{}
+	lastDiagnosis     *client.CrashDiagnosis
+)
+
+func diagnoseCrash(ctx context.Context, info *client.RunningGame, workDir string, exitCode int) {
+	logPath := fsointerop.GetDebugLogPath(info.PrefPath)
+	diag, err := fsointerop.AnalyzeLog(ctx, logPath)
+	if err != nil {
+		api.Log(ctx, api.LogError, "Failed to analyze %s: %s", logPath, eris.ToString(err, true))
+		return
+	}
+
+	started := info.Started.AsTime()
+	if diag.LogFound {
+		stat, err := os.Stat(logPath)
+		if err == nil && stat.ModTime().Before(started) {
+			diag.Stale = true
+		}
+	}
+
+	diag.Game = info
+	diag.ExitCode = int32(exitCode)
+	diag.CrashDumps = fsointerop.FindCrashDumps(ctx, []string{workDir, filepath.Dir(logPath)}, started)
+
+	api.Log(ctx, api.LogInfo, "Found %d issues and %d crash dumps after %s crashed", len(diag.Issues), len(diag.CrashDumps), info.Modid)
+
+	lastDiagnosisLock.Lock()
+	lastDiagnosis = diag
+	lastDiagnosisLock.Unlock()
+}
+
+// GetCrashDiagnosis returns the diagnosis for the last game crash. If there was no crash since Knossos started or
+// reanalyze is true, the current FSO log (of the last crashed game's pref path, if there is one) is analyzed instead.
+func GetCrashDiagnosis(ctx context.Context, reanalyze bool) (*client.CrashDiagnosis, error) {
+	if !reanalyze {
+		lastDiagnosisLock.Lock()
+		diag := lastDiagnosis
+		lastDiagnosisLock.Unlock()
+
+		if diag != nil {
+			return diag, nil
+		}
+	}
+
+	prefPath := fsointerop.GetPrefPath(ctx)
+	lastDiagnosisLock.Lock()
+	if lastDiagnosis.GetGame().GetPrefPath() != "" {
+		prefPath = lastDiagnosis.Game.PrefPath
+	}
+	lastDiagnosisLock.Unlock()
+
+	return fsointerop.AnalyzeLog(ctx, fsointerop.GetDebugLogPath(prefPath))
+}
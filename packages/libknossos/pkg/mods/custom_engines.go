@@ -0,0 +1,386 @@
+package mods
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/rotisserie/eris"
+	"golang.org/x/sys/cpu"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/helpers"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+const customEnginePrefix = "custom-"
+
+var customEngineIDCleaner = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// customExePrefixes maps the file name prefixes of FSO's build targets to the labels used in mod.json
+var customExePrefixes = []struct {
+	prefix string
+	label  string
+}{
+	// qtfred has to be checked before fred2_open; neither is a prefix of the other but this keeps the order obvious
+	{"qtfred", "QtFRED"},
+	{"fred2_open", "FRED"},
+	{"fs2_open", ""},
+}
+
+// classifyCustomExecutable returns the executable entry for the given file name or nil if the file isn't an FSO binary
+// we can use on this system.
+func classifyCustomExecutable(name string) *common.EngineExecutable {
+	lower := strings.ToLower(name)
+	switch runtime.GOOS {
+	case "windows":
+		if !strings.HasSuffix(lower, ".exe") {
+			return nil
+		}
+		lower = strings.TrimSuffix(lower, ".exe")
+	case "darwin":
+		lower = strings.TrimSuffix(lower, ".app")
+	default:
+		for _, ext := range []string{".so", ".pdb", ".debug", ".dbg", ".a"} {
+			if strings.HasSuffix(lower, ext) {
+				return nil
+			}
+		}
+	}
+
+	label := ""
+	found := false
+	for _, item := range customExePrefixes {
+		if strings.HasPrefix(lower, item.prefix) {
+			label = item.label
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	exe := &common.EngineExecutable{Path: name}
+	if strings.Contains(lower, "debug") || strings.Contains(lower, "fastdbg") {
+		exe.Debug = true
+		if label == "" {
+			label = "FastDebug"
+		} else {
+			label += " FastDebug"
+		}
+	}
+	exe.Label = label
+
+	// Use the same priorities as the importer, see ImportMods()
+	tokens := strings.FieldsFunc(lower, func(r rune) bool { return r == '_' || r == '-' || r == '.' })
+	for _, token := range tokens {
+		switch token {
+		case "x64":
+			if !helpers.SupportsX64() {
+				return nil
+			}
+			exe.Priority += 50
+		case "avx2":
+			if !cpu.X86.HasAVX2 {
+				return nil
+			}
+			exe.Priority += 3
+		case "avx":
+			if !cpu.X86.HasAVX {
+				return nil
+			}
+			exe.Priority += 2
+		case "sse2":
+			exe.Priority++
+		}
+	}
+
+	return exe
+}
+
+// discoverCustomExecutables looks for FSO, FRED and qtFRED binaries in the passed build folder and its bin subfolder
+// (which is where CMake puts them). The returned paths are relative to folder.
+func discoverCustomExecutables(folder string) ([]*common.EngineExecutable, error) {
+	result := make([]*common.EngineExecutable, 0)
+	for _, sub := range []string{"", "bin"} {
+		entries, err := os.ReadDir(filepath.Join(folder, sub))
+		if err != nil {
+			if eris.Is(err, os.ErrNotExist) && sub != "" {
+				continue
+			}
+			return nil, eris.Wrapf(err, "failed to list %s", filepath.Join(folder, sub))
+		}
+
+		for _, entry := range entries {
+			exe := classifyCustomExecutable(entry.Name())
+			if exe == nil {
+				continue
+			}
+
+			relPath := filepath.Join(sub, entry.Name())
+			if runtime.GOOS == "darwin" {
+				if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".app") {
+					continue
+				}
+				relPath = filepath.Join(relPath, "Contents", "MacOS", strings.TrimSuffix(entry.Name(), ".app"))
+			} else if entry.IsDir() {
+				continue
+			}
+
+			info, err := os.Stat(filepath.Join(folder, relPath))
+			if err != nil || info.IsDir() {
+				continue
+			}
+			if runtime.GOOS != "windows" && info.Mode()&0o111 == 0 {
+				continue
+			}
+
+			exe.Path = relPath
+			result = append(result, exe)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// buildCustomEngineRelease turns a registered custom engine into the synthetic mod and release we keep in LocalMods
+func buildCustomEngineRelease(engine *client.CustomEngine) (*common.ModMeta, *common.Release) {
+	mod := &common.ModMeta{
+		Modid: engine.Modid,
+		Title: engine.Title,
+		Type:  common.ModType_ENGINE,
+		Tags:  []string{"custom"},
+	}
+
+	release := &common.Release{
+		Modid:       engine.Modid,
+		Version:     engine.Version,
+		Folder:      engine.Path,
+		Stability:   common.ReleaseStability_NIGHTLY,
+		Description: fmt.Sprintf("Custom build from %s", engine.Path),
+		Updated:     engine.Refreshed,
+		Packages: []*common.Package{{
+			Name:        "Executables",
+			Executables: engine.Executables,
+		}},
+	}
+
+	return mod, release
+}
+
+func saveCustomEngineRelease(ctx context.Context, engine *client.CustomEngine) error {
+	mod, release := buildCustomEngineRelease(engine)
+	err := storage.SaveLocalMod(ctx, mod)
+	if err != nil {
+		return err
+	}
+
+	return storage.SaveLocalModRelease(ctx, release)
+}
+
+// probeCustomEngine discovers the executables in the engine's folder and asks FSO for its version
+func probeCustomEngine(ctx context.Context, engine *client.CustomEngine) error {
+	executables, err := discoverCustomExecutables(engine.Path)
+	if err != nil {
+		return err
+	}
+	if len(executables) == 0 {
+		return eris.Errorf("no FSO executables found in %s", engine.Path)
+	}
+
+	engine.Executables = executables
+	_, release := buildCustomEngineRelease(engine)
+	binaryPath, err := getBinaryForEngine(ctx, release, "")
+	if err != nil {
+		return err
+	}
+
+	flags, err := getJSONFlagsForBinary(ctx, binaryPath)
+	if err != nil {
+		return eris.Wrapf(err, "failed to probe %s", binaryPath)
+	}
+
+	engine.Version = fmt.Sprintf("%d.%d.%d-custom", flags.Version.Major, flags.Version.Minor, flags.Version.Build)
+	engine.Refreshed = timestamppb.Now()
+	return nil
+}
+
+func newCustomEngineID(ctx context.Context, folder string) (string, error) {
+	base := customEngineIDCleaner.ReplaceAllString(strings.ToLower(filepath.Base(folder)), "-")
+	base = customEnginePrefix + strings.Trim(base, "-")
+
+	modID := base
+	for idx := 2; ; idx++ {
+		existing, err := storage.GetCustomEngine(ctx, modID)
+		if err != nil {
+			return "", err
+		}
+
+		if existing == nil {
+			_, err = storage.LocalMods.GetMod(ctx, modID)
+			if err != nil {
+				// nothing else uses this ID
+				return modID, nil
+			}
+		}
+
+		modID = fmt.Sprintf("%s-%d", base, idx)
+	}
+}
+
+// RegisterCustomEngine registers the FSO build in folder as a synthetic engine release which can be selected for any mod
+func RegisterCustomEngine(ctx context.Context, folder, title string) (*client.CustomEngine, error) {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to resolve %s", folder)
+	}
+
+	engines, err := storage.GetCustomEngines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, engine := range engines {
+		if engine.Path == folder {
+			return nil, eris.Errorf("%s is already registered as %s", folder, engine.Title)
+		}
+	}
+
+	modID, err := newCustomEngineID(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	if title == "" {
+		title = filepath.Base(folder)
+	}
+
+	engine := &client.CustomEngine{
+		Modid: modID,
+		Title: title,
+		Path:  folder,
+	}
+	err = probeCustomEngine(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.SaveCustomEngine(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveCustomEngineRelease(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	api.Log(ctx, api.LogInfo, "Registered %s %s from %s", engine.Modid, engine.Version, folder)
+	return engine, nil
+}
+
+// RefreshCustomEngine rescans the engine's folder. If the build reports a new version, the old release is replaced and
+// all mods using it are switched to the new version.
+func RefreshCustomEngine(ctx context.Context, modID string) (*client.CustomEngine, error) {
+	engine, err := storage.GetCustomEngine(ctx, modID)
+	if err != nil {
+		return nil, err
+	}
+	if engine == nil {
+		return nil, eris.Errorf("%s is not a custom engine", modID)
+	}
+
+	oldVersion := engine.Version
+	err = probeCustomEngine(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.BatchUpdate(ctx, func(ctx context.Context) error {
+		if oldVersion != engine.Version {
+			err := storage.DeleteLocalModRelease(ctx, &common.Release{Modid: modID, Version: oldVersion})
+			if err != nil {
+				return err
+			}
+
+			err = storage.ReplaceUserEngineVersion(ctx, modID, oldVersion, engine.Version)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := storage.SaveCustomEngine(ctx, engine)
+		if err != nil {
+			return err
+		}
+
+		return saveCustomEngineRelease(ctx, engine)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if oldVersion != engine.Version {
+		api.Log(ctx, api.LogInfo, "Custom engine %s changed from %s to %s", modID, oldVersion, engine.Version)
+	}
+	return engine, nil
+}
+
+// RemoveCustomEngine unregisters a custom engine. Mods which used it are switched back to their default engine. The
+// build folder is left untouched.
+func RemoveCustomEngine(ctx context.Context, modID string) error {
+	engine, err := storage.GetCustomEngine(ctx, modID)
+	if err != nil {
+		return err
+	}
+	if engine == nil {
+		return eris.Errorf("%s is not a custom engine", modID)
+	}
+
+	return storage.BatchUpdate(ctx, func(ctx context.Context) error {
+		err := storage.DeleteLocalModRelease(ctx, &common.Release{Modid: modID, Version: engine.Version})
+		if err != nil {
+			return err
+		}
+
+		err = storage.DeleteLocalMod(ctx, modID)
+		if err != nil {
+			return err
+		}
+
+		err = storage.ResetUserEngine(ctx, modID)
+		if err != nil {
+			return err
+		}
+
+		return storage.DeleteCustomEngine(ctx, modID)
+	})
+}
+
+// RestoreCustomEngines re-adds the releases for all registered custom engines. ImportMods() wipes LocalMods so this has
+// to be called whenever the local mods are reimported.
+func RestoreCustomEngines(ctx context.Context) error {
+	engines, err := storage.GetCustomEngines(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, engine := range engines {
+		err = saveCustomEngineRelease(ctx, engine)
+		if err != nil {
+			return eris.Wrapf(err, "failed to restore custom engine %s", engine.Modid)
+		}
+	}
+
+	return nil
+}
@@ -29,6 +29,9 @@ type resolvePathNode struct {
 	modID            string
 	version          string
 	constraints      []modConstraint
+	requiredCaps     []string
+	// installed release of this engine or nil if this isn't an engine or it's not installed
+	engine *common.Release
 }
 
 func makeVersionSnapshot(versions map[string][]string) map[string][]string {
@@ -43,6 +46,27 @@ func makeVersionSnapshot(versions map[string][]string) map[string][]string {
 
 var noPreRelConstraintPattern = regexp.MustCompile(`[>=~]*\s*[0-9]+\.[0-9]+\.[0-9]+(?:-)?`)
 
+func parseDependencyConstraint(rawConstraint string) (*semver.Constraints, error) {
+	if rawConstraint == "" || rawConstraint == "*" {
+		rawConstraint = ">= 0.0.0-0"
+	}
+
+	// Make sure all constraints that don't require exact versions allow prerelease versions
+	rawConstraint = noPreRelConstraintPattern.ReplaceAllStringFunc(rawConstraint, func(s string) string {
+		if !strings.HasSuffix(s, "-") && strings.ContainsAny(s, ">~") {
+			return s + "-0"
+		}
+		return s
+	})
+
+	constraint, err := semver.NewConstraint(rawConstraint)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse constraint %s", rawConstraint)
+	}
+
+	return constraint, nil
+}
+
 func GetDependencySnapshot(ctx context.Context, mods storage.ModProvider, release *common.Release) (DependencySnapshot, error) {
 	startTime := time.Now()
 
@@ -55,6 +79,57 @@ func GetDependencySnapshot(ctx context.Context, mods storage.ModProvider, releas
 	availableVersions[release.Modid] = []string{release.Version}
 	fromGraph[release.Modid] = []string{"root"}
 
+	// Probing an engine means running it so we only do that once we actually need the caps
+	capsCache := make(map[string][]string)
+	getCaps := func(engine *common.Release) ([]string, bool) {
+		key := engine.Modid + "#" + engine.Version
+		if caps, ok := capsCache[key]; ok {
+			return caps, caps != nil
+		}
+
+		caps, known, err := getEngineCaps(ctx, engine)
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "DEP: Failed to check capabilities of %s %s: %s", engine.Modid, engine.Version, err)
+		}
+		if !known || err != nil {
+			capsCache[key] = nil
+			return nil, false
+		}
+
+		if caps == nil {
+			caps = []string{}
+		}
+		capsCache[key] = caps
+		return caps, true
+	}
+	checkCaps := func(requirer string, required []string, engine *common.Release) bool {
+		if len(required) == 0 || engine == nil {
+			return true
+		}
+
+		caps, known := getCaps(engine)
+		if !known {
+			return true
+		}
+
+		missing := findMissingCaps(required, caps)
+		if len(missing) == 0 {
+			return true
+		}
+
+		api.Log(ctx, api.LogDebug, "DEP: Conflict: %s requires capabilities %s missing from %s %s", requirer,
+			strings.Join(missing, ", "), engine.Modid, engine.Version)
+
+		msgs, ok := conflicts[engine.Modid]
+		if !ok {
+			msgs = make(map[string]string)
+			conflicts[engine.Modid] = msgs
+		}
+		msgs[requirer] = fmt.Sprintf("%s requires the engine capabilities %s which %s %s doesn't support", requirer,
+			strings.Join(missing, ", "), engine.Modid, engine.Version)
+		return false
+	}
+
 	for len(queue) > 0 {
 		modID := queue[0]
 		queue = queue[1:]
@@ -128,8 +203,27 @@ func GetDependencySnapshot(ctx context.Context, mods storage.ModProvider, releas
 			presentPackages[pkg.Name] = true
 		}
 
+		requiredCaps := getRequiredCaps(ctx, rel)
+		var engine *common.Release
+		meta, err := mods.GetMod(ctx, modID)
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to retrieve mod %s", modID)
+		}
+		if meta.Type == common.ModType_ENGINE {
+			// Only installed engines can be probed; remote ones are assumed to be fine.
+			engine, err = storage.LocalMods.GetModRelease(ctx, modID, version)
+			if err != nil {
+				engine = nil
+			}
+		}
+
 		// Ensure this version is compatible with previously chosen mods.
 		for _, node := range path {
+			if !checkCaps(node.modID, node.requiredCaps, engine) || !checkCaps(modID, requiredCaps, node.engine) {
+				availableVersions[modID] = availableVersions[modID][:len(availableVersions[modID])-1]
+				goto repickVersion
+			}
+
 			for _, con := range node.constraints {
 				if con.modID == modID {
 					ok, err := con.constraint.Validate(parsedVersion)
@@ -157,20 +251,7 @@ func GetDependencySnapshot(ctx context.Context, mods storage.ModProvider, releas
 		cons := make([]modConstraint, 0)
 		for _, pkg := range pkgs {
 			for _, dep := range pkg.Dependencies {
-				rawConstraint := dep.Constraint
-				if rawConstraint == "" || rawConstraint == "*" {
-					rawConstraint = ">= 0.0.0-0"
-				}
-
-				// Make sure all constraints that don't require exact versions allow prerelease versions
-				rawConstraint = noPreRelConstraintPattern.ReplaceAllStringFunc(rawConstraint, func(s string) string {
-					if !strings.HasSuffix(s, "-") && strings.ContainsAny(s, ">~") {
-						return s + "-0"
-					}
-					return s
-				})
-
-				constraint, err := semver.NewConstraint(rawConstraint)
+				constraint, err := parseDependencyConstraint(dep.Constraint)
 				if err != nil {
 					return nil, eris.Wrapf(err, "failed to parse constraint %s for mod %s %s", dep.Constraint, modID, version)
 				}
@@ -266,6 +347,8 @@ func GetDependencySnapshot(ctx context.Context, mods storage.ModProvider, releas
 			versionSnapshot:  makeVersionSnapshot(availableVersions),
 			presentPackages:  presentPackages,
 			requiredPackages: requiredPackages,
+			requiredCaps:     requiredCaps,
+			engine:           engine,
 		})
 	}
 
@@ -0,0 +1,213 @@
+package mods
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func graphNodeID(modID, version string) string {
+	if version == "" {
+		return modID
+	}
+
+	return modID + "#" + version
+}
+
+// BuildDependencyGraph collects the passed release and every mod in its dependency snapshot as nodes and every package
+// dependency as an edge pointing to the version chosen by the snapshot. If engineOverride is set, an additional edge
+// from the release to the user-selected engine is added.
+func BuildDependencyGraph(ctx context.Context, mods storage.ModProvider, release *common.Release, snapshot DependencySnapshot, engineOverride *client.UserSettings_EngineOptions) (*client.DependencyGraph, error) {
+	graph := &client.DependencyGraph{
+		Root:  graphNodeID(release.Modid, release.Version),
+		Nodes: make([]*client.DependencyGraph_Node, 0, len(snapshot)+1),
+		Edges: make([]*client.DependencyGraph_Edge, 0),
+	}
+
+	chosen := make(map[string]string, len(snapshot)+1)
+	for modID, version := range snapshot {
+		chosen[modID] = version
+	}
+	chosen[release.Modid] = release.Version
+
+	nodes := make(map[string]*client.DependencyGraph_Node)
+	releases := make(map[string]*common.Release)
+	addNode := func(modID, version string) (*client.DependencyGraph_Node, *common.Release) {
+		id := graphNodeID(modID, version)
+		if node, ok := nodes[id]; ok {
+			return node, releases[id]
+		}
+
+		node := &client.DependencyGraph_Node{
+			Id:       id,
+			Modid:    modID,
+			Version:  version,
+			Title:    modID,
+			Packages: make([]string, 0),
+		}
+		nodes[id] = node
+		graph.Nodes = append(graph.Nodes, node)
+
+		mod, err := mods.GetMod(ctx, modID)
+		if err == nil {
+			node.Title = mod.Title
+			node.Type = mod.Type
+			node.Engine = mod.Type == common.ModType_ENGINE
+		}
+
+		if version == "" {
+			node.Missing = true
+			return node, nil
+		}
+
+		var rel *common.Release
+		if modID == release.Modid && version == release.Version {
+			rel = release
+		} else {
+			rel, err = mods.GetModRelease(ctx, modID, version)
+			if err != nil {
+				node.Missing = true
+				return node, nil
+			}
+		}
+
+		for _, pkg := range FilterUnsupportedPackages(ctx, rel.Packages) {
+			node.Packages = append(node.Packages, pkg.Name)
+		}
+
+		releases[id] = rel
+		return node, rel
+	}
+
+	modIDs := make([]string, 0, len(chosen))
+	for modID := range chosen {
+		if modID != release.Modid {
+			modIDs = append(modIDs, modID)
+		}
+	}
+	sort.Strings(modIDs)
+	modIDs = append([]string{release.Modid}, modIDs...)
+
+	for _, modID := range modIDs {
+		node, rel := addNode(modID, chosen[modID])
+		if rel == nil {
+			continue
+		}
+
+		for _, pkg := range FilterUnsupportedPackages(ctx, rel.Packages) {
+			for _, dep := range pkg.Dependencies {
+				depVersion := chosen[dep.Modid]
+				target, _ := addNode(dep.Modid, depVersion)
+
+				edge := &client.DependencyGraph_Edge{
+					From:        node.Id,
+					To:          target.Id,
+					FromPackage: pkg.Name,
+					Constraint:  dep.Constraint,
+					Packages:    dep.Packages,
+				}
+
+				if depVersion != "" {
+					constraint, err := parseDependencyConstraint(dep.Constraint)
+					if err != nil {
+						return nil, eris.Wrapf(err, "failed to parse constraint %s for mod %s %s", dep.Constraint, rel.Modid, rel.Version)
+					}
+
+					parsedVersion, err := semver.NewVersion(depVersion)
+					if err != nil {
+						return nil, eris.Wrapf(err, "failed to parse version %s for mod %s", depVersion, dep.Modid)
+					}
+
+					edge.Satisfied = constraint.Check(parsedVersion)
+				}
+
+				graph.Edges = append(graph.Edges, edge)
+			}
+		}
+	}
+
+	if engineOverride.GetModid() != "" {
+		target, _ := addNode(engineOverride.Modid, engineOverride.Version)
+
+		graph.Edges = append(graph.Edges, &client.DependencyGraph_Edge{
+			From:           graph.Root,
+			To:             target.Id,
+			Satisfied:      !target.Missing,
+			EngineOverride: true,
+		})
+	}
+
+	return graph, nil
+}
+
+// FormatDependencyGraphJSON returns the passed graph as indented JSON
+func FormatDependencyGraphJSON(graph *client.DependencyGraph) (string, error) {
+	encoded, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return "", eris.Wrap(err, "failed to serialise dependency graph")
+	}
+
+	return string(encoded), nil
+}
+
+// FormatDependencyGraphDOT returns the passed graph in Graphviz' DOT format
+func FormatDependencyGraphDOT(graph *client.DependencyGraph) string {
+	buffer := strings.Builder{}
+	buffer.WriteString("digraph dependencies {\n")
+	buffer.WriteString("\trankdir=LR;\n")
+	buffer.WriteString("\tnode [shape=box];\n\n")
+
+	for _, node := range graph.Nodes {
+		attrs := []string{"label=" + strconv.Quote(fmt.Sprintf("%s\n%s", node.Title, node.Version))}
+		if node.Engine {
+			attrs = append(attrs, "shape=hexagon")
+		}
+		if node.Id == graph.Root {
+			attrs = append(attrs, "penwidth=2")
+		}
+		if node.Missing {
+			attrs = append(attrs, "style=dashed")
+		}
+
+		fmt.Fprintf(&buffer, "\t%s [%s];\n", strconv.Quote(node.Id), strings.Join(attrs, ", "))
+	}
+
+	buffer.WriteString("\n")
+	for _, edge := range graph.Edges {
+		var label string
+		if edge.EngineOverride {
+			label = "engine override"
+		} else {
+			label = edge.FromPackage
+			if edge.Constraint != "" {
+				label += ": " + edge.Constraint
+			}
+			if len(edge.Packages) > 0 {
+				label += "\n(" + strings.Join(edge.Packages, ", ") + ")"
+			}
+		}
+
+		attrs := []string{"label=" + strconv.Quote(label)}
+		if edge.EngineOverride {
+			attrs = append(attrs, "style=dotted")
+		}
+		if !edge.Satisfied {
+			attrs = append(attrs, "color=red")
+		}
+
+		fmt.Fprintf(&buffer, "\t%s -> %s [%s];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), strings.Join(attrs, ", "))
+	}
+
+	buffer.WriteString("}\n")
+	return buffer.String()
+}
@@ -0,0 +1,162 @@
+package mods
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+// getRequiredCaps returns the engine capabilities required by the release and its packages which are supported on
+// this system
+func getRequiredCaps(ctx context.Context, rel *common.Release) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	add := func(caps []string) {
+		for _, item := range caps {
+			if item != "" && !seen[item] {
+				seen[item] = true
+				result = append(result, item)
+			}
+		}
+	}
+
+	add(rel.RequiredCaps)
+	for _, pkg := range FilterUnsupportedPackages(ctx, rel.Packages) {
+		add(pkg.RequiredCaps)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// getEngineCaps returns the capabilities reported by the passed engine. The second return value is false if the
+// engine isn't installed which means that we can't tell which capabilities it has.
+func getEngineCaps(ctx context.Context, engine *common.Release) ([]string, bool, error) {
+	knSettings, err := storage.GetSettings(ctx)
+	if err != nil {
+		return nil, false, eris.Wrap(err, "failed to load settings")
+	}
+
+	binaryPath, err := getBinaryForEngine(ctx, engine, "")
+	if err != nil {
+		return nil, false, nil
+	}
+
+	binaryPath = smartJoin(knSettings.LibraryPath, "bin", binaryPath)
+	if _, err = os.Stat(binaryPath); err != nil {
+		return nil, false, nil
+	}
+
+	flags, err := getJSONFlagsForBinary(ctx, binaryPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return flags.Caps, true, nil
+}
+
+func findMissingCaps(required, available []string) []string {
+	present := make(map[string]bool, len(available))
+	for _, item := range available {
+		present[item] = true
+	}
+
+	missing := make([]string, 0)
+	for _, item := range required {
+		if !present[item] {
+			missing = append(missing, item)
+			// only report each capability once
+			present[item] = true
+		}
+	}
+
+	return missing
+}
+
+// suggestEngineVersion returns the newest installed version of the given engine which supports all required caps
+func suggestEngineVersion(ctx context.Context, engineID string, required []string) string {
+	versions, err := storage.LocalMods.GetVersionsForMod(ctx, engineID)
+	if err != nil {
+		return ""
+	}
+
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		engine, err := storage.LocalMods.GetModRelease(ctx, engineID, versions[idx])
+		if err != nil {
+			continue
+		}
+
+		caps, known, err := getEngineCaps(ctx, engine)
+		if err != nil || !known {
+			continue
+		}
+
+		if len(findMissingCaps(required, caps)) == 0 {
+			return versions[idx]
+		}
+	}
+
+	return ""
+}
+
+// checkEngineCaps makes sure that the engine supports all capabilities required by mod and its dependencies. Returns
+// an EngineCapsMissing error if it doesn't.
+func checkEngineCaps(ctx context.Context, mod, engine *common.Release) error {
+	required := getRequiredCaps(ctx, mod)
+	for modID, version := range mod.DependencySnapshot {
+		if modID == engine.Modid {
+			continue
+		}
+
+		dep, err := storage.LocalMods.GetModRelease(ctx, modID, version)
+		if err != nil {
+			// Missing dependencies are reported by the launcher
+			continue
+		}
+
+		required = append(required, getRequiredCaps(ctx, dep)...)
+	}
+
+	if len(required) == 0 {
+		return nil
+	}
+
+	caps, known, err := getEngineCaps(ctx, engine)
+	if err != nil {
+		return eris.Wrapf(err, "failed to check capabilities of %s %s", engine.Modid, engine.Version)
+	}
+	if !known {
+		api.Log(ctx, api.LogWarn, "Can't check the capabilities of %s %s since it isn't installed", engine.Modid, engine.Version)
+		return nil
+	}
+
+	missing := findMissingCaps(required, caps)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sort.Strings(missing)
+	return eris.Wrap(EngineCapsMissing{
+		ModID:         mod.Modid,
+		Version:       mod.Version,
+		EngineID:      engine.Modid,
+		EngineVersion: engine.Version,
+		Missing:       missing,
+		Suggested:     suggestEngineVersion(ctx, engine.Modid, required),
+	}, "engine check failed")
+}
+
+// warnMissingCaps logs a warning if the engine lacks capabilities the mod needs. It's used for engines the user picked
+// explicitly since they might know better than the mod's metadata.
+func warnMissingCaps(ctx context.Context, mod, engine *common.Release) {
+	err := checkEngineCaps(ctx, mod, engine)
+	if err != nil {
+		api.Log(ctx, api.LogWarn, "%s", eris.ToString(err, false))
+	}
+}
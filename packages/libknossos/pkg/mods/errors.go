@@ -1,6 +1,9 @@
 package mods
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type ModMissing struct {
 	ModID   string
@@ -24,3 +27,24 @@ var _ error = (*PackageMissing)(nil)
 func (e PackageMissing) Error() string {
 	return fmt.Sprintf("The package %s for mod %s (%s) is missing.", e.Package, e.ModID, e.Version)
 }
+
+type EngineCapsMissing struct {
+	ModID         string
+	Version       string
+	EngineID      string
+	EngineVersion string
+	Missing       []string
+	Suggested     string
+}
+
+var _ error = (*EngineCapsMissing)(nil)
+
+func (e EngineCapsMissing) Error() string {
+	msg := fmt.Sprintf("The mod %s (%s) requires the engine capabilities %s which %s (%s) doesn't support.", e.ModID,
+		e.Version, strings.Join(e.Missing, ", "), e.EngineID, e.EngineVersion)
+	if e.Suggested != "" {
+		msg += fmt.Sprintf(" Version %s supports them.", e.Suggested)
+	}
+
+	return msg
+}
@@ -0,0 +1,131 @@
+package mods
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func collectFlagNames(flags *storage.JSONFlags) map[string]bool {
+	result := make(map[string]bool, len(flags.Flags))
+	for _, flag := range flags.Flags {
+		result[flag.Name] = true
+	}
+
+	return result
+}
+
+// validateCmdline checks every flag in cmdline against the flags supported by the engine. If previous is set, flags
+// which the previous engine supported but the new one doesn't are reported as removed instead of unknown.
+func validateCmdline(cmdline string, flags *storage.JSONFlags, previous *storage.JSONFlags) (*client.FlagValidation, error) {
+	args, err := splitCommandLine(cmdline)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to parse command line")
+	}
+
+	known := collectFlagNames(flags)
+	var previousKnown map[string]bool
+	if previous != nil {
+		previousKnown = collectFlagNames(previous)
+	}
+
+	result := &client.FlagValidation{
+		Unknown:    make([]string, 0),
+		Removed:    make([]string, 0),
+		Duplicated: make([]string, 0),
+	}
+	seen := make(map[string]int)
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			// Flag parameter
+			continue
+		}
+
+		if _, err := strconv.ParseFloat(arg, 64); err == nil {
+			// Negative numbers are parameters, too
+			continue
+		}
+
+		seen[arg]++
+		if seen[arg] == 2 {
+			result.Duplicated = append(result.Duplicated, arg)
+		}
+		if seen[arg] > 1 || known[arg] {
+			continue
+		}
+
+		if previousKnown[arg] {
+			result.Removed = append(result.Removed, arg)
+		} else {
+			result.Unknown = append(result.Unknown, arg)
+		}
+	}
+
+	sort.Strings(result.Unknown)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Duplicated)
+
+	result.Valid = len(result.Unknown) == 0 && len(result.Removed) == 0 && len(result.Duplicated) == 0
+	return result, nil
+}
+
+func logFlagValidation(ctx context.Context, validation *client.FlagValidation) {
+	if len(validation.Unknown) > 0 {
+		api.Log(ctx, api.LogWarn, "The engine doesn't support these flags: %s", strings.Join(validation.Unknown, ", "))
+	}
+	if len(validation.Removed) > 0 {
+		api.Log(ctx, api.LogWarn, "These flags are not supported by the new engine: %s", strings.Join(validation.Removed, ", "))
+	}
+	if len(validation.Duplicated) > 0 {
+		api.Log(ctx, api.LogWarn, "These flags are passed more than once: %s", strings.Join(validation.Duplicated, ", "))
+	}
+}
+
+// ValidateModFlags checks the mod's effective command line against the engine it uses. If newEngine is set, the
+// command line is checked against that engine instead and flags that would be lost by switching are reported as
+// removed.
+func ValidateModFlags(ctx context.Context, mod *common.Release, settings *client.UserSettings, newEngine *client.UserSettings_EngineOptions) (*client.FlagValidation, error) {
+	cmdline := settings.GetCmdline()
+	if cmdline == "" {
+		cmdline = mod.Cmdline
+	}
+
+	var currentFlags *storage.JSONFlags
+	currentEngine, err := getUserEngineForMod(ctx, mod, settings)
+	if err == nil {
+		currentFlags, err = getJSONFlagsForEngine(ctx, currentEngine)
+	}
+	if err != nil {
+		if newEngine.GetModid() == "" {
+			return nil, eris.Wrapf(err, "failed to retrieve flags for the engine of %s %s", mod.Modid, mod.Version)
+		}
+
+		// The mod might be switched to a new engine precisely because the current one is broken or missing.
+		api.Log(ctx, api.LogWarn, "Could not retrieve flags for the current engine of %s: %s", mod.Modid, eris.ToString(err, true))
+		currentFlags = nil
+	}
+
+	if newEngine.GetModid() == "" {
+		return validateCmdline(cmdline, currentFlags, nil)
+	}
+
+	engine, err := storage.LocalMods.GetModRelease(ctx, newEngine.Modid, newEngine.Version)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to fetch engine %s %s", newEngine.Modid, newEngine.Version)
+	}
+
+	newFlags, err := getJSONFlagsForEngine(ctx, engine)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to retrieve flags for %s %s", newEngine.Modid, newEngine.Version)
+	}
+
+	return validateCmdline(cmdline, newFlags, currentFlags)
+}
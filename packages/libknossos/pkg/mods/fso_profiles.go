@@ -0,0 +1,58 @@
+package mods
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/fsointerop"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+// applyFSOSettingsProfile writes the named profile to the fs2_open.ini in prefPath. If the profile asks for it, the
+// returned function restores the previous ini; otherwise it's nil.
+func applyFSOSettingsProfile(ctx context.Context, prefPath, name string) (func(context.Context), error) {
+	profile, err := storage.GetFSOSettingsProfile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, eris.Errorf("FSO settings profile %s not found", name)
+	}
+
+	iniPath := filepath.Join(prefPath, "fs2_open.ini")
+	var previous []byte
+	if profile.Restore {
+		previous, err = os.ReadFile(iniPath)
+		if err != nil && !eris.Is(err, os.ErrNotExist) {
+			return nil, eris.Wrapf(err, "failed to back up %s", iniPath)
+		}
+	}
+
+	api.Log(ctx, api.LogInfo, "Applying FSO settings profile %s", name)
+	err = fsointerop.SaveSettings(ctx, prefPath, profile.Settings)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to apply FSO settings profile %s", name)
+	}
+
+	if !profile.Restore {
+		return nil, nil
+	}
+
+	return func(ctx context.Context) {
+		api.Log(ctx, api.LogInfo, "Restoring %s", iniPath)
+
+		var err error
+		if previous == nil {
+			err = os.Remove(iniPath)
+		} else {
+			err = os.WriteFile(iniPath, previous, 0o600)
+		}
+		if err != nil {
+			api.Log(ctx, api.LogError, "Failed to restore %s: %s", iniPath, err)
+		}
+	}, nil
+}
@@ -0,0 +1,210 @@
+package mods
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/helpers"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+// imagePrefetchWorkers is the number of images downloaded in parallel by PrefetchRemoteImages
+const imagePrefetchWorkers = 4
+
+// ErrImageNotAvailable is returned by ResolveImage if there's neither a local copy of the image nor a way to fetch it
+// (i.e. because the image cache is disabled)
+var ErrImageNotAvailable = eris.New("image not available")
+
+// fetchImage downloads the passed remote image into the image cache. Returns an empty string if the image couldn't be
+// cached.
+func fetchImage(ctx context.Context, ref *common.FileRef) (string, error) {
+	folder := storage.ImageCacheFolder(ctx)
+	err := os.MkdirAll(folder, 0o770)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to create %s", folder)
+	}
+
+	var lastErr error
+	for _, url := range ref.Urls {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+
+		cachePath, err := fetchImageFrom(ctx, ref.Fileid, url, folder)
+		if err == nil {
+			return cachePath, nil
+		}
+
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		return "", ErrImageNotAvailable
+	}
+	return "", lastErr
+}
+
+func fetchImageFrom(ctx context.Context, fileID, url, folder string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to construct GET request for %s", url)
+	}
+
+	resp, err := helpers.HTTPDo(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", eris.Errorf("request to %s failed with status %d", url, resp.StatusCode)
+	}
+
+	tmpFile, err := os.CreateTemp(folder, storage.ImageCacheTempPrefix)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to create temporary file in %s", folder)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = io.Copy(tmpFile, resp.Body)
+	tmpFile.Close()
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to download %s", url)
+	}
+
+	ext := path.Ext(req.URL.Path)
+	if len(ext) > 5 {
+		ext = ""
+	}
+
+	return storage.AddCachedImage(ctx, fileID, tmpFile.Name(), ext)
+}
+
+// ResolveImage returns a local path for the passed file ID. Images of installed mods are served from the mod folder,
+// all other images are served from (and if necessary downloaded to) the image cache.
+func ResolveImage(ctx context.Context, fileID string) (string, error) {
+	ref, err := storage.GetFile(ctx, fileID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, url := range ref.Urls {
+		if strings.HasPrefix(url, "file://") {
+			localPath := filepath.FromSlash(url[7:])
+			_, err = os.Stat(localPath)
+			if err == nil {
+				return localPath, nil
+			}
+		}
+	}
+
+	cachePath, err := storage.GetCachedImage(ctx, fileID)
+	if err != nil || cachePath != "" {
+		return cachePath, err
+	}
+
+	limit, err := storage.GetImageCacheLimit(ctx)
+	if err != nil {
+		return "", err
+	}
+	if limit == 0 {
+		return "", ErrImageNotAvailable
+	}
+
+	cachePath, err = fetchImage(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if cachePath == "" {
+		return "", ErrImageNotAvailable
+	}
+
+	return cachePath, nil
+}
+
+// PrefetchRemoteImages downloads the teasers of all remote mods which aren't cached yet so that the mod list works
+// offline. Banners and screenshots are cached once they're viewed.
+func PrefetchRemoteImages(ctx context.Context) error {
+	limit, err := storage.GetImageCacheLimit(ctx)
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	releases, err := storage.RemoteMods.GetMods(ctx)
+	if err != nil {
+		return eris.Wrap(err, "failed to load remote mods")
+	}
+
+	refs := make([]*common.FileRef, 0)
+	for _, rel := range releases {
+		if rel.Teaser == nil || rel.Teaser.Fileid == "" {
+			continue
+		}
+
+		cached, err := storage.IsImageCached(ctx, rel.Teaser.Fileid)
+		if err != nil {
+			return err
+		}
+
+		if !cached {
+			refs = append(refs, rel.Teaser)
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	api.Log(ctx, api.LogInfo, "Caching %d mod images", len(refs))
+	queue := make(chan *common.FileRef)
+	failed := 0
+	done := 0
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < imagePrefetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range queue {
+				_, err := fetchImage(ctx, ref)
+
+				lock.Lock()
+				if err != nil {
+					api.Log(ctx, api.LogDebug, "Failed to cache image %s: %s", ref.Fileid, err)
+					failed++
+				}
+				done++
+				api.SetProgress(ctx, float32(done)/float32(len(refs)), fmt.Sprintf("Caching images (%d of %d)", done,
+					len(refs)))
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- ref
+	}
+	close(queue)
+	wg.Wait()
+
+	if failed > 0 {
+		api.Log(ctx, api.LogWarn, "Failed to cache %d of %d mod images", failed, len(refs))
+	}
+
+	return nil
+}
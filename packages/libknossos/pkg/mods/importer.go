@@ -466,7 +466,7 @@ func ImportMods(ctx context.Context, modFiles []string) error {
 			}
 		}
 
-		return nil
+		return RestoreCustomEngines(ctx)
 	})
 	if err != nil {
 		return err
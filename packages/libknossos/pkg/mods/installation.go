@@ -549,6 +549,11 @@ func InstallMod(ctx context.Context, req *client.InstallModRequest) error {
 			if err != nil {
 				return err
 			}
+
+			err = SaveInstalledChecksumPack(ctx, rel, info[rel.Modid])
+			if err != nil {
+				return err
+			}
 		}
 
 		for _, modMeta := range modMetas {
@@ -20,9 +20,26 @@ import (
 
 func VerifyModIntegrity(ctx context.Context, rel *common.Release) error {
 	api.Log(ctx, api.LogInfo, "Fetching checksums")
+	var checksums *common.ChecksumPack
 	checksumPacks, err := FetchModChecksums(ctx, map[string]string{rel.Modid: rel.Version})
-	if err != nil {
-		return eris.Wrap(err, "failed to acquire checksums")
+	if err == nil {
+		checksums = checksumPacks[rel.Modid]
+
+		// Releases installed before we saved checksums during the installation don't have a local copy, yet
+		err = SaveInstalledChecksumPack(ctx, rel, checksums)
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "Failed to save installed checksums: %s", err)
+		}
+	} else {
+		// The release might not be available anymore; fall back to the copy we saved during the installation
+		var localErr error
+		checksums, localErr = LoadInstalledChecksumPack(ctx, rel)
+		if localErr != nil {
+			api.Log(ctx, api.LogWarn, "Failed to load installed checksums: %s", localErr)
+			return eris.Wrap(err, "failed to acquire checksums")
+		}
+
+		api.Log(ctx, api.LogInfo, "Using installed checksums since we couldn't fetch them: %s", err)
 	}
 
 	modFolder, err := GetModFolder(ctx, rel)
@@ -30,7 +47,6 @@ func VerifyModIntegrity(ctx context.Context, rel *common.Release) error {
 		return eris.Wrap(err, "failed to build mod folder")
 	}
 
-	checksums := checksumPacks[rel.Modid]
 	totalBytes := int64(0)
 	for _, pkg := range rel.Packages {
 		for _, archive := range pkg.Archives {
@@ -173,6 +189,7 @@ func buildFilelist(dir, prefix string) ([]string, error) {
 func detectOrphans(ctx context.Context, rel *common.Release, checksums *common.ChecksumPack, delete bool) error {
 	filelist := make(map[string]bool)
 	filelist["knrelease.json"] = true
+	filelist[checksumPackFile] = true
 
 	modFolder, err := GetModFolder(ctx, rel)
 	if err != nil {
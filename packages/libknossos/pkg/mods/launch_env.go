@@ -0,0 +1,160 @@
+package mods
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/fsointerop"
+)
+
+// wrapperPlaceholder marks the position of the FSO binary and its arguments in a wrapper template. If a template
+// doesn't contain it, the command is appended to the end.
+const wrapperPlaceholder = "%command%"
+
+// splitCommandLine splits the passed string into arguments. Single and double quotes group arguments and a backslash
+// escapes the next character (except inside single quotes).
+func splitCommandLine(line string) ([]string, error) {
+	result := make([]string, 0)
+	current := strings.Builder{}
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, char := range line {
+		switch {
+		case escaped:
+			current.WriteRune(char)
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '"' || char == '\'':
+			quote = char
+			inArg = true
+		case char == ' ' || char == '\t' || char == '\n':
+			if inArg {
+				result = append(result, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+
+	if escaped {
+		return nil, eris.Errorf("unterminated escape sequence in %s", line)
+	}
+	if quote != 0 {
+		return nil, eris.Errorf("unterminated quote in %s", line)
+	}
+	if inArg {
+		result = append(result, current.String())
+	}
+
+	return result, nil
+}
+
+// buildLaunchEnv returns the current process environment extended with the global and per-mod variables. The per-mod
+// variables take precedence. settings may be nil.
+func buildLaunchEnv(knSettings *client.Settings, settings *client.UserSettings) ([]string, error) {
+	vars := make(map[string]string)
+	for k, v := range knSettings.GetEnv() {
+		vars[k] = v
+	}
+	for k, v := range settings.GetEnv() {
+		vars[k] = v
+	}
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		if k == "" || strings.ContainsAny(k, "= ") {
+			return nil, eris.Errorf("invalid environment variable name %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := os.Environ()
+	for _, k := range keys {
+		env = append(env, k+"="+vars[k])
+	}
+
+	return env, nil
+}
+
+// wrapCommand inserts the passed command into the wrapper template from the settings (the per-mod one if set,
+// otherwise the global one) and makes sure the wrapper's executable exists. Without a wrapper, the command is returned
+// as is.
+func wrapCommand(knSettings *client.Settings, settings *client.UserSettings, command ...string) ([]string, error) {
+	template := settings.GetWrapper()
+	if template == "" {
+		template = knSettings.GetWrapper()
+	}
+	template = strings.TrimSpace(template)
+	if template == "" {
+		return command, nil
+	}
+
+	parts, err := splitCommandLine(template)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to parse launch wrapper")
+	}
+
+	result := make([]string, 0, len(parts)+len(command))
+	found := false
+	for _, part := range parts {
+		if part == wrapperPlaceholder {
+			result = append(result, command...)
+			found = true
+		} else {
+			result = append(result, part)
+		}
+	}
+
+	if !found {
+		result = append(result, command...)
+	}
+
+	if result[0] == command[0] {
+		// The template consists of nothing but the placeholder.
+		return result, nil
+	}
+
+	wrapperPath, err := exec.LookPath(result[0])
+	if err != nil {
+		return nil, eris.Wrapf(err, "launch wrapper %s not found", result[0])
+	}
+	result[0] = wrapperPath
+
+	return result, nil
+}
+
+// ValidateUserSettings checks the launch options (environment, wrapper and pref profile) in the passed settings
+func ValidateUserSettings(settings *client.UserSettings) error {
+	_, err := buildLaunchEnv(nil, settings)
+	if err != nil {
+		return err
+	}
+
+	if settings.GetWrapper() != "" {
+		_, err = wrapCommand(nil, settings, "fs2_open")
+		if err != nil {
+			return err
+		}
+	}
+
+	return fsointerop.ValidatePrefProfile(settings.GetPrefProfile())
+}
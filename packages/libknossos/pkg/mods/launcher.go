@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -35,8 +34,13 @@ func smartJoin(path ...string) string {
 	return filepath.Join(result...)
 }
 
-func touchINI(ctx context.Context) error {
-	iniPath := filepath.Join(fsointerop.GetPrefPath(ctx), "fs2_open.ini")
+func touchINI(prefPath string) error {
+	err := os.MkdirAll(prefPath, 0o770)
+	if err != nil {
+		return eris.Wrapf(err, "failed to create %s", prefPath)
+	}
+
+	iniPath := filepath.Join(prefPath, "fs2_open.ini")
 
 	f, err := os.OpenFile(iniPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o660)
 	if err != nil {
@@ -74,6 +78,72 @@ func GetEngineForMod(ctx context.Context, mod *common.Release) (*common.Release,
 		return nil, eris.New("no engine found")
 	}
 
+	err := checkEngineCaps(ctx, mod, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+// GetPrefPathForMod returns the pref path FSO uses when the given mod is launched
+func GetPrefPathForMod(ctx context.Context, modID, version string) (string, error) {
+	settings, err := storage.GetUserSettingsForMod(ctx, modID, version)
+	if err != nil {
+		return "", err
+	}
+
+	return fsointerop.GetProfilePrefPath(ctx, settings.GetPrefProfile()), nil
+}
+
+// refreshUserEngine rescans the custom engine the user selected, if any. Custom builds change whenever the user
+// recompiles them so this has to happen before launching them.
+func refreshUserEngine(ctx context.Context, settings *client.UserSettings) error {
+	engOpts := settings.GetEngineOptions()
+	if engOpts.GetModid() == "" {
+		return nil
+	}
+
+	custom, err := storage.GetCustomEngine(ctx, engOpts.Modid)
+	if err != nil {
+		return err
+	}
+	if custom == nil {
+		return nil
+	}
+
+	_, err = RefreshCustomEngine(ctx, custom.Modid)
+	if err != nil {
+		return eris.Wrapf(err, "failed to refresh custom engine %s", engOpts.Modid)
+	}
+
+	return nil
+}
+
+// getUserEngineForMod returns the engine the user selected for the passed mod or the mod's default engine if the user
+// didn't choose one. Call refreshUserEngine() first if the engine is about to be launched.
+func getUserEngineForMod(ctx context.Context, mod *common.Release, settings *client.UserSettings) (*common.Release, error) {
+	engOpts := settings.GetEngineOptions()
+	if engOpts.GetModid() == "" {
+		return GetEngineForMod(ctx, mod)
+	}
+
+	custom, err := storage.GetCustomEngine(ctx, engOpts.Modid)
+	if err != nil {
+		return nil, err
+	}
+	if custom != nil {
+		_, engine := buildCustomEngineRelease(custom)
+		warnMissingCaps(ctx, mod, engine)
+		return engine, nil
+	}
+
+	engine, err := storage.LocalMods.GetModRelease(ctx, engOpts.Modid, engOpts.Version)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to fetch user engine")
+	}
+
+	warnMissingCaps(ctx, mod, engine)
 	return engine, nil
 }
 
@@ -109,15 +179,27 @@ func getJSONFlagsForBinary(ctx context.Context, binaryPath string) (*storage.JSO
 		return flags, nil
 	}
 
-	// Make sure FSO is not running in legacy mode
-	err = touchINI(ctx)
+	// Make sure FSO is not running in legacy mode. The probe always runs with the shared pref path.
+	err = touchINI(fsointerop.GetPrefPath(ctx))
 	if err != nil {
 		return nil, eris.Wrap(err, "failed to touch fs2_open.ini")
 	}
 
+	knSettings, err := storage.GetSettings(ctx)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to load settings")
+	}
+
+	// The flags are cached per binary so only the global environment applies here. Wrappers are skipped since they
+	// only matter for actual game sessions.
+	env, err := buildLaunchEnv(knSettings, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	api.Log(ctx, api.LogInfo, "Running \"%s -parse_cmdline_only -get_flags json_v1\"", binaryPath)
 	proc := exec.Command(binaryPath, "-parse_cmdline_only", "-get_flags", "json_v1")
-	proc.Env = append(proc.Env, "FSO_KEEP_STDOUT=1")
+	proc.Env = append(env, "FSO_KEEP_STDOUT=1")
 	out, err := proc.CombinedOutput()
 	// Ignore the error if it's only about the exit code being 1 because that's normal.
 	if err != nil && proc.ProcessState.ExitCode() != 1 {
@@ -156,9 +238,7 @@ func GetFlagsForMod(ctx context.Context, mod *common.Release) (map[string]*clien
 	return GetFlagsForEngine(ctx, engine)
 }
 
-func GetFlagsForEngine(ctx context.Context, engine *common.Release) (map[string]*client.FlagInfo_Flag, error) {
-	result := make(map[string]*client.FlagInfo_Flag)
-
+func getJSONFlagsForEngine(ctx context.Context, engine *common.Release) (*storage.JSONFlags, error) {
 	knSettings, err := storage.GetSettings(ctx)
 	if err != nil {
 		return nil, eris.Wrap(err, "failed to load settings")
@@ -170,7 +250,13 @@ func GetFlagsForEngine(ctx context.Context, engine *common.Release) (map[string]
 	}
 
 	binaryPath = smartJoin(knSettings.LibraryPath, "bin", binaryPath)
-	flags, err := getJSONFlagsForBinary(ctx, binaryPath)
+	return getJSONFlagsForBinary(ctx, binaryPath)
+}
+
+func GetFlagsForEngine(ctx context.Context, engine *common.Release) (map[string]*client.FlagInfo_Flag, error) {
+	result := make(map[string]*client.FlagInfo_Flag)
+
+	flags, err := getJSONFlagsForEngine(ctx, engine)
 	if err != nil {
 		return nil, err
 	}
@@ -187,6 +273,102 @@ func GetFlagsForEngine(ctx context.Context, engine *common.Release) (map[string]
 	return result, nil
 }
 
+// buildModFlag returns the folders which have to be passed to FSO's -mod flag for the given mod
+func buildModFlag(ctx context.Context, mod *common.Release, parentFolder string) ([]string, error) {
+	modFlag := make([]string, 0, len(mod.DependencySnapshot))
+	for _, ID := range mod.ModOrder {
+		var rel *common.Release
+
+		if ID == mod.Modid {
+			rel = mod
+		} else {
+			version, ok := mod.DependencySnapshot[ID]
+			if !ok {
+				// This dependency is probably optional and missing, just skip it.
+				// TODO Make this more explicit
+				continue
+			}
+
+			var err error
+			rel, err = storage.LocalMods.GetModRelease(ctx, ID, version)
+			if err != nil {
+				return nil, eris.Wrap(ModMissing{
+					ModID:   ID,
+					Version: version,
+				}, "part of the dependency snapshot is missing")
+			}
+		}
+
+		// TODO Allow mod authors to specify which (optional) packages from dependencies should be used.
+		// For now, we just use all installed packages.
+
+		for _, pkg := range rel.Packages {
+			if filepath.IsAbs(rel.Folder) || filepath.IsAbs(pkg.Folder) {
+				flagPath, err := filepath.Rel(parentFolder, filepath.Join(rel.Folder, pkg.Folder))
+				if err != nil {
+					return nil, eris.Wrapf(err, "failed to build relative path to %s", filepath.Join(rel.Folder, pkg.Folder))
+				}
+
+				modFlag = append(modFlag, flagPath)
+			} else {
+				modFlag = append(modFlag, filepath.Join(rel.Folder, pkg.Folder))
+			}
+		}
+	}
+
+	return modFlag, nil
+}
+
+// relocateModFlag makes the paths in modFlag, which are relative to parentFolder, relative to workDir
+func relocateModFlag(modFlag []string, parentFolder, workDir string) ([]string, error) {
+	result := make([]string, len(modFlag))
+	for idx, item := range modFlag {
+		flagPath, err := filepath.Rel(workDir, filepath.Join(parentFolder, item))
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to build a path from %s to %s; pref profiles require the library to be on the same drive as the Knossos settings", workDir, item)
+		}
+
+		result[idx] = flagPath
+	}
+
+	return result, nil
+}
+
+// checkProfileFlags makes sure that the engine supports the flags required by the pref profile
+func checkProfileFlags(prefOpts *fsointerop.ProfileLaunchOptions, flags *storage.JSONFlags) error {
+	known := collectFlagNames(flags)
+	for _, arg := range prefOpts.Args {
+		if !known[arg] {
+			return eris.Errorf("the selected engine doesn't support %s which pref profiles need on this platform", arg)
+		}
+	}
+
+	return nil
+}
+
+// ensureExecutable makes sure that the user can execute the passed binary
+func ensureExecutable(binary string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Stat(binary)
+	if err != nil {
+		return eris.Wrapf(err, "failed to check file permissions for %s", binary)
+	}
+
+	// We assume that the user owns the binary (since we most likely created that file) so we just check if the user
+	// has rwx set on the file.
+	if info.Mode()&0o700 != 0o700 {
+		err = os.Chmod(binary, 0o777)
+		if err != nil {
+			return eris.Wrapf(err, "failed to set executable permission on %s", binary)
+		}
+	}
+
+	return nil
+}
+
 func LaunchMod(ctx context.Context, mod *common.Release, settings *client.UserSettings, label string) error {
 	// Resolve the engine by checking all relevant options in the following order:
 	//  1. custom build in the user settings (manual path to the binary)
@@ -201,17 +383,14 @@ func LaunchMod(ctx context.Context, mod *common.Release, settings *client.UserSe
 	if binary == "" || label != "" {
 		var engine *common.Release
 
-		engOpts := settings.GetEngineOptions()
-		if engOpts.GetModid() != "" {
-			engine, err = storage.LocalMods.GetModRelease(ctx, engOpts.Modid, engOpts.Version)
-			if err != nil {
-				return eris.Wrap(err, "failed to fetch user engine")
-			}
-		} else {
-			engine, err = GetEngineForMod(ctx, mod)
-			if err != nil {
-				return err
-			}
+		err = refreshUserEngine(ctx, settings)
+		if err != nil {
+			return err
+		}
+
+		engine, err = getUserEngineForMod(ctx, mod, settings)
+		if err != nil {
+			return err
 		}
 
 		binary, err = getBinaryForEngine(ctx, engine, label)
@@ -227,6 +406,15 @@ func LaunchMod(ctx context.Context, mod *common.Release, settings *client.UserSe
 		binary = smartJoin(knSettings.LibraryPath, "bin", binary)
 	}
 
+	return launchGame(ctx, mod, settings, label, binary)
+}
+
+// launchGame launches the passed FSO binary with the mod's command line
+func launchGame(ctx context.Context, mod *common.Release, settings *client.UserSettings, label, binary string) error {
+	if IsGameRunning(mod.Modid) {
+		return eris.Errorf("%s is already running", mod.Modid)
+	}
+
 	// Use the user's command line if one is set for this mod and fall back to the mod default otherwise.
 	cmdline := settings.GetCmdline()
 	if cmdline == "" {
@@ -238,46 +426,51 @@ func LaunchMod(ctx context.Context, mod *common.Release, settings *client.UserSe
 		return eris.Wrap(err, "failed to load settings")
 	}
 
-	parentFolder := filepath.Join(globalSettings.LibraryPath, "mods")
+	prefOpts, err := fsointerop.GetProfileLaunchOptions(ctx, settings.GetPrefProfile())
+	if err != nil {
+		return err
+	}
+	prefPath := fsointerop.GetProfilePrefPath(ctx, settings.GetPrefProfile())
 
-	// Build the -mod flag
-	modFlag := make([]string, 0, len(mod.DependencySnapshot))
-	for _, ID := range mod.ModOrder {
-		var rel *common.Release
+	// A failed backup shouldn't keep the user from playing
+	prefKey := getPrefKey(settings.GetPrefProfile())
+	err = snapshotPilots(ctx, prefPath, prefKey, mod.Modid, mod.Version)
+	if err != nil {
+		api.Log(ctx, api.LogError, "Failed to back up pilot files: %s", eris.ToString(err, true))
+	} else {
+		prunePilotSnapshots(ctx, prefKey)
+	}
 
-		if ID == mod.Modid {
-			rel = mod
-		} else {
-			version, ok := mod.DependencySnapshot[ID]
-			if !ok {
-				// This dependency is probably optional and missing, just skip it.
-				// TODO Make this more explicit
-				continue
-			}
+	flags, err := getJSONFlagsForBinary(ctx, binary)
+	if err != nil {
+		api.Log(ctx, api.LogWarn, "Could not retrieve flags from %s, skipping command line validation: %s", binary, eris.ToString(err, true))
+	} else {
+		validation, err := validateCmdline(cmdline, flags, nil)
+		if err != nil {
+			return err
+		}
 
-			rel, err = storage.LocalMods.GetModRelease(ctx, ID, version)
-			if err != nil {
-				return eris.Wrap(ModMissing{
-					ModID:   ID,
-					Version: version,
-				}, "part of the dependency snapshot is missing")
-			}
+		logFlagValidation(ctx, validation)
+
+		err = checkProfileFlags(prefOpts, flags)
+		if err != nil {
+			return err
 		}
+	}
 
-		// TODO Allow mod authors to specify which (optional) packages from dependencies should be used.
-		// For now, we just use all installed packages.
+	parentFolder := filepath.Join(globalSettings.LibraryPath, "mods")
 
-		for _, pkg := range rel.Packages {
-			if filepath.IsAbs(rel.Folder) || filepath.IsAbs(pkg.Folder) {
-				flagPath, err := filepath.Rel(parentFolder, filepath.Join(rel.Folder, pkg.Folder))
-				if err != nil {
-					return eris.Wrapf(err, "failed to build relative path to %s", filepath.Join(rel.Folder, pkg.Folder))
-				}
+	modFlag, err := buildModFlag(ctx, mod, parentFolder)
+	if err != nil {
+		return err
+	}
 
-				modFlag = append(modFlag, flagPath)
-			} else {
-				modFlag = append(modFlag, filepath.Join(rel.Folder, pkg.Folder))
-			}
+	workDir := parentFolder
+	if prefOpts.Dir != "" {
+		workDir = prefOpts.Dir
+		modFlag, err = relocateModFlag(modFlag, parentFolder, workDir)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -287,7 +480,7 @@ func LaunchMod(ctx context.Context, mod *common.Release, settings *client.UserSe
 		cmdline += "\""
 	}
 
-	cmdlineFile := filepath.Join(fsointerop.GetPrefPath(ctx), "data", "cmdline_fso.cfg")
+	cmdlineFile := filepath.Join(prefPath, "data", "cmdline_fso.cfg")
 	cmdlineFolder := filepath.Dir(cmdlineFile)
 	err = os.MkdirAll(cmdlineFolder, 0o770)
 	if err != nil {
@@ -312,60 +505,75 @@ func LaunchMod(ctx context.Context, mod *common.Release, settings *client.UserSe
 	}
 
 	// Make sure FSO is not running in legacy mode
-	err = touchINI(ctx)
+	err = touchINI(prefPath)
 	if err != nil {
 		return eris.Wrap(err, "failed to touch fs2_open.ini")
 	}
 
-	if runtime.GOOS != "windows" {
-		info, err := os.Stat(binary)
-		if err != nil {
-			return eris.Wrapf(err, "failed to check file permissions for %s", binary)
-		}
-
-		// We assume that the user owns the binary (since we most likely created that file) so we just check if the user
-		// has rwx set on the file.
-		if info.Mode()&0o700 != 0o700 {
-			err = os.Chmod(binary, 0o777)
-			if err != nil {
-				return eris.Wrapf(err, "failed to set executable permission on %s", binary)
-			}
-		}
+	err = ensureExecutable(binary)
+	if err != nil {
+		return err
 	}
 
-	proc := exec.Command(binary)
-	proc.Stdout = os.Stdout
-	proc.Stderr = os.Stderr
-	proc.Dir = parentFolder
-
-	api.Log(ctx, api.LogInfo, "Launching %s in %s", binary, proc.Dir)
+	command, err := wrapCommand(globalSettings, settings, append([]string{binary}, prefOpts.Args...)...)
+	if err != nil {
+		return err
+	}
 
-	err = proc.Start()
+	env, err := buildLaunchEnv(globalSettings, settings)
 	if err != nil {
-		return eris.Wrapf(err, "failed to launch %s", binary)
+		return err
 	}
 
-	running := true
-	go func() {
-		err := proc.Wait()
+	var restoreINI func(context.Context)
+	if settings.GetFsoSettingsProfile() != "" {
+		restoreINI, err = applyFSOSettingsProfile(ctx, prefPath, settings.FsoSettingsProfile)
 		if err != nil {
-			api.Log(ctx, api.LogError, "Failed to launch FSO: %s", eris.ToString(err, true))
+			return err
 		}
+	}
 
-		running = false
-	}()
+	proc := exec.Command(command[0], command[1:]...)
+	proc.Dir = workDir
+	proc.Env = append(env, prefOpts.Env...)
 
-	time.Sleep(3 * time.Second)
+	api.Log(ctx, api.LogInfo, "Launching %s in %s", strings.Join(command, " "), proc.Dir)
 
-	if !running {
-		var code string
-		if runtime.GOOS == "windows" {
-			code = fmt.Sprintf("%x", proc.ProcessState.ExitCode())
-		} else {
-			code = fmt.Sprintf("%d", proc.ProcessState.ExitCode())
+	game, err := superviseGame(ctx, &client.RunningGame{
+		Modid:    mod.Modid,
+		Version:  mod.Version,
+		Label:    label,
+		Binary:   binary,
+		Cmdline:  cmdline,
+		PrefPath: prefPath,
+	}, proc)
+	if err != nil {
+		if restoreINI != nil {
+			restoreINI(ctx)
 		}
+		return err
+	}
+
+	if restoreINI != nil {
+		bgCtx := api.DetachedContext(ctx)
+		go func() {
+			defer api.CrashReporter(bgCtx)
+
+			<-game.done
+			restoreINI(bgCtx)
+		}()
+	}
+
+	settings.LastPlayed = game.info.Started
+	err = storage.SaveUserSettingsForMod(ctx, mod.Modid, mod.Version, settings)
+	if err != nil {
+		api.Log(ctx, api.LogError, "Failed to update last played date for %s: %s", mod.Modid, eris.ToString(err, true))
+	}
 
-		return eris.Errorf("FSO closed after less than three seconds with exit code %s!", code)
+	select {
+	case <-game.done:
+		return eris.Errorf("FSO closed after less than three seconds with exit code %s!", formatExitCode(proc.ProcessState.ExitCode()))
+	case <-time.After(3 * time.Second):
 	}
 
 	return nil
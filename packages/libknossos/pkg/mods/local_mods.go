@@ -0,0 +1,170 @@
+package mods
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+// relinkRelease points the release at the folder its knrelease.json was found in if the stored folder or images
+// don't exist anymore. This happens when a library is moved or restored on another machine. Returns true if anything
+// changed.
+func relinkRelease(rel *common.Release, folder string) bool {
+	changed := false
+	if filepath.IsAbs(rel.Folder) && rel.Folder != folder {
+		_, err := os.Stat(rel.Folder)
+		if err != nil {
+			rel.Folder = folder
+			changed = true
+		}
+	}
+
+	for _, ref := range append([]*common.FileRef{rel.Banner, rel.Teaser}, rel.Screenshots...) {
+		if ref == nil || len(ref.Urls) != 1 || !strings.HasPrefix(ref.Urls[0], "file://") {
+			continue
+		}
+
+		oldPath := filepath.FromSlash(strings.TrimPrefix(ref.Urls[0], "file://"))
+		_, err := os.Stat(oldPath)
+		if err == nil {
+			continue
+		}
+
+		newPath := filepath.Join(folder, filepath.Base(oldPath))
+		_, err = os.Stat(newPath)
+		if err == nil {
+			ref.Urls = []string{"file://" + filepath.ToSlash(newPath)}
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// ReloadLocalMods replaces the local mods with the knmod.json and knrelease.json files found in the library folder
+func ReloadLocalMods(ctx context.Context) error {
+	api.Log(ctx, api.LogInfo, "Looking for knmod.json files")
+
+	settings, err := storage.GetSettings(ctx)
+	if err != nil {
+		return eris.Wrap(err, "failed to load settings")
+	}
+
+	parentFolders, err := os.ReadDir(settings.LibraryPath)
+	if err != nil {
+		return eris.Wrapf(err, "failed to read directory %s", settings.LibraryPath)
+	}
+
+	modInfos := []*common.ModMeta{}
+	releaseInfos := []*common.Release{}
+	relinked := []*common.Release{}
+	for _, parent := range parentFolders {
+		if !parent.IsDir() {
+			continue
+		}
+
+		subDir := filepath.Join(settings.LibraryPath, parent.Name())
+		items, err := os.ReadDir(subDir)
+		if err != nil {
+			return eris.Wrapf(err, "failed to list contents of %s", subDir)
+		}
+
+		for _, item := range items {
+			if item.IsDir() {
+				releasePath := filepath.Join(subDir, item.Name(), "knrelease.json")
+				encodedData, err := os.ReadFile(releasePath)
+				if err != nil {
+					if eris.Is(err, os.ErrNotExist) {
+						// Ignore file not found errors
+						continue
+					}
+
+					return eris.Wrapf(err, "failed to read %s", releasePath)
+				}
+
+				var releaseInfo common.Release
+				err = json.Unmarshal(encodedData, &releaseInfo)
+				if err != nil {
+					return eris.Wrapf(err, "failed to parse %s", releasePath)
+				}
+
+				if relinkRelease(&releaseInfo, filepath.Dir(releasePath)) {
+					api.Log(ctx, api.LogInfo, "Relinked %s %s to %s", releaseInfo.Modid, releaseInfo.Version,
+						filepath.Dir(releasePath))
+
+					encodedData, err = json.MarshalIndent(&releaseInfo, "", "  ")
+					if err != nil {
+						return eris.Wrapf(err, "failed to serialise release %s %s", releaseInfo.Modid,
+							releaseInfo.Version)
+					}
+
+					err = os.WriteFile(releasePath, encodedData, 0o600)
+					if err != nil {
+						return eris.Wrapf(err, "failed to write %s", releasePath)
+					}
+
+					relinked = append(relinked, &releaseInfo)
+				}
+
+				releaseInfos = append(releaseInfos, &releaseInfo)
+			} else if strings.HasPrefix(item.Name(), "knmod-") && strings.HasSuffix(item.Name(), ".json") {
+				modPath := filepath.Join(subDir, item.Name())
+				encodedData, err := os.ReadFile(modPath)
+				if err != nil {
+					return eris.Wrapf(err, "failed to read %s", modPath)
+				}
+
+				var modInfo common.ModMeta
+				err = json.Unmarshal(encodedData, &modInfo)
+				if err != nil {
+					return eris.Wrapf(err, "failed to parse %s", modPath)
+				}
+
+				modInfos = append(modInfos, &modInfo)
+			}
+		}
+	}
+
+	err = storage.ImportMods(ctx, func(ctx context.Context) error {
+		for _, modInfo := range modInfos {
+			err := storage.SaveLocalMod(ctx, modInfo)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, releaseInfo := range releaseInfos {
+			err := storage.SaveLocalModRelease(ctx, releaseInfo)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The file bucket still points to the old image paths
+		for _, rel := range relinked {
+			for _, ref := range append([]*common.FileRef{rel.Banner, rel.Teaser}, rel.Screenshots...) {
+				if ref != nil {
+					err := storage.ImportFile(ctx, ref)
+					if err != nil {
+						return eris.Wrapf(err, "failed to import file ref %s", ref.Fileid)
+					}
+				}
+			}
+		}
+
+		return RestoreCustomEngines(ctx)
+	})
+	if err != nil {
+		return eris.Wrap(err, "failed to import mod metadata")
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package mods
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+// LockfileVersion is the format version written by BuildLockfile. Lockfiles with a higher version are rejected.
+const LockfileVersion = 1
+
+// BuildLockfile collects the exact mod versions and packages used by the passed local release
+func BuildLockfile(ctx context.Context, rel *common.Release, settings *client.UserSettings) (*client.ModLockfile, error) {
+	lock := &client.ModLockfile{
+		FormatVersion:      LockfileVersion,
+		Modid:              rel.Modid,
+		Version:            rel.Version,
+		Mods:               make([]*client.ModLockfile_Mod, 0, len(rel.DependencySnapshot)+1),
+		DependencySnapshot: rel.DependencySnapshot,
+		Cmdline:            settings.GetCmdline(),
+	}
+
+	if lock.Cmdline == "" {
+		lock.Cmdline = rel.Cmdline
+	}
+
+	addMod := func(modRel *common.Release) {
+		pkgs := make([]string, len(modRel.Packages))
+		for idx, pkg := range modRel.Packages {
+			pkgs[idx] = pkg.Name
+		}
+
+		lock.Mods = append(lock.Mods, &client.ModLockfile_Mod{
+			Modid:    modRel.Modid,
+			Version:  modRel.Version,
+			Packages: pkgs,
+		})
+	}
+
+	// Sort the dependencies to make sure that the same setup always produces the same lockfile
+	modIDs := make([]string, 0, len(rel.DependencySnapshot))
+	for modID := range rel.DependencySnapshot {
+		modIDs = append(modIDs, modID)
+	}
+	sort.Strings(modIDs)
+
+	addMod(rel)
+	for _, modID := range modIDs {
+		version := rel.DependencySnapshot[modID]
+		dep, err := storage.LocalMods.GetModRelease(ctx, modID, version)
+		if err != nil {
+			return nil, eris.Wrap(ModMissing{
+				ModID:   modID,
+				Version: version,
+			}, "part of the dependency snapshot is missing")
+		}
+
+		addMod(dep)
+	}
+
+	engOpts := settings.GetEngineOptions()
+	if engOpts.GetModid() != "" {
+		lock.Engine = &client.UserSettings_EngineOptions{
+			Modid:   engOpts.Modid,
+			Version: engOpts.Version,
+		}
+
+		if rel.DependencySnapshot[engOpts.Modid] != engOpts.Version {
+			engine, err := storage.LocalMods.GetModRelease(ctx, engOpts.Modid, engOpts.Version)
+			if err != nil {
+				return nil, eris.Wrap(err, "failed to fetch user engine")
+			}
+
+			addMod(engine)
+		}
+	} else if rel.DependencySnapshot != nil {
+		engine, err := GetEngineForMod(ctx, rel)
+		if err != nil {
+			return nil, err
+		}
+
+		lock.Engine = &client.UserSettings_EngineOptions{
+			Modid:   engine.Modid,
+			Version: engine.Version,
+		}
+	}
+
+	return lock, nil
+}
+
+// WriteLockfile serialises the passed lockfile to the given path
+func WriteLockfile(lock *client.ModLockfile, path string) error {
+	encoded, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return eris.Wrap(err, "failed to serialise lockfile")
+	}
+
+	err = os.WriteFile(path, encoded, 0o600)
+	if err != nil {
+		return eris.Wrapf(err, "failed to write %s", path)
+	}
+
+	return nil
+}
+
+// ReadLockfile parses the lockfile at the given path and makes sure that we understand its format
+func ReadLockfile(path string) (*client.ModLockfile, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s", path)
+	}
+
+	lock := new(client.ModLockfile)
+	err = json.Unmarshal(encoded, lock)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse %s", path)
+	}
+
+	if lock.FormatVersion < 1 || lock.FormatVersion > LockfileVersion {
+		return nil, eris.Errorf("unsupported lockfile version %d (this Knossos supports up to %d)", lock.FormatVersion, LockfileVersion)
+	}
+
+	if lock.Modid == "" || lock.Version == "" {
+		return nil, eris.Errorf("lockfile %s does not specify a mod", path)
+	}
+
+	return lock, nil
+}
+
+// PlanLockfileInstall returns an install request for all mods in the lockfile if any of them (or any of their
+// packages) are missing locally. nil is returned if everything is already installed.
+func PlanLockfileInstall(ctx context.Context, lock *client.ModLockfile) (*client.InstallModRequest, error) {
+	missing := false
+	req := &client.InstallModRequest{
+		Mods: make([]*client.InstallModRequest_Mod, len(lock.Mods)),
+	}
+
+	for idx, mod := range lock.Mods {
+		req.Mods[idx] = &client.InstallModRequest_Mod{
+			Modid:    mod.Modid,
+			Version:  mod.Version,
+			Packages: mod.Packages,
+		}
+
+		if missing {
+			continue
+		}
+
+		rel, err := storage.LocalMods.GetModRelease(ctx, mod.Modid, mod.Version)
+		if err != nil {
+			api.Log(ctx, api.LogInfo, "%s %s is not installed", mod.Modid, mod.Version)
+			missing = true
+			continue
+		}
+
+		present := make(map[string]bool)
+		for _, pkg := range rel.Packages {
+			present[pkg.Name] = true
+		}
+
+		for _, name := range mod.Packages {
+			if !present[name] {
+				api.Log(ctx, api.LogInfo, "Package %s of %s %s is not installed", name, mod.Modid, mod.Version)
+				missing = true
+				break
+			}
+		}
+	}
+
+	if !missing {
+		return nil, nil
+	}
+
+	// InstallMod expects every dependency of the requested mods to be part of the request so we always pass the full
+	// list. Packages that are already present are skipped during the installation.
+	for _, mod := range req.Mods {
+		_, err := storage.RemoteMods.GetModRelease(ctx, mod.Modid, mod.Version)
+		if err != nil {
+			return nil, eris.Wrapf(err, "%s %s is missing and not available from Nebula", mod.Modid, mod.Version)
+		}
+	}
+
+	return req, nil
+}
+
+// ImportLockfile installs everything the lockfile references and applies its dependency snapshot, engine and
+// command line to the local release.
+func ImportLockfile(ctx context.Context, lock *client.ModLockfile) error {
+	api.Log(ctx, api.LogInfo, "Checking installed mods")
+	req, err := PlanLockfileInstall(ctx, lock)
+	if err != nil {
+		return err
+	}
+
+	if req != nil {
+		api.Log(ctx, api.LogInfo, "Installing missing mods")
+		err = InstallMod(ctx, req)
+		if err != nil {
+			return eris.Wrap(err, "failed to install mods from lockfile")
+		}
+	} else {
+		api.Log(ctx, api.LogInfo, "All mods are already installed")
+	}
+
+	rel, err := storage.LocalMods.GetModRelease(ctx, lock.Modid, lock.Version)
+	if err != nil {
+		return eris.Wrapf(err, "failed to load release %s %s", lock.Modid, lock.Version)
+	}
+
+	for modID, version := range lock.DependencySnapshot {
+		_, err = storage.LocalMods.GetModRelease(ctx, modID, version)
+		if err != nil {
+			return eris.Wrap(ModMissing{
+				ModID:   modID,
+				Version: version,
+			}, "lockfile references a mod that is not installed")
+		}
+	}
+
+	rel.DependencySnapshot = lock.DependencySnapshot
+	rel.SnapshotModified = true
+	err = SaveLocalModRelease(ctx, rel)
+	if err != nil {
+		return err
+	}
+
+	userSettings, err := storage.GetUserSettingsForMod(ctx, lock.Modid, lock.Version)
+	if err != nil {
+		return err
+	}
+
+	userSettings.Cmdline = ""
+	if lock.Cmdline != rel.Cmdline {
+		userSettings.Cmdline = lock.Cmdline
+	}
+
+	userSettings.EngineOptions = nil
+	if lock.Engine.GetModid() != "" && lock.DependencySnapshot[lock.Engine.Modid] != lock.Engine.Version {
+		userSettings.EngineOptions = lock.Engine
+	}
+
+	err = storage.SaveUserSettingsForMod(ctx, lock.Modid, lock.Version, userSettings)
+	if err != nil {
+		return err
+	}
+
+	api.Log(ctx, api.LogInfo, "Applied lockfile to %s %s", lock.Modid, lock.Version)
+	return nil
+}
@@ -9,9 +9,14 @@ import (
 	"github.com/ngld/knossos/packages/api/common"
 	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
 	"github.com/rotisserie/eris"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// checksumPackFile contains the checksum pack of an installed release. This allows us to verify the release even if
+// it's no longer available remotely.
+const checksumPackFile = "knchecksums.pb"
+
 func SaveLocalMod(ctx context.Context, mod *common.ModMeta) error {
 	settings, err := storage.GetSettings(ctx)
 	if err != nil {
@@ -74,3 +79,46 @@ func SaveLocalModRelease(ctx context.Context, rel *common.Release) error {
 
 	return nil
 }
+
+// SaveInstalledChecksumPack stores the passed checksum pack in the release's folder
+func SaveInstalledChecksumPack(ctx context.Context, rel *common.Release, pack *common.ChecksumPack) error {
+	modFolder, err := GetModFolder(ctx, rel)
+	if err != nil {
+		return eris.Wrapf(err, "failed to build folder path for %s %s", rel.Modid, rel.Version)
+	}
+
+	encoded, err := proto.Marshal(pack)
+	if err != nil {
+		return eris.Wrapf(err, "failed to serialise checksum pack for %s %s", rel.Modid, rel.Version)
+	}
+
+	packPath := filepath.Join(modFolder, checksumPackFile)
+	err = os.WriteFile(packPath, encoded, 0o600)
+	if err != nil {
+		return eris.Wrapf(err, "failed to write %s for %s %s", packPath, rel.Modid, rel.Version)
+	}
+
+	return nil
+}
+
+// LoadInstalledChecksumPack reads the checksum pack saved by SaveInstalledChecksumPack
+func LoadInstalledChecksumPack(ctx context.Context, rel *common.Release) (*common.ChecksumPack, error) {
+	modFolder, err := GetModFolder(ctx, rel)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to build folder path for %s %s", rel.Modid, rel.Version)
+	}
+
+	packPath := filepath.Join(modFolder, checksumPackFile)
+	encoded, err := os.ReadFile(packPath)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s", packPath)
+	}
+
+	pack := new(common.ChecksumPack)
+	err = proto.Unmarshal(encoded, pack)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse %s", packPath)
+	}
+
+	return pack, nil
+}
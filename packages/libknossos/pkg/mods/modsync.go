@@ -10,8 +10,10 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ngld/knossos/packages/api/client"
 	"github.com/ngld/knossos/packages/api/common"
 	"github.com/ngld/knossos/packages/libknossos/pkg/api"
 	"github.com/ngld/knossos/packages/libknossos/pkg/downloader"
@@ -27,38 +29,54 @@ type modPackInfo struct {
 	idx int
 }
 
+const (
+	// checksumFetchWorkers is the number of checksum packs fetched in parallel by FetchModChecksums
+	checksumFetchWorkers = 4
+	// checksumFetchRetries is the number of attempts for each checksum pack before giving up
+	checksumFetchRetries = 3
+)
+
 var errModNotFound = eris.New("remote mod not found")
 
-func fetchRemoteMessage(ctx context.Context, messageName string, ref protoreflect.ProtoMessage) error {
-	resp, err := helpers.CachedGet(ctx, api.SyncEndpoint+"/"+messageName)
+// fetchRemoteMessage downloads and verifies the signed modsync file messageName and parses it into ref. Returns false
+// and leaves ref untouched if the file hasn't changed since the last request.
+func fetchRemoteMessage(ctx context.Context, repo *client.ModRepository, messageName string, ref protoreflect.ProtoMessage) (bool, error) {
+	url := repo.Url + "/" + messageName
+	resp, err := helpers.CachedGet(ctx, url)
 	if err != nil {
-		return eris.Wrapf(err, "failed to send modsync request to Nebula (%s)", messageName)
+		return false, eris.Wrapf(err, "failed to send modsync request to %s (%s)", repo.Name, messageName)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == 404 {
-		return errModNotFound
+		return false, errModNotFound
 	}
 
 	if resp.StatusCode == 304 {
-		return nil
+		return false, nil
 	}
 
 	if resp.StatusCode != 200 {
-		return eris.Errorf("request to %s failed with status %d", api.SyncEndpoint+"/"+messageName, resp.StatusCode)
+		return false, eris.Errorf("request to %s failed with status %d", url, resp.StatusCode)
 	}
 
 	encoded, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return eris.Wrapf(err, "failed to read %s", messageName)
+		return false, eris.Wrapf(err, "failed to read %s", messageName)
 	}
 
-	err = proto.Unmarshal(encoded, ref)
+	err = parseSignedModsyncMessage(repo, messageName, encoded, ref)
 	if err != nil {
-		return eris.Wrapf(err, "failed to parse response to %s", messageName)
+		// Forget the validators; otherwise we'd receive a 304 for the rejected file during the next sync and assume
+		// that we're up to date.
+		cacheErr := storage.DeleteHTTPCacheEntryForURL(ctx, url)
+		if cacheErr != nil {
+			api.Log(ctx, api.LogWarn, "Failed to reset cache entry for %s: %s", url, cacheErr)
+		}
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 func calcVersionsChecksum(versions []string) ([]byte, error) {
@@ -77,24 +95,95 @@ func calcVersionsChecksum(versions []string) ([]byte, error) {
 	return hasher.Sum(nil), nil
 }
 
+// UpdateRemoteModIndex syncs all enabled repositories. Repositories are processed by priority (highest first) and a
+// mod is only imported from the repository with the highest priority that contains it.
 func UpdateRemoteModIndex(ctx context.Context) error {
+	settings, err := storage.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	repos, err := storage.GetEnabledRepositories(ctx)
+	if err != nil {
+		return err
+	}
+
+	priorities := make(map[string]int32)
+	names := make([]string, len(repos))
+	for idx, repo := range repos {
+		priorities[repo.Name] = repo.Priority
+		names[idx] = repo.Name
+	}
+
+	removed, err := storage.PruneRemoteRepositories(ctx, names)
+	if err != nil {
+		return eris.Wrap(err, "failed to remove mods from disabled repositories")
+	}
+
+	if removed > 0 {
+		api.Log(ctx, api.LogInfo, "Removed %d mods from disabled repositories", removed)
+		// Other repositories might contain these mods as well but we skipped them so far
+		resetRepositoryIndexes(ctx, repos)
+	}
+
+	failed := 0
+	var lastErr error
+	for idx, repo := range repos {
+		released, err := syncRepository(ctx, repo, priorities, settings.LibraryPath)
+		if err != nil {
+			// An unreachable repository shouldn't keep us from updating the others. Its mods stay as they are.
+			api.Log(ctx, api.LogWarn, "Failed to sync repository %s: %s", repo.Name, eris.ToString(err, true))
+			failed++
+			lastErr = err
+			continue
+		}
+
+		if released {
+			resetRepositoryIndexes(ctx, repos[idx+1:])
+		}
+	}
+
+	if failed == len(repos) {
+		return lastErr
+	}
+
+	// The index is usable without images so we don't fail the sync if some of them couldn't be fetched
+	err = PrefetchRemoteImages(ctx)
+	if err != nil {
+		api.Log(ctx, api.LogWarn, "Failed to cache mod images: %s", eris.ToString(err, true))
+	}
+
+	return nil
+}
+
+// resetRepositoryIndexes makes sure that the next sync processes the full index of the passed repositories even if
+// it didn't change. This is necessary once a mod they contain is no longer provided by a repository with a higher
+// priority.
+func resetRepositoryIndexes(ctx context.Context, repos []*client.ModRepository) {
+	for _, repo := range repos {
+		err := storage.DeleteHTTPCacheEntryForURL(ctx, repo.Url+"/index")
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "Failed to reset cache entry for %s: %s", repo.Url, err)
+		}
+	}
+}
+
+// syncRepository imports all mods from repo which aren't provided by an enabled repository with a higher (or the
+// same) priority. Returns true if mods were removed since lower priority repositories might provide them.
+func syncRepository(ctx context.Context, repo *client.ModRepository, priorities map[string]int32, libraryPath string) (bool, error) {
+	released := false
 	resyncNeeded := true
 	for resyncNeeded {
 		resyncNeeded = false
-		err := storage.ImportRemoteMods(ctx, func(ctx context.Context, params storage.RemoteImportCallbackParams) error {
-			curDates, err := storage.GetRemoteModsLastModifiedDates(ctx)
-			if err != nil {
-				return err
-			}
-
-			settings, err := storage.GetSettings(ctx)
+		err := storage.ImportRemoteMods(ctx, repo.Name, func(ctx context.Context, params storage.RemoteImportCallbackParams) error {
+			curDates, err := storage.GetRemoteModsLastModifiedDates(ctx, repo.Name)
 			if err != nil {
 				return err
 			}
 
-			api.Log(ctx, api.LogInfo, "Fetching remote index")
+			api.Log(ctx, api.LogInfo, "Fetching remote index from %s", repo.Name)
 			var index common.ModIndex
-			err = fetchRemoteMessage(ctx, "index", &index)
+			_, err = fetchRemoteMessage(ctx, repo, "index", &index)
 			if err != nil {
 				return eris.Wrap(err, "failed to fetch index")
 			}
@@ -106,22 +195,47 @@ func UpdateRemoteModIndex(ctx context.Context) error {
 			}
 
 			packs := make([]*downloader.QueueItem, 0)
-			tmpFolder := filepath.Join(settings.LibraryPath, "temp")
+			tmpFolder := filepath.Join(libraryPath, "temp")
 			modEntries := make(map[string]modPackInfo)
 
 			err = os.MkdirAll(tmpFolder, 0o777)
 			if err != nil {
-				return eris.Wrapf(err, "failed to create temp folder %s")
+				return eris.Wrapf(err, "failed to create temp folder %s", tmpFolder)
 			}
 
 			// If the mod index hasn't changed since the last time we fetched it, index will be the zero value of common.ModIndex
 			// this means that index.Mods is an empty list which means we do nothing which is exactly what we want.
+			owned := make([]*common.ModIndex_Mod, 0, len(index.Mods))
 			for _, entry := range index.Mods {
+				owner := params.ModRepository(entry.Modid)
+				if owner != "" && owner != repo.Name {
+					ownerPriority, enabled := priorities[owner]
+					if enabled && ownerPriority >= repo.Priority {
+						// Forget the dates so that we import the mod once the other repository drops it
+						delete(curDates, entry.Modid)
+						continue
+					}
+
+					api.Log(ctx, api.LogInfo, "Replacing %s from %s with the version from %s", entry.Modid, owner, repo.Name)
+					err = params.RemoveMod(entry.Modid)
+					if err != nil {
+						return eris.Wrapf(err, "failed to remove mod %s", entry.Modid)
+					}
+					delete(curDates, entry.Modid)
+				}
+				owned = append(owned, entry)
+
 				curModDates, found := curDates[entry.Modid]
-				if !found || err != nil {
+				if !found {
 					curModDates = make([]time.Time, len(entry.PacksLastModified)+1)
 				}
 
+				// The signed index contains the checksums for all files we download below. Refuse to import files that
+				// aren't covered.
+				if len(entry.MetaChecksum) == 0 || len(entry.PackChecksums) != len(entry.PacksLastModified) {
+					return eris.Errorf("index entry for %s is missing file checksums", entry.Modid)
+				}
+
 				if !curModDates[0].Equal(entry.LastModified.AsTime()) {
 					key := "mod-" + entry.Modid
 					modEntries[key] = modPackInfo{
@@ -131,8 +245,8 @@ func UpdateRemoteModIndex(ctx context.Context) error {
 					packs = append(packs, &downloader.QueueItem{
 						Key:      key,
 						Filepath: filepath.Join(tmpFolder, "modsync-"+key),
-						Mirrors:  []string{api.SyncEndpoint + "/m." + entry.Modid},
-						Checksum: nil,
+						Mirrors:  []string{repo.Url + "/m." + entry.Modid},
+						Checksum: entry.MetaChecksum,
 						Filesize: 0,
 					})
 				}
@@ -147,8 +261,8 @@ func UpdateRemoteModIndex(ctx context.Context) error {
 						packs = append(packs, &downloader.QueueItem{
 							Key:      key,
 							Filepath: filepath.Join(tmpFolder, "modsync-"+key),
-							Mirrors:  []string{fmt.Sprintf("%s/m.%s.%03d", api.SyncEndpoint, entry.Modid, idx)},
-							Checksum: nil,
+							Mirrors:  []string{fmt.Sprintf("%s/m.%s.%03d", repo.Url, entry.Modid, idx)},
+							Checksum: entry.PackChecksums[idx],
 							Filesize: 0,
 						})
 					}
@@ -236,6 +350,9 @@ func UpdateRemoteModIndex(ctx context.Context) error {
 			seen := make(map[string]bool)
 			for _, entry := range index.Mods {
 				seen[entry.Modid] = true
+			}
+
+			for _, entry := range owned {
 
 				localVersions, err := storage.RemoteMods.GetVersionsForMod(ctx, entry.Modid)
 				if err == nil {
@@ -260,18 +377,21 @@ func UpdateRemoteModIndex(ctx context.Context) error {
 			}
 
 			for _, rel := range releases {
-				if !seen[rel.Modid] {
+				if !seen[rel.Modid] && params.ModRepository(rel.Modid) == repo.Name {
 					api.Log(ctx, api.LogInfo, "Removing %s", rel.Modid)
 					err = params.RemoveMod(rel.Modid)
 					if err != nil {
 						return eris.Wrapf(err, "failed to remove mod %s", rel.Modid)
 					}
+
+					delete(curDates, rel.Modid)
+					released = true
 				}
 			}
 
 			api.SetProgress(ctx, 1, "Finishing")
 
-			err = storage.UpdateRemoteModsLastModifiedDates(ctx, curDates)
+			err = storage.UpdateRemoteModsLastModifiedDates(ctx, repo.Name, curDates)
 			if err == nil {
 				api.Log(ctx, api.LogInfo, "Done")
 			}
@@ -279,7 +399,7 @@ func UpdateRemoteModIndex(ctx context.Context) error {
 			return err
 		})
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		if resyncNeeded {
@@ -287,49 +407,162 @@ func UpdateRemoteModIndex(ctx context.Context) error {
 		}
 	}
 
-	return nil
+	return released, nil
 }
 
-func FetchModChecksums(ctx context.Context, modVersions map[string]string) (map[string]*common.ChecksumPack, error) {
-	tempFolder, err := os.MkdirTemp("", "knossos-chk-dl")
+// getModRepository returns the repository which provided the passed remote mod. Mods which aren't available anymore
+// (or come from a disabled repository) are looked up in the repository with the highest priority.
+func getModRepository(ctx context.Context, modID string, repos []*client.ModRepository) (*client.ModRepository, error) {
+	name, err := storage.GetRemoteModRepository(ctx, modID)
 	if err != nil {
-		return nil, eris.Wrap(err, "failed to create temp folder for checksums")
+		return nil, err
 	}
-	defer os.RemoveAll(tempFolder)
 
-	queueItems := make([]*downloader.QueueItem, 0, len(modVersions))
-	for modID, version := range modVersions {
-		queueItems = append(queueItems, &downloader.QueueItem{
-			Key:      modID,
-			Filepath: filepath.Join(tempFolder, fmt.Sprintf("c.%s.%s", modID, version)),
-			Mirrors:  []string{fmt.Sprintf("%s/c.%s.%s", api.SyncEndpoint, modID, version)},
-		})
+	for _, repo := range repos {
+		if repo.Name == name {
+			return repo, nil
+		}
 	}
 
-	queue, err := downloader.NewQueue(ctx, queueItems)
-	if err != nil {
-		return nil, eris.Wrap(err, "failed to init download queue")
+	if len(repos) == 0 {
+		return nil, eris.New("no repository is enabled")
 	}
 
-	err = queue.Run(ctx)
+	return repos[0], nil
+}
+
+// fetchChecksumPack returns the checksum pack for the passed release. A cached pack is revalidated with the
+// repository and used as is if the repository can't provide a (valid) copy, i.e. because we're offline.
+func fetchChecksumPack(ctx context.Context, repo *client.ModRepository, modID, version string) (*common.ChecksumPack, error) {
+	cached, err := storage.GetChecksumPack(ctx, modID, version)
 	if err != nil {
-		return nil, eris.Wrap(err, "failed to download checksums")
+		return nil, err
 	}
 
-	result := make(map[string]*common.ChecksumPack)
-	for _, item := range queueItems {
-		encoded, err := os.ReadFile(item.Filepath)
+	name := fmt.Sprintf("c.%s.%s", modID, version)
+	if cached == nil {
+		// Without a cached pack, we can't do anything with a 304 response
+		err = storage.DeleteHTTPCacheEntryForURL(ctx, repo.Url+"/"+name)
 		if err != nil {
-			return nil, eris.Wrapf(err, "failed to read downloaded checksum file %s", item.Filepath)
+			return nil, err
+		}
+	}
+
+	pack := new(common.ChecksumPack)
+	var modified bool
+	for try := 1; ; try++ {
+		modified, err = fetchRemoteMessage(ctx, repo, name, pack)
+		// Missing and untrusted packs won't change if we ask again
+		if err == nil || try >= checksumFetchRetries || ctx.Err() != nil || eris.Is(err, errModNotFound) ||
+			eris.Is(err, ErrUntrustedModsyncFile) {
+			break
+		}
+
+		api.Log(ctx, api.LogWarn, "Failed to fetch checksums for %s %s (%v), trying again", modID, version, err)
+		time.Sleep(time.Duration(try) * 500 * time.Millisecond)
+	}
+	if err != nil {
+		if cached != nil {
+			api.Log(ctx, api.LogWarn, "Failed to revalidate checksums for %s %s, using the cached copy: %s", modID,
+				version, err)
+			return cached, nil
+		}
+
+		return nil, eris.Wrapf(err, "failed to fetch checksums for %s %s", modID, version)
+	}
+
+	if !modified {
+		if cached == nil {
+			return nil, eris.Errorf("received no checksums for %s %s", modID, version)
 		}
+		return cached, nil
+	}
+
+	err = storage.SaveChecksumPack(ctx, modID, version, pack)
+	if err != nil {
+		return nil, err
+	}
+
+	return pack, nil
+}
 
-		data := new(common.ChecksumPack)
-		err = proto.Unmarshal(encoded, data)
+// FetchModChecksums returns the checksum packs for the passed mod versions. The packs are fetched in parallel from the
+// repositories that provided the mods and cached in the state DB.
+func FetchModChecksums(ctx context.Context, modVersions map[string]string) (map[string]*common.ChecksumPack, error) {
+	repos, err := storage.GetEnabledRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type checksumJob struct {
+		repo    *client.ModRepository
+		modID   string
+		version string
+	}
+
+	jobs := make([]checksumJob, 0, len(modVersions))
+	for modID, version := range modVersions {
+		repo, err := getModRepository(ctx, modID, repos)
 		if err != nil {
-			return nil, eris.Wrapf(err, "failed to parse checksum file %s", item.Filepath)
+			return nil, err
+		}
+
+		jobs = append(jobs, checksumJob{repo: repo, modID: modID, version: version})
+	}
+
+	workers := checksumFetchWorkers
+	if len(jobs) < workers {
+		workers = len(jobs)
+	}
+	if storage.TxFromCtx(ctx) != nil {
+		// A transaction must not be used by multiple goroutines at once
+		workers = 1
+	}
+
+	result := make(map[string]*common.ChecksumPack, len(jobs))
+	var firstErr error
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	queue := make(chan checksumJob)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				pack, err := fetchChecksumPack(ctx, job.repo, job.modID, job.version)
+
+				lock.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					result[job.modID] = pack
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		lock.Lock()
+		failed := firstErr != nil
+		lock.Unlock()
+
+		if failed || ctx.Err() != nil {
+			break
 		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
 
-		result[item.Key] = data
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, eris.Wrap(ctx.Err(), "checksum download was cancelled")
 	}
 
 	return result, nil
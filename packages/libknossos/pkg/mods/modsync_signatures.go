@@ -0,0 +1,132 @@
+package mods
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/rotisserie/eris"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+// modsyncSignaturePrefix has to match the prefix used by Nebula's exporter
+const modsyncSignaturePrefix = "knossos-modsync\x00"
+
+var (
+	// ErrUntrustedModsyncFile is returned if a modsync file isn't signed by any of our pinned keys
+	ErrUntrustedModsyncFile = eris.New("modsync file has no valid signature from a trusted key")
+
+	pinnedKeysOnce sync.Once
+	pinnedKeys     map[string]ed25519.PublicKey
+	pinnedKeysErr  error
+)
+
+func modsyncKeyID(pub ed25519.PublicKey) string {
+	digest := sha256.Sum256(pub)
+	return hex.EncodeToString(digest[:8])
+}
+
+func parseModsyncKeys(encodedKeys []string) (map[string]ed25519.PublicKey, error) {
+	keys := make(map[string]ed25519.PublicKey, len(encodedKeys))
+	for _, encoded := range encodedKeys {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to decode modsync key %s", encoded)
+		}
+
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, eris.Errorf("modsync key %s has an invalid length (%d)", encoded, len(raw))
+		}
+
+		key := ed25519.PublicKey(raw)
+		keys[modsyncKeyID(key)] = key
+	}
+
+	return keys, nil
+}
+
+// getPinnedModsyncKeys returns the keys from api.SyncKeys indexed by their ID
+func getPinnedModsyncKeys() (map[string]ed25519.PublicKey, error) {
+	pinnedKeysOnce.Do(func() {
+		pinnedKeys, pinnedKeysErr = parseModsyncKeys(api.SyncKeys)
+	})
+
+	return pinnedKeys, pinnedKeysErr
+}
+
+// getRepositoryKeys returns the pinned keys and the keys the user configured for repo
+func getRepositoryKeys(repo *client.ModRepository) (map[string]ed25519.PublicKey, error) {
+	pinned, err := getPinnedModsyncKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	repoKeys, err := parseModsyncKeys(repo.Keys)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to load the keys for repository %s", repo.Name)
+	}
+
+	for id, key := range pinned {
+		repoKeys[id] = key
+	}
+
+	if len(repoKeys) == 0 {
+		return nil, eris.Errorf("there are no trusted keys for repository %s", repo.Name)
+	}
+
+	return repoKeys, nil
+}
+
+// verifyModsyncMessage checks the signatures in the passed SignedMessage and returns its payload if at least one of
+// them is a valid signature from a key in keys. Signatures from unknown keys are ignored since Nebula might already
+// sign with a key that this build doesn't know yet.
+func verifyModsyncMessage(name string, encoded []byte, keys map[string]ed25519.PublicKey) ([]byte, error) {
+	var signed common.SignedMessage
+	err := proto.Unmarshal(encoded, &signed)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse signed %s", name)
+	}
+
+	data := make([]byte, 0, len(modsyncSignaturePrefix)+len(name)+1+len(signed.Payload))
+	data = append(data, modsyncSignaturePrefix...)
+	data = append(data, name...)
+	data = append(data, 0)
+	data = append(data, signed.Payload...)
+
+	for _, sig := range signed.Signatures {
+		key, found := keys[sig.KeyId]
+		if found && ed25519.Verify(key, data, sig.Signature) {
+			return signed.Payload, nil
+		}
+	}
+
+	return nil, eris.Wrapf(ErrUntrustedModsyncFile, "failed to verify %s", name)
+}
+
+// parseSignedModsyncMessage verifies the signed modsync file name against the keys trusted for repo and parses its
+// payload into ref
+func parseSignedModsyncMessage(repo *client.ModRepository, name string, encoded []byte, ref proto.Message) error {
+	keys, err := getRepositoryKeys(repo)
+	if err != nil {
+		return err
+	}
+
+	payload, err := verifyModsyncMessage(name, encoded, keys)
+	if err != nil {
+		return err
+	}
+
+	err = proto.Unmarshal(payload, ref)
+	if err != nil {
+		return eris.Wrapf(err, "failed to parse %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+package mods
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/pilotfile"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+const (
+	// defaultPilotBackups is used if the user didn't configure a limit
+	defaultPilotBackups = 10
+	snapshotTimeFormat  = "20060102-150405.000"
+	snapshotMetaFile    = "snapshot.json"
+)
+
+type pilotSnapshotMeta struct {
+	PrefPath string
+	Modid    string
+	Version  string
+	Created  time.Time
+	Files    []string
+	Size     uint64
+}
+
+// getPrefKey returns the name of the folder that contains the snapshots for the given pref profile
+func getPrefKey(profile string) string {
+	if profile == "" {
+		return "shared"
+	}
+
+	return "profile-" + profile
+}
+
+func getSnapshotRoot(ctx context.Context, prefKey string) string {
+	return filepath.Join(api.SettingsPath(ctx), "pilot_backups", prefKey)
+}
+
+func getPilotBackupLimit(ctx context.Context) int {
+	settings, err := storage.GetSettings(ctx)
+	if err != nil || settings.PilotBackups == 0 {
+		return defaultPilotBackups
+	}
+
+	return int(settings.PilotBackups)
+}
+
+// listPlayerFiles returns the paths of all files in the players folder relative to that folder
+func listPlayerFiles(playersPath string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(playersPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			rel, err := filepath.Rel(playersPath, path)
+			if err != nil {
+				return eris.Wrapf(err, "failed to build relative path for %s", path)
+			}
+
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		if eris.Is(err, os.ErrNotExist) {
+			return files, nil
+		}
+		return nil, eris.Wrapf(err, "failed to list %s", playersPath)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// isPilotFile returns true if the passed file is a pilot or campaign save file
+func isPilotFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".plr", ".json", ".csg":
+		return true
+	default:
+		return false
+	}
+}
+
+func copyFile(src, dest string) (int64, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return 0, eris.Wrapf(err, "failed to read %s", src)
+	}
+
+	err = os.MkdirAll(filepath.Dir(dest), 0o770)
+	if err != nil {
+		return 0, eris.Wrapf(err, "failed to create %s", filepath.Dir(dest))
+	}
+
+	err = os.WriteFile(dest, data, 0o600)
+	if err != nil {
+		return 0, eris.Wrapf(err, "failed to write %s", dest)
+	}
+
+	return int64(len(data)), nil
+}
+
+func readSnapshotMeta(folder string) (*pilotSnapshotMeta, error) {
+	data, err := os.ReadFile(filepath.Join(folder, snapshotMetaFile))
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read snapshot %s", folder)
+	}
+
+	meta := new(pilotSnapshotMeta)
+	err = json.Unmarshal(data, meta)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse snapshot %s", folder)
+	}
+
+	return meta, nil
+}
+
+// listSnapshotFolders returns the snapshot folders for the given pref key, newest first
+func listSnapshotFolders(ctx context.Context, prefKey string) ([]string, error) {
+	root := getSnapshotRoot(ctx, prefKey)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if eris.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, eris.Wrapf(err, "failed to list %s", root)
+	}
+
+	folders := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			folders = append(folders, filepath.Join(root, entry.Name()))
+		}
+	}
+
+	// The folder names are timestamps so sorting them by name sorts them by date
+	sort.Sort(sort.Reverse(sort.StringSlice(folders)))
+	return folders, nil
+}
+
+// snapshotMatches returns true if the snapshot in folder contains exactly the passed files with the same contents
+func snapshotMatches(folder, playersPath string, files []string) bool {
+	meta, err := readSnapshotMeta(folder)
+	if err != nil || len(meta.Files) != len(files) {
+		return false
+	}
+
+	for idx, file := range files {
+		if meta.Files[idx] != file {
+			return false
+		}
+
+		current, err := os.ReadFile(filepath.Join(playersPath, file))
+		if err != nil {
+			return false
+		}
+
+		saved, err := os.ReadFile(filepath.Join(folder, "files", file))
+		if err != nil || !bytes.Equal(current, saved) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func prunePilotSnapshots(ctx context.Context, prefKey string) {
+	limit := getPilotBackupLimit(ctx)
+	if limit < 0 {
+		// Snapshots are disabled; keep whatever the user saved before
+		return
+	}
+
+	folders, err := listSnapshotFolders(ctx, prefKey)
+	if err != nil {
+		api.Log(ctx, api.LogWarn, "Failed to list pilot snapshots: %s", err)
+		return
+	}
+
+	if len(folders) <= limit {
+		return
+	}
+
+	for _, folder := range folders[limit:] {
+		err = os.RemoveAll(folder)
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "Failed to delete old pilot snapshot %s: %s", folder, err)
+		}
+	}
+}
+
+// snapshotPilots copies all pilot and campaign files in prefPath into a new snapshot unless they didn't change since
+// the last snapshot. Callers should call prunePilotSnapshots() afterwards.
+func snapshotPilots(ctx context.Context, prefPath, prefKey, modID, version string) error {
+	if getPilotBackupLimit(ctx) < 0 {
+		return nil
+	}
+
+	playersPath := pilotfile.GetPlayersPath(prefPath)
+	files, err := listPlayerFiles(playersPath)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	folders, err := listSnapshotFolders(ctx, prefKey)
+	if err != nil {
+		return err
+	}
+
+	if len(folders) > 0 && snapshotMatches(folders[0], playersPath, files) {
+		api.Log(ctx, api.LogInfo, "Pilot files didn't change since the last snapshot")
+		return nil
+	}
+
+	created := time.Now()
+	folder := filepath.Join(getSnapshotRoot(ctx, prefKey), created.Format(snapshotTimeFormat))
+	meta := pilotSnapshotMeta{
+		PrefPath: prefPath,
+		Modid:    modID,
+		Version:  version,
+		Created:  created,
+		Files:    files,
+	}
+
+	for _, file := range files {
+		size, err := copyFile(filepath.Join(playersPath, file), filepath.Join(folder, "files", file))
+		if err != nil {
+			// Don't leave an incomplete snapshot behind
+			os.RemoveAll(folder)
+			return err
+		}
+
+		meta.Size += uint64(size)
+	}
+
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return eris.Wrap(err, "failed to serialise snapshot metadata")
+	}
+
+	err = os.WriteFile(filepath.Join(folder, snapshotMetaFile), encoded, 0o600)
+	if err != nil {
+		return eris.Wrapf(err, "failed to write snapshot metadata to %s", folder)
+	}
+
+	api.Log(ctx, api.LogInfo, "Saved %d pilot files to %s", len(files), folder)
+	return nil
+}
+
+func getPrefKeyForMod(ctx context.Context, modID, version string) (string, error) {
+	if modID == "" {
+		return getPrefKey(""), nil
+	}
+
+	settings, err := storage.GetUserSettingsForMod(ctx, modID, version)
+	if err != nil {
+		return "", err
+	}
+
+	return getPrefKey(settings.GetPrefProfile()), nil
+}
+
+// ListPilotSnapshots returns all snapshots for the pref path used by the given mod (or the shared pref path if modID
+// is empty), newest first
+func ListPilotSnapshots(ctx context.Context, modID, version string) ([]*client.PilotSnapshot, error) {
+	prefKey, err := getPrefKeyForMod(ctx, modID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := listSnapshotFolders(ctx, prefKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*client.PilotSnapshot, 0, len(folders))
+	for _, folder := range folders {
+		meta, err := readSnapshotMeta(folder)
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "Skipping broken pilot snapshot: %s", err)
+			continue
+		}
+
+		result = append(result, &client.PilotSnapshot{
+			Id:       prefKey + "/" + filepath.Base(folder),
+			PrefPath: meta.PrefPath,
+			Modid:    meta.Modid,
+			Version:  meta.Version,
+			Created:  timestamppb.New(meta.Created),
+			Files:    meta.Files,
+			Size:     meta.Size,
+		})
+	}
+
+	return result, nil
+}
+
+// RestorePilotSnapshot copies the files from the given snapshot back into its pref path and removes pilots and
+// campaigns which aren't part of it. The current files are saved in a new snapshot first.
+func RestorePilotSnapshot(ctx context.Context, id string) error {
+	parts := strings.Split(id, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(id, "..") || strings.Contains(id, "\\") {
+		return eris.Errorf("invalid snapshot ID %s", id)
+	}
+
+	prefKey := parts[0]
+	folder := filepath.Join(getSnapshotRoot(ctx, prefKey), parts[1])
+	meta, err := readSnapshotMeta(folder)
+	if err != nil {
+		return err
+	}
+
+	for _, game := range GetRunningGames() {
+		if game.PrefPath == meta.PrefPath {
+			return eris.Errorf("can't restore pilots while %s is running", game.Modid)
+		}
+	}
+
+	err = snapshotPilots(ctx, meta.PrefPath, prefKey, "", "")
+	if err != nil {
+		return eris.Wrap(err, "failed to back up the current pilot files")
+	}
+
+	playersPath := pilotfile.GetPlayersPath(meta.PrefPath)
+	restored := make(map[string]bool, len(meta.Files))
+	for _, file := range meta.Files {
+		_, err = copyFile(filepath.Join(folder, "files", file), filepath.Join(playersPath, file))
+		if err != nil {
+			return err
+		}
+
+		restored[file] = true
+	}
+
+	// Pilots and campaigns created after the snapshot have to go as well; otherwise a JSON pilot written by a newer
+	// FSO would hide the restored .plr.
+	current, err := listPlayerFiles(playersPath)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range current {
+		if restored[file] || !isPilotFile(file) {
+			continue
+		}
+
+		if getPilotBackupLimit(ctx) < 0 {
+			// Without the snapshot above, deleting the file would lose it
+			api.Log(ctx, api.LogWarn, "Keeping %s since it isn't part of the snapshot and snapshots are disabled", file)
+			continue
+		}
+
+		err = os.Remove(filepath.Join(playersPath, file))
+		if err != nil {
+			return eris.Wrapf(err, "failed to remove %s", file)
+		}
+	}
+
+	api.Log(ctx, api.LogInfo, "Restored %d pilot files from %s", len(meta.Files), id)
+
+	// Pruning before the restore could have deleted the snapshot we were restoring
+	prunePilotSnapshots(ctx, prefKey)
+	return nil
+}
@@ -0,0 +1,289 @@
+package mods
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+// keepGameLogs is the number of game logs we keep around; older logs are deleted whenever a new game is launched.
+const keepGameLogs = 20
+
+type supervisedGame struct {
+	info   *client.RunningGame
+	proc   *exec.Cmd
+	done   chan struct{}
+	killed bool
+}
+
+var (
+	gamesLock  = sync.Mutex{}
+	games      = make(map[uint32]*supervisedGame)
+	lastGameID = uint32(0)
+)
+
+// IsGameRunning returns true if a supervised game process for the given mod is still running. Tools like FRED are
+// ignored.
+func IsGameRunning(modID string) bool {
+	gamesLock.Lock()
+	defer gamesLock.Unlock()
+
+	for _, game := range games {
+		if game.info.Modid == modID && !game.info.Tool {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetRunningGames returns info about all game processes that are currently running
+func GetRunningGames() []*client.RunningGame {
+	gamesLock.Lock()
+	defer gamesLock.Unlock()
+
+	result := make([]*client.RunningGame, 0, len(games))
+	for _, game := range games {
+		result = append(result, game.info)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result
+}
+
+// KillGame terminates the game process with the given ID
+func KillGame(ctx context.Context, id uint32) error {
+	gamesLock.Lock()
+	game, ok := games[id]
+	if ok {
+		game.killed = true
+	}
+	gamesLock.Unlock()
+
+	if !ok {
+		return eris.Errorf("game %d is not running", id)
+	}
+
+	api.Log(ctx, api.LogInfo, "Killing %s (%d)", game.info.Binary, game.info.Pid)
+	err := game.proc.Process.Kill()
+	if err != nil {
+		return eris.Wrapf(err, "failed to kill process %d", game.info.Pid)
+	}
+
+	return nil
+}
+
+func formatExitCode(code int) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("%x", code)
+	}
+
+	return fmt.Sprintf("%d", code)
+}
+
+func dispatchGameEvent(ctx context.Context, eventType client.GameEvent_EventType, info *client.RunningGame, exitCode int, elapsed time.Duration) {
+	err := api.DispatchMessage(ctx, &client.ClientSentEvent{
+		Payload: &client.ClientSentEvent_Game{
+			Game: &client.GameEvent{
+				Type:     eventType,
+				Game:     info,
+				ExitCode: int32(exitCode),
+				Runtime:  float32(elapsed.Seconds()),
+			},
+		},
+	})
+	if err != nil {
+		api.Log(ctx, api.LogError, "Failed to dispatch game event: %s", eris.ToString(err, true))
+	}
+}
+
+func saveLaunchHistory(ctx context.Context, entry *client.LaunchHistoryEntry) {
+	// The game is already running at this point so we only log failures instead of aborting.
+	err := storage.SaveLaunchHistoryEntry(ctx, entry)
+	if err != nil {
+		api.Log(ctx, api.LogError, "Failed to record launch of %s: %s", entry.Modid, eris.ToString(err, true))
+	}
+}
+
+func createGameLog(ctx context.Context, info *client.RunningGame) (*os.File, error) {
+	logFolder := filepath.Join(api.SettingsPath(ctx), "logs")
+	err := os.MkdirAll(logFolder, 0o770)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to create %s", logFolder)
+	}
+
+	pruneGameLogs(ctx, logFolder)
+
+	name := fmt.Sprintf("%s-%s-%s.log", info.Modid, info.Version, time.Now().Format("20060102-150405"))
+	info.LogPath = filepath.Join(logFolder, name)
+
+	f, err := os.Create(info.LogPath)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to create %s", info.LogPath)
+	}
+
+	return f, nil
+}
+
+func pruneGameLogs(ctx context.Context, logFolder string) {
+	entries, err := os.ReadDir(logFolder)
+	if err != nil {
+		api.Log(ctx, api.LogWarn, "Failed to list %s: %s", logFolder, err)
+		return
+	}
+
+	type logInfo struct {
+		name     string
+		modified time.Time
+	}
+
+	logs := make([]logInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		logs = append(logs, logInfo{name: entry.Name(), modified: info.ModTime()})
+	}
+
+	// The new log will be created after this so we keep one less.
+	if len(logs) < keepGameLogs {
+		return
+	}
+
+	sort.Slice(logs, func(i, j int) bool { return logs[i].modified.After(logs[j].modified) })
+	for _, item := range logs[keepGameLogs-1:] {
+		err = os.Remove(filepath.Join(logFolder, item.name))
+		if err != nil {
+			api.Log(ctx, api.LogWarn, "Failed to delete old log %s: %s", item.name, err)
+		}
+	}
+}
+
+// superviseGame starts the passed process, redirects its output to a new log file and tracks it until it exits.
+// Only one game per mod may run at the same time; tools may run alongside it but only once per binary.
+func superviseGame(ctx context.Context, info *client.RunningGame, proc *exec.Cmd) (*supervisedGame, error) {
+	gamesLock.Lock()
+	for _, game := range games {
+		conflict := game.info.Tool == info.Tool
+		if info.Tool {
+			conflict = conflict && game.info.Binary == info.Binary
+		}
+
+		if game.info.Modid == info.Modid && conflict {
+			gamesLock.Unlock()
+			return nil, eris.Errorf("%s %s is already running", game.info.Modid, game.info.Version)
+		}
+	}
+
+	logFile, err := createGameLog(ctx, info)
+	if err != nil {
+		gamesLock.Unlock()
+		return nil, err
+	}
+
+	proc.Stdout = logFile
+	proc.Stderr = logFile
+
+	err = proc.Start()
+	if err != nil {
+		gamesLock.Unlock()
+		logFile.Close()
+		return nil, eris.Wrapf(err, "failed to launch %s", info.Binary)
+	}
+
+	lastGameID++
+	info.Id = lastGameID
+	info.Pid = int32(proc.Process.Pid)
+	info.Started = timestamppb.Now()
+
+	game := &supervisedGame{
+		info: info,
+		proc: proc,
+		done: make(chan struct{}),
+	}
+	games[info.Id] = game
+	gamesLock.Unlock()
+
+	// The request context is cancelled once LaunchMod returns but we need to keep logging after that.
+	bgCtx := api.DetachedContext(ctx)
+	dispatchGameEvent(bgCtx, client.GameEvent_STARTED, info, 0, 0)
+
+	history := &client.LaunchHistoryEntry{
+		Modid:   info.Modid,
+		Version: info.Version,
+		Label:   info.Label,
+		Binary:  info.Binary,
+		Cmdline: info.Cmdline,
+		Started: info.Started,
+		Status:  client.GameEvent_STARTED,
+	}
+	// Tools don't count as playtime
+	if !info.Tool {
+		saveLaunchHistory(bgCtx, history)
+	}
+
+	go func() {
+		defer api.CrashReporter(bgCtx)
+		defer close(game.done)
+
+		waitErr := proc.Wait()
+		elapsed := time.Since(info.Started.AsTime())
+		exitCode := proc.ProcessState.ExitCode()
+
+		err := logFile.Close()
+		if err != nil {
+			api.Log(bgCtx, api.LogWarn, "Failed to close %s: %s", info.LogPath, err)
+		}
+
+		gamesLock.Lock()
+		delete(games, info.Id)
+		killed := game.killed
+		gamesLock.Unlock()
+
+		eventType := client.GameEvent_EXITED
+		switch {
+		case killed:
+			eventType = client.GameEvent_KILLED
+			api.Log(bgCtx, api.LogInfo, "%s was killed after %s", info.Binary, elapsed.Round(time.Second))
+		case waitErr != nil || exitCode != 0:
+			eventType = client.GameEvent_CRASHED
+			api.Log(bgCtx, api.LogError, "%s exited with code %s after %s; see %s for details", info.Binary,
+				formatExitCode(exitCode), elapsed.Round(time.Second), info.LogPath)
+
+			// Analyze the log before sending the event so that the diagnosis is ready once the UI asks for it.
+			diagnoseCrash(bgCtx, info, proc.Dir, exitCode)
+		default:
+			api.Log(bgCtx, api.LogInfo, "%s exited after %s", info.Binary, elapsed.Round(time.Second))
+		}
+
+		history.Ended = timestamppb.Now()
+		history.ExitCode = int32(exitCode)
+		history.Status = eventType
+		if !info.Tool {
+			saveLaunchHistory(bgCtx, history)
+		}
+
+		dispatchGameEvent(bgCtx, eventType, info, exitCode, elapsed)
+	}()
+
+	return game, nil
+}
@@ -0,0 +1,151 @@
+package mods
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/fsointerop"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func isFREDLabel(label string) bool {
+	return strings.Contains(strings.ToUpper(label), "FRED")
+}
+
+// GetToolsForMod lists every executable of the engine the user selected for the passed mod which runs on this system
+func GetToolsForMod(ctx context.Context, mod *common.Release, settings *client.UserSettings) ([]*client.ToolInfo, error) {
+	engine, err := getUserEngineForMod(ctx, mod, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	tools := make([]*client.ToolInfo, 0)
+	for _, pkg := range FilterUnsupportedPackages(ctx, engine.Packages) {
+		for _, exe := range pkg.Executables {
+			tools = append(tools, &client.ToolInfo{
+				Label:   exe.Label,
+				Id:      engine.Modid,
+				Debug:   exe.Debug,
+				Fred:    isFREDLabel(exe.Label),
+				Path:    filepath.ToSlash(filepath.Join(pkg.Folder, exe.Path)),
+				Version: engine.Version,
+			})
+		}
+	}
+
+	// Keep the executables with the same label together
+	sort.SliceStable(tools, func(i, j int) bool { return tools[i].Label < tools[j].Label })
+	return tools, nil
+}
+
+// LaunchTool launches the executable at toolPath (as returned by GetToolsForMod) for the passed mod. FSO builds
+// (i.e. debug builds) are launched like the game while FRED and qtFRED receive the mod's -mod list as arguments.
+func LaunchTool(ctx context.Context, mod *common.Release, settings *client.UserSettings, toolPath string) error {
+	err := refreshUserEngine(ctx, settings)
+	if err != nil {
+		return err
+	}
+
+	engine, err := getUserEngineForMod(ctx, mod, settings)
+	if err != nil {
+		return err
+	}
+
+	var tool *common.EngineExecutable
+	binary := ""
+	for _, pkg := range FilterUnsupportedPackages(ctx, engine.Packages) {
+		for _, exe := range pkg.Executables {
+			if filepath.ToSlash(filepath.Join(pkg.Folder, exe.Path)) == toolPath {
+				tool = exe
+				binary = smartJoin(engine.Folder, pkg.Folder, exe.Path)
+			}
+		}
+	}
+
+	if tool == nil {
+		return eris.Errorf("%s is not part of %s %s", toolPath, engine.Modid, engine.Version)
+	}
+
+	globalSettings, err := storage.GetSettings(ctx)
+	if err != nil {
+		return eris.Wrap(err, "failed to load settings")
+	}
+
+	binary = smartJoin(globalSettings.LibraryPath, "bin", binary)
+	if !isFREDLabel(tool.Label) {
+		return launchGame(ctx, mod, settings, tool.Label, binary)
+	}
+
+	parentFolder := filepath.Join(globalSettings.LibraryPath, "mods")
+	modFlag, err := buildModFlag(ctx, mod, parentFolder)
+	if err != nil {
+		return err
+	}
+
+	// FRED uses the same pref path as FSO so that it finds the same fs2_open.ini
+	prefOpts, err := fsointerop.GetProfileLaunchOptions(ctx, settings.GetPrefProfile())
+	if err != nil {
+		return err
+	}
+
+	workDir := parentFolder
+	if prefOpts.Dir != "" {
+		workDir = prefOpts.Dir
+		modFlag, err = relocateModFlag(modFlag, parentFolder, workDir)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(workDir, 0o770)
+		if err != nil {
+			return eris.Wrapf(err, "failed to create %s", workDir)
+		}
+	}
+
+	args := make([]string, 0, len(prefOpts.Args)+2)
+	args = append(args, prefOpts.Args...)
+	if len(modFlag) > 0 {
+		args = append(args, "-mod", strings.Join(modFlag, ","))
+	}
+
+	err = ensureExecutable(binary)
+	if err != nil {
+		return err
+	}
+
+	command, err := wrapCommand(globalSettings, settings, append([]string{binary}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	env, err := buildLaunchEnv(globalSettings, settings)
+	if err != nil {
+		return err
+	}
+
+	proc := exec.Command(command[0], command[1:]...)
+	proc.Dir = workDir
+	proc.Env = append(env, prefOpts.Env...)
+
+	api.Log(ctx, api.LogInfo, "Launching %s in %s", strings.Join(command, " "), proc.Dir)
+
+	_, err = superviseGame(ctx, &client.RunningGame{
+		Modid:    mod.Modid,
+		Version:  mod.Version,
+		Label:    tool.Label,
+		Binary:   binary,
+		Cmdline:  strings.Join(args, " "),
+		PrefPath: fsointerop.GetProfilePrefPath(ctx, settings.GetPrefProfile()),
+		Tool:     true,
+	}, proc)
+	return err
+}
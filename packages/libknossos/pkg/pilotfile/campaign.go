@@ -0,0 +1,159 @@
+package pilotfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+const (
+	// "CSG_" in file order
+	csgFileID = 0x5f475343
+	// newest campaign file version we know about
+	csgVersion = 8
+)
+
+// ReadCampaign parses the campaign save file at path. Like ReadPilot, it only fails if the file isn't a campaign save
+// file at all.
+func ReadCampaign(path string) (*client.CampaignProgress, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s", path)
+	}
+
+	r := &binaryReader{data: data}
+	version, err := readHeader(r, csgFileID)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse %s", path)
+	}
+
+	// Campaign saves are named <callsign>.<campaign>.csg
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if pos := strings.IndexRune(name, '.'); pos > -1 {
+		name = name[pos+1:]
+	}
+
+	progress := &client.CampaignProgress{
+		Campaign:          name,
+		Path:              path,
+		Version:           version,
+		CompletedMissions: make([]int32, 0),
+		Warnings:          make([]string, 0),
+	}
+	if version > csgVersion {
+		progress.Warnings = append(progress.Warnings, fmt.Sprintf("unknown campaign file version %d, some information might be missing", version))
+	}
+
+	sections, err := readSections(r)
+	if err != nil {
+		progress.Warnings = append(progress.Warnings, err.Error())
+	}
+
+	// The per-mission stats are sized by the ship and medal lists in the info section
+	var lists *campaignLists
+	for _, sec := range sections {
+		switch sec.id {
+		case sectionInfo:
+			lists = readCampaignInfo(sec.data, progress)
+		case sectionMissions:
+			if lists == nil {
+				progress.Warnings = append(progress.Warnings, "missions section found before the info section")
+				continue
+			}
+			readCampaignMissions(sec.data, progress, lists)
+		default:
+			continue
+		}
+
+		if sec.data.err != nil {
+			progress.Warnings = append(progress.Warnings, fmt.Sprintf("section %04x: %s", sec.id, sec.data.err))
+		}
+	}
+
+	return progress, nil
+}
+
+// campaignLists holds the sizes of the lists in a campaign file's info section
+type campaignLists struct {
+	ships  int
+	medals int
+}
+
+func readCampaignInfo(r *binaryReader, progress *client.CampaignProgress) *campaignLists {
+	// The ship, weapon, intel and medal lists contain the names of all entries which were available when the
+	// campaign was saved.
+	sizes := make([]int, 4)
+	for idx := range sizes {
+		sizes[idx] = r.list(4)
+		for item := 0; item < sizes[idx]; item++ {
+			r.string()
+		}
+	}
+
+	// last ship flown (index into the ship list)
+	r.int32()
+
+	progress.PrevMission = r.int32()
+	progress.NextMission = r.int32()
+	// loop_reentry and loop_enabled
+	r.int32()
+	r.int32()
+
+	completed := r.int32()
+	if completed > 0 {
+		progress.MissionsCompleted = uint32(completed)
+	}
+
+	return &campaignLists{ships: sizes[0], medals: sizes[3]}
+}
+
+func readCampaignMissions(r *binaryReader, progress *client.CampaignProgress, lists *campaignLists) {
+	// Every completed mission starts with its index followed by its goals, events, variables and stats. We have to
+	// skip all of those to reach the next entry; if that fails, we keep the indices we've read so far.
+	for idx := uint32(0); idx < progress.MissionsCompleted && r.pos < len(r.data); idx++ {
+		missionIdx := r.int32()
+		if r.err != nil {
+			return
+		}
+
+		progress.CompletedMissions = append(progress.CompletedMissions, missionIdx)
+		if !skipMissionDetails(r, lists) {
+			return
+		}
+	}
+}
+
+// skipMissionDetails skips the goals, events, variables and stats of a completed mission entry. Returns false if the
+// entry couldn't be decoded.
+func skipMissionDetails(r *binaryReader, lists *campaignLists) bool {
+	// flags
+	r.int32()
+
+	// goals and events: name + status
+	for list := 0; list < 2; list++ {
+		count := r.list(5)
+		for idx := 0; idx < count; idx++ {
+			r.string()
+			r.uint8()
+		}
+	}
+
+	// variables: type, value, name
+	count := r.list(12)
+	for idx := 0; idx < count; idx++ {
+		r.int32()
+		r.string()
+		r.string()
+	}
+
+	// Unlike the pilot's scoring section, the per-mission stats only contain the score, rank, kill and shot counters
+	// followed by one kill count per ship class and one count per medal without any names or list sizes.
+	r.take((12 + lists.ships + lists.medals) * 4)
+
+	return r.err == nil
+}
@@ -0,0 +1,89 @@
+package pilotfile
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+// GetPlayersPath returns the folder FSO stores pilot and campaign files in
+func GetPlayersPath(prefPath string) string {
+	return filepath.Join(prefPath, "data", "players")
+}
+
+// ListPilots reads all pilots and their campaign saves in the passed pref path. Files which can't be parsed are
+// reported through the pilot's warnings instead of failing the whole listing.
+func ListPilots(prefPath string) ([]*client.PilotInfo, error) {
+	playersPath := GetPlayersPath(prefPath)
+	entries, err := os.ReadDir(playersPath)
+	if err != nil {
+		if eris.Is(err, os.ErrNotExist) {
+			return []*client.PilotInfo{}, nil
+		}
+		return nil, eris.Wrapf(err, "failed to list %s", playersPath)
+	}
+
+	// Newer FSO versions save pilots as JSON but keep the .plr they converted it from. The JSON file is the one FSO
+	// uses in that case so it replaces the .plr one.
+	pilotPaths := make(map[string]string)
+	campaigns := make(map[string][]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		switch ext {
+		case ".plr", ".json":
+			fileName := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+			if _, found := pilotPaths[fileName]; !found || ext == ".json" {
+				pilotPaths[fileName] = filepath.Join(playersPath, name)
+			}
+		case ".csg":
+			callsign := strings.ToLower(strings.SplitN(name, ".", 2)[0])
+			campaigns[callsign] = append(campaigns[callsign], filepath.Join(playersPath, name))
+		}
+	}
+
+	pilots := make([]*client.PilotInfo, 0, len(pilotPaths))
+	for _, path := range pilotPaths {
+		pilot, err := ReadPilot(path)
+		if err != nil {
+			pilot = &client.PilotInfo{
+				Path:     path,
+				Warnings: []string{err.Error()},
+			}
+		}
+
+		if pilot.Callsign == "" {
+			pilot.Callsign = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		pilots = append(pilots, pilot)
+	}
+
+	for _, pilot := range pilots {
+		fileName := strings.ToLower(strings.TrimSuffix(filepath.Base(pilot.Path), filepath.Ext(pilot.Path)))
+		pilot.Campaigns = make([]*client.CampaignProgress, 0, len(campaigns[fileName]))
+
+		for _, path := range campaigns[fileName] {
+			progress, err := ReadCampaign(path)
+			if err != nil {
+				pilot.Warnings = append(pilot.Warnings, err.Error())
+				continue
+			}
+
+			pilot.Campaigns = append(pilot.Campaigns, progress)
+		}
+
+		sort.Slice(pilot.Campaigns, func(i, j int) bool { return pilot.Campaigns[i].Campaign < pilot.Campaigns[j].Campaign })
+	}
+
+	sort.Slice(pilots, func(i, j int) bool { return strings.ToLower(pilots[i].Callsign) < strings.ToLower(pilots[j].Callsign) })
+	return pilots, nil
+}
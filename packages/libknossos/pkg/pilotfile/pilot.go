@@ -0,0 +1,208 @@
+package pilotfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+const (
+	// "PLR_" in file order
+	plrFileID = 0x5f524c50
+	// newest pilot file version we know about
+	plrVersion = 3
+)
+
+// retailRanks lists the rank names used by FreeSpace 2. Mods can change them through rank.tbl which we don't parse.
+var retailRanks = []string{
+	"Ensign",
+	"Lieutenant Junior Grade",
+	"Lieutenant",
+	"Lieutenant Commander",
+	"Commander",
+	"Captain",
+	"Commodore",
+	"Rear Admiral",
+	"Vice Admiral",
+	"Admiral",
+}
+
+// ReadPilot parses the pilot file at path. Problems in individual sections are recorded in the result's warnings;
+// an error is only returned if the file can't be identified as a pilot file at all.
+func ReadPilot(path string) (*client.PilotInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s", path)
+	}
+
+	var pilot *client.PilotInfo
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		pilot, err = readJSONPilot(trimmed)
+	} else {
+		pilot, err = readBinaryPilot(data)
+	}
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse %s", path)
+	}
+
+	pilot.Path = path
+	if pilot.Version > plrVersion {
+		pilot.Warnings = append(pilot.Warnings, fmt.Sprintf("unknown pilot file version %d, some information might be missing", pilot.Version))
+	}
+
+	if pilot.Rank >= 0 && int(pilot.Rank) < len(retailRanks) {
+		pilot.RankName = retailRanks[pilot.Rank]
+	}
+
+	return pilot, nil
+}
+
+func readBinaryPilot(data []byte) (*client.PilotInfo, error) {
+	r := &binaryReader{data: data}
+	version, err := readHeader(r, plrFileID)
+	if err != nil {
+		return nil, err
+	}
+
+	pilot := &client.PilotInfo{
+		Version:  version,
+		Medals:   make([]*client.PilotInfo_Medal, 0),
+		Warnings: make([]string, 0),
+	}
+
+	sections, err := readSections(r)
+	if err != nil {
+		pilot.Warnings = append(pilot.Warnings, err.Error())
+	}
+
+	for _, sec := range sections {
+		switch sec.id {
+		case sectionInfo:
+			readPilotInfo(sec.data, pilot)
+		case sectionScoring:
+			readPilotScoring(sec.data, pilot)
+		default:
+			continue
+		}
+
+		if sec.data.err != nil {
+			pilot.Warnings = append(pilot.Warnings, fmt.Sprintf("section %04x: %s", sec.id, sec.data.err))
+		}
+	}
+
+	return pilot, nil
+}
+
+func readPilotInfo(r *binaryReader, pilot *client.PilotInfo) {
+	pilot.Callsign = r.string()
+	pilot.Image = r.string()
+	pilot.Squad = r.string()
+	// squad image
+	r.string()
+	pilot.CurrentCampaign = r.string()
+}
+
+func readPilotScoring(r *binaryReader, pilot *client.PilotInfo) {
+	pilot.Score = r.int32()
+	pilot.Rank = r.int32()
+	// assists
+	r.int32()
+	pilot.KillCount = uint32(r.int32())
+	// kill_count_ok, bonehead kills, primary and secondary shots fired, hit and bonehead hits
+	for idx := 0; idx < 8; idx++ {
+		r.uint32()
+	}
+
+	pilot.FlightTime = r.uint32()
+	pilot.MissionsFlown = r.uint32()
+	// last_flown and last_backup
+	r.int32()
+	r.int32()
+
+	// kills per ship class: name + count
+	count := r.list(8)
+	for idx := 0; idx < count; idx++ {
+		r.string()
+		r.int32()
+	}
+
+	// medals: name + count. The list contains every medal the pilot has seen in any mod so we can only report the
+	// position; it matches medals.tbl for the mod the pilot was last used with.
+	count = r.list(8)
+	for idx := 0; idx < count; idx++ {
+		r.string()
+		medal := r.int32()
+		if medal > 0 {
+			pilot.Medals = append(pilot.Medals, &client.PilotInfo_Medal{Index: uint32(idx), Count: medal})
+		}
+	}
+}
+
+func jsonObject(data map[string]interface{}, key string) map[string]interface{} {
+	value, _ := data[key].(map[string]interface{})
+	return value
+}
+
+func jsonString(data map[string]interface{}, key string) string {
+	value, _ := data[key].(string)
+	return value
+}
+
+func jsonNumber(data map[string]interface{}, key string) float64 {
+	value, _ := data[key].(float64)
+	return value
+}
+
+func readJSONPilot(data []byte) (*client.PilotInfo, error) {
+	var doc map[string]interface{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to decode JSON")
+	}
+
+	pilot := &client.PilotInfo{
+		Version:  uint32(jsonNumber(doc, "version")),
+		Medals:   make([]*client.PilotInfo_Medal, 0),
+		Warnings: make([]string, 0),
+	}
+
+	info := jsonObject(doc, "info")
+	if info == nil {
+		pilot.Warnings = append(pilot.Warnings, "info section is missing")
+	} else {
+		pilot.Callsign = jsonString(info, "callsign")
+		pilot.Image = jsonString(info, "image_filename")
+		pilot.Squad = jsonString(info, "squad_name")
+		pilot.CurrentCampaign = jsonString(info, "current_campaign")
+		pilot.Multi = jsonNumber(info, "is_multi") > 0
+	}
+
+	scoring := jsonObject(doc, "scoring")
+	if scoring == nil {
+		pilot.Warnings = append(pilot.Warnings, "scoring section is missing")
+		return pilot, nil
+	}
+
+	pilot.Score = int32(jsonNumber(scoring, "score"))
+	pilot.Rank = int32(jsonNumber(scoring, "rank"))
+	pilot.KillCount = uint32(jsonNumber(scoring, "kill_count"))
+	pilot.MissionsFlown = uint32(jsonNumber(scoring, "missions_flown"))
+	pilot.FlightTime = uint32(jsonNumber(scoring, "flight_time"))
+
+	// same name + count list as in the binary format
+	medals, _ := scoring["medals_earned"].([]interface{})
+	for idx, item := range medals {
+		medal, _ := item.(map[string]interface{})
+		count := jsonNumber(medal, "val")
+		if count > 0 {
+			pilot.Medals = append(pilot.Medals, &client.PilotInfo_Medal{Index: uint32(idx), Count: int32(count)})
+		}
+	}
+
+	return pilot, nil
+}
@@ -0,0 +1,214 @@
+package pilotfile
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the .golden files in testdata/captured")
+
+// testdata/pref contains files generated to match FSO's writers: a binary pilot in the FSO 3.7.x format with a
+// version 5 campaign save and a pilot that a newer FSO converted to JSON (the stale .plr is still there) with a
+// version 8 campaign save. They cover the listing logic; TestCapturedFiles covers files written by FSO itself.
+const testPrefPath = "testdata/pref"
+
+func TestListPilots(t *testing.T) {
+	t.Parallel()
+
+	playersPath := GetPlayersPath(testPrefPath)
+	expected := []*client.PilotInfo{
+		{
+			Callsign:        "Alpha",
+			Path:            filepath.Join(playersPath, "Alpha.plr"),
+			Version:         2,
+			Image:           "pilot01.pcx",
+			Squad:           "Alpha Squad",
+			Score:           2450,
+			Rank:            2,
+			RankName:        "Lieutenant",
+			Medals:          []*client.PilotInfo_Medal{{Index: 6, Count: 1}, {Index: 12, Count: 1}},
+			MissionsFlown:   9,
+			FlightTime:      7380,
+			KillCount:       31,
+			CurrentCampaign: "freespace2",
+			Campaigns: []*client.CampaignProgress{{
+				Campaign:          "freespace2",
+				Path:              filepath.Join(playersPath, "Alpha.freespace2.csg"),
+				Version:           5,
+				PrevMission:       1,
+				NextMission:       2,
+				MissionsCompleted: 2,
+				CompletedMissions: []int32{0, 1},
+			}},
+		},
+		{
+			Callsign:        "Beta",
+			Path:            filepath.Join(playersPath, "Beta.json"),
+			Version:         3,
+			Image:           "pilot02.pcx",
+			Squad:           "Beta Wing",
+			Score:           8110,
+			Rank:            4,
+			RankName:        "Commander",
+			Medals:          []*client.PilotInfo_Medal{{Index: 0, Count: 1}, {Index: 2, Count: 2}},
+			MissionsFlown:   22,
+			FlightTime:      25210,
+			KillCount:       96,
+			CurrentCampaign: "btrl",
+			Campaigns: []*client.CampaignProgress{{
+				Campaign:          "btrl",
+				Path:              filepath.Join(playersPath, "Beta.btrl.csg"),
+				Version:           8,
+				PrevMission:       3,
+				NextMission:       4,
+				MissionsCompleted: 3,
+				CompletedMissions: []int32{2, 3, 5},
+			}},
+		},
+	}
+
+	pilots, err := ListPilots(testPrefPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pilots) != len(expected) {
+		t.Fatalf("expected %d pilots but got %v", len(expected), pilots)
+	}
+
+	for idx, pilot := range expected {
+		if !proto.Equal(pilots[idx], pilot) {
+			t.Errorf("expected %v but got %v", pilot, pilots[idx])
+		}
+	}
+}
+
+func TestReadTruncatedCampaign(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile(filepath.Join(GetPlayersPath(testPrefPath), "Beta.btrl.csg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the file off in the middle of the missions section's last entry
+	r := &binaryReader{data: data}
+	_, err = readHeader(r, csgFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sections, err := readSections(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := -1
+	for _, sec := range sections {
+		if sec.id == sectionMissions {
+			end = bytes.Index(data, sec.data.data) + len(sec.data.data) - 20
+		}
+	}
+	if end < 0 {
+		t.Fatal("missions section not found")
+	}
+
+	csgPath := filepath.Join(t.TempDir(), "Beta.btrl.csg")
+	err = os.WriteFile(csgPath, data[:end], 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := ReadCampaign(csgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if progress.NextMission != 4 || len(progress.Warnings) == 0 {
+		t.Errorf("expected the info section and a warning but got %v", progress)
+	}
+
+	if len(progress.CompletedMissions) != 3 || progress.CompletedMissions[1] != 3 {
+		t.Errorf("expected the missions before the cut but got %v", progress.CompletedMissions)
+	}
+}
+
+// TestCapturedFiles reads the pilot and campaign files in testdata/captured/<FSO version>/ which were copied from the
+// players folder of a real FSO install and compares the result with the .golden file next to each of them. Run the
+// test with -update after adding new files to write their .golden files, then check that they match what FSO shows.
+func TestCapturedFiles(t *testing.T) {
+	t.Parallel()
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "captured", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, path := range paths {
+		var read func(string) (proto.Message, error)
+		var result proto.Message
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".plr", ".json":
+			read = func(path string) (proto.Message, error) { return ReadPilot(path) }
+			result = &client.PilotInfo{}
+		case ".csg":
+			read = func(path string) (proto.Message, error) { return ReadCampaign(path) }
+			result = &client.CampaignProgress{}
+		default:
+			continue
+		}
+
+		found = true
+		path := path
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+
+			info, err := read(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			goldenPath := path + ".golden"
+			if *updateGolden {
+				encoded, err := protojson.MarshalOptions{Multiline: true}.Marshal(info)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = os.WriteFile(goldenPath, append(encoded, '\n'), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			encoded, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = protojson.Unmarshal(encoded, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !proto.Equal(info, result) {
+				t.Errorf("expected %v but got %v", result, info)
+			}
+		})
+	}
+
+	if !found {
+		t.Skip("no captured pilot files in testdata/captured")
+	}
+}
@@ -0,0 +1,159 @@
+// Package pilotfile reads FSO's pilot (.plr, .json) and campaign save (.csg) files.
+//
+// Both formats consist of a signature, a format version and a list of sections. Every section starts with its ID and
+// its size which lets us skip sections we don't understand. This keeps the reader working with files written by newer
+// FSO versions as long as the sections we care about don't change. Newer FSO versions write pilot files as JSON
+// (<callsign>.json) instead; see readJSONPilot() for that. Campaign saves are still binary.
+//
+// See https://github.com/scp-fs2open/fs2open.github.com/tree/master/code/pilotfile for the writer.
+package pilotfile
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/rotisserie/eris"
+)
+
+// Section IDs as defined in code/pilotfile/pilotfile.h
+const (
+	sectionInfo     = 0x0002
+	sectionScoring  = 0x0006
+	sectionMissions = 0x0013
+)
+
+// maxStringLength guards against corrupt length prefixes
+const maxStringLength = 1024
+
+var errShortRead = eris.New("unexpected end of file")
+
+// binaryReader reads little-endian values from a byte slice. Once a read fails, all following reads return zero values
+// and the first error is kept in err.
+type binaryReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *binaryReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = eris.Wrapf(errShortRead, "tried to read %d bytes at offset %d", n, r.pos)
+		return nil
+	}
+
+	result := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return result
+}
+
+func (r *binaryReader) uint8() uint8 {
+	data := r.take(1)
+	if data == nil {
+		return 0
+	}
+	return data[0]
+}
+
+func (r *binaryReader) uint16() uint16 {
+	data := r.take(2)
+	if data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(data)
+}
+
+func (r *binaryReader) uint32() uint32 {
+	data := r.take(4)
+	if data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data)
+}
+
+func (r *binaryReader) int32() int32 {
+	return int32(r.uint32())
+}
+
+// string reads a string prefixed with its length as written by cfwrite_string_len()
+func (r *binaryReader) string() string {
+	length := r.int32()
+	if r.err != nil {
+		return ""
+	}
+	if length < 0 || length > maxStringLength {
+		r.err = eris.Errorf("invalid string length %d at offset %d", length, r.pos-4)
+		return ""
+	}
+
+	return string(r.take(int(length)))
+}
+
+// list reads a count prefix and makes sure it's plausible for the remaining data
+func (r *binaryReader) list(elementSize int) int {
+	count := r.int32()
+	if r.err != nil {
+		return 0
+	}
+
+	if count < 0 || (elementSize > 0 && int64(count)*int64(elementSize) > int64(len(r.data)-r.pos)) || count > math.MaxInt16 {
+		r.err = eris.Errorf("invalid list length %d at offset %d", count, r.pos-4)
+		return 0
+	}
+
+	return int(count)
+}
+
+type section struct {
+	id   uint16
+	data *binaryReader
+}
+
+// readHeader checks the signature and returns the format version
+func readHeader(r *binaryReader, signature uint32) (uint32, error) {
+	fileID := r.uint32()
+	version := r.uint8()
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if fileID != signature {
+		return 0, eris.Errorf("invalid signature %08x", fileID)
+	}
+
+	return uint32(version), nil
+}
+
+// readSections splits the rest of the file into its sections. A truncated last section is returned as is so that
+// callers can still try to read it.
+func readSections(r *binaryReader) ([]section, error) {
+	sections := make([]section, 0)
+	for r.err == nil && r.pos < len(r.data) {
+		id := r.uint16()
+		size := r.uint32()
+		if r.err != nil {
+			return sections, r.err
+		}
+
+		end := r.pos + int(size)
+		var err error
+		if size > math.MaxInt32 || end > len(r.data) {
+			end = len(r.data)
+			err = eris.Errorf("section %04x is truncated", id)
+		}
+
+		sections = append(sections, section{
+			id:   id,
+			data: &binaryReader{data: r.data[r.pos:end]},
+		})
+		r.pos = end
+
+		if err != nil {
+			return sections, err
+		}
+	}
+
+	return sections, nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+)
+
+// checksumPackBucket contains the checksum packs we fetched from the modsync repositories, indexed by mod ID and
+// version ("<modid>#<version>"). The packs have been verified before they're stored.
+var checksumPackBucket = []byte("checksum_packs")
+
+// GetChecksumPack returns the cached checksum pack for the passed release or nil if we haven't fetched it, yet
+func GetChecksumPack(ctx context.Context, modID, version string) (*common.ChecksumPack, error) {
+	var pack *common.ChecksumPack
+	err := view(ctx, func(tx *bolt.Tx) error {
+		encoded := tx.Bucket(checksumPackBucket).Get([]byte(modID + "#" + version))
+		if encoded == nil {
+			return nil
+		}
+
+		pack = new(common.ChecksumPack)
+		err := proto.Unmarshal(encoded, pack)
+		if err != nil {
+			return eris.Wrapf(err, "failed to deserialise checksum pack for %s %s", modID, version)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pack, nil
+}
+
+// SaveChecksumPack caches the passed checksum pack for the passed release
+func SaveChecksumPack(ctx context.Context, modID, version string, pack *common.ChecksumPack) error {
+	encoded, err := proto.Marshal(pack)
+	if err != nil {
+		return eris.Wrapf(err, "failed to serialise checksum pack for %s %s", modID, version)
+	}
+
+	return update(ctx, func(tx *bolt.Tx) error {
+		err := tx.Bucket(checksumPackBucket).Put([]byte(modID+"#"+version), encoded)
+		if err != nil {
+			return eris.Wrapf(err, "failed to save checksum pack for %s %s", modID, version)
+		}
+
+		return nil
+	})
+}
+
+// cleanChecksumPacks removes the checksum packs of releases which are neither installed nor available remotely
+func cleanChecksumPacks(_ context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	localMods := tx.Bucket(localModsBucket)
+	remoteMods := tx.Bucket(remoteModsBucket)
+	bucket := tx.Bucket(checksumPackBucket)
+
+	stale := make([][]byte, 0)
+	err := bucket.ForEach(func(k, v []byte) error {
+		if localMods.Get(k) == nil && remoteMods.Get(k) == nil {
+			stale = append(stale, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report.ChecksumPacks = uint32(len(stale))
+	return deleteKeys(bucket, stale)
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+)
+
+func TestChecksumPacks(t *testing.T) {
+	t.Parallel()
+
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{checksumPackBucket, localModsBucket, remoteModsBucket} {
+			_, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		// mva 4.1.0 is installed, bp 1.0.0 is available remotely and fso 21.0.0 is gone
+		err := tx.Bucket(localModsBucket).Put([]byte("mva#4.1.0"), []byte{})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(remoteModsBucket).Put([]byte("bp#1.0.0"), []byte{})
+	})
+
+	pack := &common.ChecksumPack{
+		Archives: map[string]*common.ChecksumPack_Archive{
+			"core.7z": {
+				Checksum: []byte{1, 2, 3},
+				Size:     42,
+				Files:    []*common.ChecksumPack_Archive_File{{Filename: "data/mod.vp", Checksum: []byte{4, 5}}},
+			},
+		},
+	}
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		ctx := CtxWithTx(testContext(), tx)
+		for _, key := range [][2]string{{"mva", "4.1.0"}, {"bp", "1.0.0"}, {"fso", "21.0.0"}} {
+			err := SaveChecksumPack(ctx, key[0], key[1], pack)
+			if err != nil {
+				return err
+			}
+		}
+
+		cached, err := GetChecksumPack(ctx, "mva", "4.1.0")
+		if err != nil {
+			return err
+		}
+
+		if !proto.Equal(cached, pack) {
+			t.Errorf("GetChecksumPack() = %v, expected %v", cached, pack)
+		}
+
+		missing, err := GetChecksumPack(ctx, "mva", "4.0.0")
+		if err != nil {
+			return err
+		}
+
+		if missing != nil {
+			t.Errorf("GetChecksumPack() returned %v for an unknown release", missing)
+		}
+
+		report := new(client.CleanupReport)
+		err = cleanChecksumPacks(ctx, tx, report)
+		if err != nil {
+			return err
+		}
+
+		if report.ChecksumPacks != 1 {
+			t.Errorf("cleanChecksumPacks() removed %d packs, expected 1", report.ChecksumPacks)
+		}
+
+		bucket := tx.Bucket(checksumPackBucket)
+		if bucket.Get([]byte("fso#21.0.0")) != nil {
+			t.Error("the pack of the unknown release wasn't removed")
+		}
+
+		for _, key := range []string{"mva#4.1.0", "bp#1.0.0"} {
+			if bucket.Get([]byte(key)) == nil {
+				t.Errorf("the pack for %s was removed", key)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+// Clean removes unknown buckets and all entries which aren't referenced anymore from the DB
+func Clean(ctx context.Context) (*client.CleanupReport, error) {
+	report := &client.CleanupReport{
+		Buckets: make([]string, 0),
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		steps := []func(context.Context, *bolt.Tx, *client.CleanupReport) error{
+			cleanUnknownBuckets,
+			cleanEngineFlags,
+			cleanFiles,
+			cleanUserSettings,
+			cleanHTTPCache,
+			cleanImageCache,
+			cleanChecksumPacks,
+			cleanIndexes,
+		}
+
+		for _, step := range steps {
+			err := step(ctx, tx, report)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	api.Log(ctx, api.LogInfo, "Cleanup removed %d files, %d user settings, %d HTTP cache entries, %d cached images, "+
+		"%d checksum packs, %d engine flags, %d index entries and %d unknown buckets", report.Files, report.UserSettings,
+		report.HttpCache, report.ImageCache, report.ChecksumPacks, report.EngineFlags, report.IndexEntries,
+		len(report.Buckets))
+	return report, nil
+}
+
+// deleteKeys removes the passed keys from bucket. Keys can't be deleted during ForEach() so callers collect them first.
+func deleteKeys(bucket *bolt.Bucket, keys [][]byte) error {
+	for _, key := range keys {
+		err := bucket.Delete(key)
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete %s", key)
+		}
+	}
+
+	return nil
+}
+
+func cleanUnknownBuckets(ctx context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	unknown := make([][]byte, 0)
+	err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		for _, known := range knownBuckets {
+			if bytes.Equal(name, known) {
+				return nil
+			}
+		}
+
+		unknown = append(unknown, append([]byte{}, name...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range unknown {
+		err = tx.DeleteBucket(name)
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete bucket %s", name)
+		}
+
+		api.Log(ctx, api.LogInfo, "Removed unknown bucket %s", name)
+		report.Buckets = append(report.Buckets, string(name))
+	}
+
+	return nil
+}
+
+func cleanEngineFlags(_ context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	bucket := tx.Bucket(engineFlagsBucket)
+	filePrefix := []byte("file#")
+
+	stale := make([][]byte, 0)
+	err := bucket.ForEach(func(k, v []byte) error {
+		if bytes.HasPrefix(k, filePrefix) {
+			filePath := string(k[len(filePrefix):])
+			_, err := os.Stat(filePath)
+			if err == nil {
+				return nil
+			}
+			if !eris.Is(err, os.ErrNotExist) {
+				return eris.Wrapf(err, "failed to check %s", filePath)
+			}
+
+			stale = append(stale, append([]byte{}, k...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report.EngineFlags = uint32(len(stale))
+	return deleteKeys(bucket, stale)
+}
+
+// isReleaseKey returns true if the passed key from a mod bucket belongs to a release. Keys starting with # contain
+// metadata like the last modified dates for remote mods.
+func isReleaseKey(key []byte) bool {
+	return len(key) > 0 && key[0] != '#' && bytes.IndexByte(key, '#') > -1
+}
+
+func cleanFiles(_ context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	referenced := make(map[string]bool)
+	for _, name := range [][]byte{localModsBucket, remoteModsBucket} {
+		err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+			if !isReleaseKey(k) {
+				return nil
+			}
+
+			var rel common.Release
+			err := proto.Unmarshal(v, &rel)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise release %s", k)
+			}
+
+			for _, ref := range append([]*common.FileRef{rel.Banner, rel.Teaser}, rel.Screenshots...) {
+				if ref != nil {
+					referenced[ref.Fileid] = true
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	bucket := tx.Bucket(fileBucket)
+	stale := make([][]byte, 0)
+	err := bucket.ForEach(func(k, _ []byte) error {
+		if !referenced[string(k)] {
+			stale = append(stale, append([]byte{}, k...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report.Files = uint32(len(stale))
+	return deleteKeys(bucket, stale)
+}
+
+func cleanUserSettings(ctx context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	mods := tx.Bucket(localModsBucket)
+	bucket := tx.Bucket(userModSettingsBucket)
+
+	stale := make([][]byte, 0)
+	err := bucket.ForEach(func(k, _ []byte) error {
+		if mods.Get(k) == nil {
+			api.Log(ctx, api.LogDebug, "Removing settings for uninstalled release %s", k)
+			stale = append(stale, append([]byte{}, k...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report.UserSettings = uint32(len(stale))
+	return deleteKeys(bucket, stale)
+}
+
+func cleanHTTPCache(_ context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	count, err := evictHTTPCache(tx.Bucket(httpCacheBucket), time.Now())
+	report.HttpCache = uint32(count)
+	return err
+}
+
+// cleanImageCache removes entries whose image is missing and images which don't have an entry
+func cleanImageCache(ctx context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	bucket := tx.Bucket(imageCacheBucket)
+	folder := ImageCacheFolder(ctx)
+
+	known := make(map[string]bool)
+	stale := make([][]byte, 0)
+	err := bucket.ForEach(func(k, v []byte) error {
+		var entry ImageCacheEntry
+		err := json.Unmarshal(v, &entry)
+		if err == nil && entry.File != "" {
+			_, err = os.Stat(filepath.Join(folder, entry.File))
+		}
+
+		if err != nil {
+			stale = append(stale, append([]byte{}, k...))
+		} else {
+			known[entry.File] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	items, err := os.ReadDir(folder)
+	if err != nil && !eris.Is(err, os.ErrNotExist) {
+		return eris.Wrapf(err, "failed to list %s", folder)
+	}
+
+	// Files without an entry aren't referenced by anything so it's safe to delete them before the transaction commits
+	orphans := make([]string, 0)
+	for _, item := range items {
+		if known[item.Name()] {
+			continue
+		}
+
+		// Skip downloads which might still be running
+		if strings.HasPrefix(item.Name(), ImageCacheTempPrefix) {
+			info, err := item.Info()
+			if err != nil || time.Since(info.ModTime()) < 24*time.Hour {
+				continue
+			}
+		}
+
+		orphans = append(orphans, item.Name())
+	}
+	removeCachedImages(ctx, orphans)
+
+	report.ImageCache = uint32(len(stale) + len(orphans))
+	return deleteKeys(bucket, stale)
+}
+
+// pruneModIndexes removes index entries which point to mods or releases missing from bucket. Returns the number of
+// removed entries.
+func pruneModIndexes(tx *bolt.Tx, bucket *bolt.Bucket, versionIdx, typeIdx *StringListIndex) (int, error) {
+	removed := 0
+	err := versionIdx.ForEach(tx, func(modID string, versions []string) error {
+		for _, version := range versions {
+			if bucket.Get([]byte(modID+"#"+version)) == nil {
+				err := versionIdx.Remove(tx, modID, version)
+				if err != nil {
+					return err
+				}
+				removed++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = typeIdx.ForEach(tx, func(modType string, IDs []string) error {
+		for _, ID := range IDs {
+			if bucket.Get([]byte(ID)) == nil {
+				err := typeIdx.Remove(tx, modType, ID)
+				if err != nil {
+					return err
+				}
+				removed++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+func cleanIndexes(_ context.Context, tx *bolt.Tx, report *client.CleanupReport) error {
+	providers := []genericModProvider{LocalMods, RemoteMods}
+	knownIndexes := make(map[string]bool)
+	for _, provider := range providers {
+		knownIndexes[provider.versionIndex.Name] = true
+		knownIndexes[provider.typeIndex.Name] = true
+
+		count, err := pruneModIndexes(tx, tx.Bucket(provider.bucket), provider.versionIndex, provider.typeIndex)
+		if err != nil {
+			return err
+		}
+		report.IndexEntries += uint32(count)
+	}
+
+	for _, idx := range remoteSearchIndexes {
+		knownIndexes[idx.Name] = true
+	}
+
+	bucket := tx.Bucket(indexBucket)
+	staleKeys := make([][]byte, 0)
+	staleBuckets := make([][]byte, 0)
+	err := bucket.ForEach(func(k, v []byte) error {
+		if knownIndexes[string(k)] {
+			return nil
+		}
+
+		// Indexes are stored in nested buckets which have a nil value
+		if v == nil {
+			staleBuckets = append(staleBuckets, append([]byte{}, k...))
+		} else {
+			staleKeys = append(staleKeys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range staleBuckets {
+		err = bucket.DeleteBucket(name)
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete index %s", name)
+		}
+	}
+
+	report.IndexEntries += uint32(len(staleKeys) + len(staleBuckets))
+	return deleteKeys(bucket, staleKeys)
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+var customEnginesBucket = []byte("custom_engines")
+
+// GetCustomEngines returns all registered custom engine builds sorted by title
+func GetCustomEngines(ctx context.Context) ([]*client.CustomEngine, error) {
+	result := make([]*client.CustomEngine, 0)
+	err := view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(customEnginesBucket).ForEach(func(k, v []byte) error {
+			engine := new(client.CustomEngine)
+			err := proto.Unmarshal(v, engine)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise custom engine %s", k)
+			}
+
+			result = append(result, engine)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Title < result[j].Title })
+	return result, nil
+}
+
+// GetCustomEngine returns the custom engine registered with the given mod ID or nil if there is none
+func GetCustomEngine(ctx context.Context, modID string) (*client.CustomEngine, error) {
+	var engine *client.CustomEngine
+	err := view(ctx, func(tx *bolt.Tx) error {
+		encoded := tx.Bucket(customEnginesBucket).Get([]byte(modID))
+		if encoded == nil {
+			return nil
+		}
+
+		engine = new(client.CustomEngine)
+		err := proto.Unmarshal(encoded, engine)
+		if err != nil {
+			return eris.Wrapf(err, "failed to deserialise custom engine %s", modID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+func SaveCustomEngine(ctx context.Context, engine *client.CustomEngine) error {
+	return update(ctx, func(tx *bolt.Tx) error {
+		encoded, err := proto.Marshal(engine)
+		if err != nil {
+			return eris.Wrapf(err, "failed to serialise custom engine %s", engine.Modid)
+		}
+
+		err = tx.Bucket(customEnginesBucket).Put([]byte(engine.Modid), encoded)
+		if err != nil {
+			return eris.Wrapf(err, "failed to save custom engine %s", engine.Modid)
+		}
+
+		return nil
+	})
+}
+
+func DeleteCustomEngine(ctx context.Context, modID string) error {
+	return update(ctx, func(tx *bolt.Tx) error {
+		err := tx.Bucket(customEnginesBucket).Delete([]byte(modID))
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete custom engine %s", modID)
+		}
+
+		return nil
+	})
+}
@@ -1,10 +1,13 @@
 package storage
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io"
 	"os"
+	"time"
 
 	"github.com/rotisserie/eris"
 	bolt "go.etcd.io/bbolt"
@@ -73,10 +76,50 @@ type JSONFlags struct {
 
 var engineFlagsBucket = []byte("engine-flags")
 
+// engineFlagsEntry stores the flags together with a fingerprint of the binary they were retrieved from. This lets us
+// detect rebuilt binaries at the same path.
+type engineFlagsEntry struct {
+	Size    int64
+	ModTime time.Time
+	Hash    string
+	Flags   *JSONFlags
+}
+
+func hashEngineBinary(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to hash %s", path)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
 func SaveEngineFlags(ctx context.Context, path string, flags *JSONFlags) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return eris.Wrapf(err, "failed to check %s", path)
+	}
+
+	hash, err := hashEngineBinary(path)
+	if err != nil {
+		return err
+	}
+
 	return update(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(engineFlagsBucket)
-		encoded, err := json.Marshal(flags)
+		encoded, err := json.Marshal(engineFlagsEntry{
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Hash:    hash,
+			Flags:   flags,
+		})
 		if err != nil {
 			return eris.Wrap(err, "failed to encode engine flags")
 		}
@@ -89,8 +132,10 @@ func SaveEngineFlags(ctx context.Context, path string, flags *JSONFlags) error {
 	})
 }
 
+// GetEngineFlags returns the cached flags for the binary at path or nil if there are none or the binary changed since
+// they were saved.
 func GetEngineFlags(ctx context.Context, path string) (*JSONFlags, error) {
-	var flags *JSONFlags
+	var entry *engineFlagsEntry
 	err := view(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(engineFlagsBucket)
 		encoded := bucket.Get([]byte("file#" + path))
@@ -98,8 +143,8 @@ func GetEngineFlags(ctx context.Context, path string) (*JSONFlags, error) {
 			return nil
 		}
 
-		flags = new(JSONFlags)
-		err := json.Unmarshal(encoded, flags)
+		entry = new(engineFlagsEntry)
+		err := json.Unmarshal(encoded, entry)
 		if err != nil {
 			return eris.Wrap(err, "failed to parse stored engine flags")
 		}
@@ -110,30 +155,35 @@ func GetEngineFlags(ctx context.Context, path string) (*JSONFlags, error) {
 		return nil, err
 	}
 
-	return flags, nil
-}
+	// Entries written by older versions don't have a fingerprint and have to be refreshed.
+	if entry == nil || entry.Flags == nil || entry.Hash == "" {
+		return nil, nil
+	}
 
-func cleanEngineFlags(_ context.Context, tx *bolt.Tx) error {
-	bucket := tx.Bucket(engineFlagsBucket)
-	filePrefix := []byte("file#")
-
-	return bucket.ForEach(func(k, v []byte) error {
-		if bytes.HasPrefix(k, filePrefix) {
-			filePath := string(k[5:])
-			_, err := os.Stat(filePath)
-			if err == nil {
-				return nil
-			}
-			if !eris.Is(err, os.ErrNotExist) {
-				return eris.Wrapf(err, "failed to check %s", filePath)
-			}
-
-			err = bucket.Delete(k)
-			if err != nil {
-				return eris.Wrapf(err, "failed to delete engine flags for missing file %s", filePath)
-			}
+	info, err := os.Stat(path)
+	if err != nil {
+		if eris.Is(err, os.ErrNotExist) {
+			return nil, nil
 		}
+		return nil, eris.Wrapf(err, "failed to check %s", path)
+	}
 
-		return nil
-	})
+	if info.Size() != entry.Size {
+		return nil, nil
+	}
+
+	if !info.ModTime().Equal(entry.ModTime) {
+		// The modification time also changes if the file was just copied or touched so we only invalidate the cache
+		// if the contents changed.
+		hash, err := hashEngineBinary(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if hash != entry.Hash {
+			return nil, nil
+		}
+	}
+
+	return entry.Flags, nil
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"strings"
 
 	"github.com/rotisserie/eris"
 	"go.etcd.io/bbolt"
@@ -66,3 +67,35 @@ func GetFile(ctx context.Context, id string) (*common.FileRef, error) {
 
 	return ref, nil
 }
+
+// importRemoteFiles saves the image references of a remote release so that the images can be served from the image
+// cache. Existing references to local files are kept since they belong to an installed release.
+func importRemoteFiles(tx *bbolt.Tx, rel *common.Release) error {
+	bucket := tx.Bucket(fileBucket)
+	for _, ref := range append([]*common.FileRef{rel.Banner, rel.Teaser}, rel.Screenshots...) {
+		if ref == nil || ref.Fileid == "" {
+			continue
+		}
+
+		encoded := bucket.Get([]byte(ref.Fileid))
+		if encoded != nil {
+			var existing common.FileRef
+			err := proto.Unmarshal(encoded, &existing)
+			if err == nil && len(existing.Urls) > 0 && strings.HasPrefix(existing.Urls[0], "file://") {
+				continue
+			}
+		}
+
+		encoded, err := proto.Marshal(ref)
+		if err != nil {
+			return eris.Wrapf(err, "failed to serialise file reference %s", ref.Fileid)
+		}
+
+		err = bucket.Put([]byte(ref.Fileid), encoded)
+		if err != nil {
+			return eris.Wrapf(err, "failed to save file reference %s", ref.Fileid)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+var fsoProfilesBucket = []byte("fso_settings_profiles")
+
+// GetFSOSettingsProfiles returns all FSO settings profiles sorted by name
+func GetFSOSettingsProfiles(ctx context.Context) ([]*client.FSOSettingsProfile, error) {
+	result := make([]*client.FSOSettingsProfile, 0)
+	err := view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(fsoProfilesBucket).ForEach(func(k, v []byte) error {
+			profile := new(client.FSOSettingsProfile)
+			err := proto.Unmarshal(v, profile)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise FSO settings profile %s", k)
+			}
+
+			result = append(result, profile)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// GetFSOSettingsProfile returns the profile with the given name or nil if it doesn't exist
+func GetFSOSettingsProfile(ctx context.Context, name string) (*client.FSOSettingsProfile, error) {
+	var profile *client.FSOSettingsProfile
+	err := view(ctx, func(tx *bolt.Tx) error {
+		encoded := tx.Bucket(fsoProfilesBucket).Get([]byte(name))
+		if encoded == nil {
+			return nil
+		}
+
+		profile = new(client.FSOSettingsProfile)
+		err := proto.Unmarshal(encoded, profile)
+		if err != nil {
+			return eris.Wrapf(err, "failed to deserialise FSO settings profile %s", name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// SaveFSOSettingsProfile creates or replaces the passed profile
+func SaveFSOSettingsProfile(ctx context.Context, profile *client.FSOSettingsProfile) error {
+	return update(ctx, func(tx *bolt.Tx) error {
+		encoded, err := proto.Marshal(profile)
+		if err != nil {
+			return eris.Wrapf(err, "failed to serialise FSO settings profile %s", profile.Name)
+		}
+
+		err = tx.Bucket(fsoProfilesBucket).Put([]byte(profile.Name), encoded)
+		if err != nil {
+			return eris.Wrapf(err, "failed to save FSO settings profile %s", profile.Name)
+		}
+
+		return nil
+	})
+}
+
+// DeleteFSOSettingsProfile removes the given profile and unassigns it from all mods that used it
+func DeleteFSOSettingsProfile(ctx context.Context, name string) error {
+	return update(ctx, func(tx *bolt.Tx) error {
+		err := tx.Bucket(fsoProfilesBucket).Delete([]byte(name))
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete FSO settings profile %s", name)
+		}
+
+		bucket := tx.Bucket(userModSettingsBucket)
+		updates := make(map[string][]byte)
+		err = bucket.ForEach(func(k, v []byte) error {
+			settings := new(client.UserSettings)
+			err := proto.Unmarshal(v, settings)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise user settings %s", k)
+			}
+
+			if settings.FsoSettingsProfile != name {
+				return nil
+			}
+
+			settings.FsoSettingsProfile = ""
+			encoded, err := proto.Marshal(settings)
+			if err != nil {
+				return eris.Wrapf(err, "failed to serialise user settings %s", k)
+			}
+
+			updates[string(k)] = encoded
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Modifying a bucket during ForEach is not allowed so we apply the changes afterwards.
+		for k, encoded := range updates {
+			err = bucket.Put([]byte(k), encoded)
+			if err != nil {
+				return eris.Wrapf(err, "failed to update user settings %s", k)
+			}
+		}
+
+		return nil
+	})
+}
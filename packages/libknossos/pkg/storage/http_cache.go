@@ -3,20 +3,53 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/rotisserie/eris"
 	"go.etcd.io/bbolt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ngld/knossos/packages/api/client"
 )
 
 var httpCacheBucket = []byte("httpCache")
 
+const (
+	// staleHTTPCacheAge is the time after which unused HTTP cache entries are removed
+	staleHTTPCacheAge = 90 * 24 * time.Hour
+	// maxHTTPCacheEntries is the number of entries we keep; the least recently used ones are removed first
+	maxHTTPCacheEntries = 1000
+)
+
 type HTTPCacheEntry struct {
 	LastAccessed time.Time
-	FetchDate    string
+	Fetched      time.Time
+	// LastModified and ETag contain the validators from the last successful response
+	LastModified string
 	ETag         string
 }
 
+// HTTPCacheResult describes how a cached request was answered
+type HTTPCacheResult int
+
+const (
+	// HTTPCacheHit means that the server confirmed our cached copy (304 Not Modified)
+	HTTPCacheHit HTTPCacheResult = iota
+	// HTTPCacheMiss means that the server sent a new response
+	HTTPCacheMiss
+	// HTTPCacheError means that the request failed or returned a status we don't cache
+	HTTPCacheError
+)
+
+var httpCacheCounters [3]uint64
+
+// RecordHTTPCacheResult updates the statistics returned by GetHTTPCacheStats()
+func RecordHTTPCacheResult(result HTTPCacheResult) {
+	atomic.AddUint64(&httpCacheCounters[result], 1)
+}
+
 func GetHTTPCacheEntryForURL(ctx context.Context, url string) (*HTTPCacheEntry, error) {
 	var entry *HTTPCacheEntry
 	err := update(ctx, func(tx *bbolt.Tx) error {
@@ -34,11 +67,9 @@ func GetHTTPCacheEntryForURL(ctx context.Context, url string) (*HTTPCacheEntry,
 		}
 
 		// Update the LastAccessed field
-		encoded, err = json.Marshal(&HTTPCacheEntry{
-			LastAccessed: time.Now(),
-			FetchDate:    entry.FetchDate,
-			ETag:         entry.ETag,
-		})
+		updated := *entry
+		updated.LastAccessed = time.Now()
+		encoded, err = json.Marshal(&updated)
 		if err != nil {
 			return eris.Wrapf(err, "failed to serialise cache info for %s", url)
 		}
@@ -56,6 +87,7 @@ func GetHTTPCacheEntryForURL(ctx context.Context, url string) (*HTTPCacheEntry,
 	return entry, nil
 }
 
+// SetHTTPCacheEntryForURL saves the validators for url and evicts old entries if the cache is full
 func SetHTTPCacheEntryForURL(ctx context.Context, url string, entry *HTTPCacheEntry) error {
 	return update(ctx, func(tx *bbolt.Tx) error {
 		encoded, err := json.Marshal(entry)
@@ -63,11 +95,103 @@ func SetHTTPCacheEntryForURL(ctx context.Context, url string, entry *HTTPCacheEn
 			return eris.Wrapf(err, "failed to serialise cache entry for url %s", url)
 		}
 
-		err = tx.Bucket(httpCacheBucket).Put([]byte(url), encoded)
+		bucket := tx.Bucket(httpCacheBucket)
+		err = bucket.Put([]byte(url), encoded)
 		if err != nil {
 			return eris.Wrapf(err, "failed to save cache entry for url %s", url)
 		}
 
+		_, err = evictHTTPCache(bucket, time.Now())
+		return err
+	})
+}
+
+// DeleteHTTPCacheEntryForURL removes the entry for url. This is necessary if a response doesn't contain validators
+// anymore since the old ones would otherwise be sent with the next request.
+func DeleteHTTPCacheEntryForURL(ctx context.Context, url string) error {
+	return update(ctx, func(tx *bbolt.Tx) error {
+		err := tx.Bucket(httpCacheBucket).Delete([]byte(url))
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete cache entry for url %s", url)
+		}
+
 		return nil
 	})
 }
+
+// evictHTTPCache removes undecodable entries, entries which haven't been used for staleHTTPCacheAge and the least
+// recently used entries beyond maxHTTPCacheEntries. Returns the number of removed entries.
+func evictHTTPCache(bucket *bbolt.Bucket, now time.Time) (int, error) {
+	type cacheItem struct {
+		key          []byte
+		lastAccessed time.Time
+	}
+
+	cutoff := now.Add(-staleHTTPCacheAge)
+	stale := make([][]byte, 0)
+	items := make([]cacheItem, 0)
+	err := bucket.ForEach(func(k, v []byte) error {
+		var entry HTTPCacheEntry
+		err := json.Unmarshal(v, &entry)
+		if err != nil || entry.LastAccessed.Before(cutoff) {
+			stale = append(stale, append([]byte{}, k...))
+		} else {
+			items = append(items, cacheItem{
+				key:          append([]byte{}, k...),
+				lastAccessed: entry.LastAccessed,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(items) > maxHTTPCacheEntries {
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].lastAccessed.Before(items[j].lastAccessed)
+		})
+
+		for _, item := range items[:len(items)-maxHTTPCacheEntries] {
+			stale = append(stale, item.key)
+		}
+	}
+
+	return len(stale), deleteKeys(bucket, stale)
+}
+
+// GetHTTPCacheStats returns the hit and miss counters since startup as well as all cache entries
+func GetHTTPCacheStats(ctx context.Context) (*client.HTTPCacheStats, error) {
+	stats := &client.HTTPCacheStats{
+		Hits:    atomic.LoadUint64(&httpCacheCounters[HTTPCacheHit]),
+		Misses:  atomic.LoadUint64(&httpCacheCounters[HTTPCacheMiss]),
+		Errors:  atomic.LoadUint64(&httpCacheCounters[HTTPCacheError]),
+		Entries: make([]*client.HTTPCacheStats_Entry, 0),
+	}
+
+	err := view(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(httpCacheBucket).ForEach(func(k, v []byte) error {
+			var entry HTTPCacheEntry
+			err := json.Unmarshal(v, &entry)
+			if err != nil {
+				// Broken entries are removed during the next eviction
+				return nil
+			}
+
+			stats.Entries = append(stats.Entries, &client.HTTPCacheStats_Entry{
+				Url:          string(k),
+				Etag:         entry.ETag,
+				LastModified: entry.LastModified,
+				Fetched:      timestamppb.New(entry.Fetched),
+				LastAccessed: timestamppb.New(entry.LastAccessed),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
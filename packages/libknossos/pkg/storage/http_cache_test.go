@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestEvictHTTPCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(httpCacheBucket)
+		if err != nil {
+			return err
+		}
+
+		// One entry more than we keep, the oldest one should be evicted
+		for idx := 0; idx <= maxHTTPCacheEntries; idx++ {
+			encoded, err := json.Marshal(&HTTPCacheEntry{
+				LastAccessed: now.Add(-time.Duration(idx) * time.Minute),
+				ETag:         fmt.Sprintf(`"%d"`, idx),
+			})
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(fmt.Sprintf("https://example.com/%d", idx)), encoded)
+			if err != nil {
+				return err
+			}
+		}
+
+		encoded, err := json.Marshal(&HTTPCacheEntry{LastAccessed: now.Add(-staleHTTPCacheAge - time.Hour)})
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put([]byte("https://example.com/stale"), encoded)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte("https://example.com/broken"), []byte("{"))
+	})
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(httpCacheBucket)
+		count, err := evictHTTPCache(bucket, now)
+		if err != nil {
+			return err
+		}
+
+		if count != 3 {
+			t.Errorf("expected 3 evicted entries but got %d", count)
+		}
+
+		for _, key := range []string{"stale", "broken", fmt.Sprint(maxHTTPCacheEntries)} {
+			if bucket.Get([]byte("https://example.com/"+key)) != nil {
+				t.Errorf("entry %s wasn't evicted", key)
+			}
+		}
+
+		if bucket.Get([]byte("https://example.com/0")) == nil {
+			t.Error("the most recently used entry was evicted")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
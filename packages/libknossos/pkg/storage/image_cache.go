@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+var imageCacheBucket = []byte("image_cache")
+
+const (
+	// defaultImageCacheSize is used if the user didn't configure a limit (in MiB)
+	defaultImageCacheSize = 200
+	// imageCacheTouchInterval limits how often LastAccessed is updated for a single image. Images are requested
+	// whenever the UI displays them and we don't want a write transaction for every one of them.
+	imageCacheTouchInterval = time.Hour
+	// ImageCacheTempPrefix should be used for temporary files in ImageCacheFolder()
+	ImageCacheTempPrefix = "tmp_"
+)
+
+type ImageCacheEntry struct {
+	// File is relative to the image cache folder
+	File         string
+	Size         int64
+	LastAccessed time.Time
+}
+
+// ImageCacheFolder returns the folder which contains the cached images
+func ImageCacheFolder(ctx context.Context) string {
+	return filepath.Join(api.SettingsPath(ctx), "image_cache")
+}
+
+// GetImageCacheLimit returns the configured size limit in bytes. 0 means that the cache is disabled.
+func GetImageCacheLimit(ctx context.Context) (int64, error) {
+	settings, err := GetSettings(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case settings.ImageCacheSize < 0:
+		return 0, nil
+	case settings.ImageCacheSize == 0:
+		return defaultImageCacheSize * 1024 * 1024, nil
+	default:
+		return int64(settings.ImageCacheSize) * 1024 * 1024, nil
+	}
+}
+
+func getImageCacheEntry(bucket *bolt.Bucket, fileID string) (*ImageCacheEntry, error) {
+	encoded := bucket.Get([]byte(fileID))
+	if encoded == nil {
+		return nil, nil
+	}
+
+	entry := new(ImageCacheEntry)
+	err := json.Unmarshal(encoded, entry)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to deserialise image cache entry %s", fileID)
+	}
+
+	return entry, nil
+}
+
+func putImageCacheEntry(bucket *bolt.Bucket, fileID string, entry *ImageCacheEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return eris.Wrapf(err, "failed to serialise image cache entry %s", fileID)
+	}
+
+	err = bucket.Put([]byte(fileID), encoded)
+	if err != nil {
+		return eris.Wrapf(err, "failed to save image cache entry %s", fileID)
+	}
+
+	return nil
+}
+
+// IsImageCached returns true if the image cache contains the passed file. Unlike GetCachedImage(), this doesn't count
+// as an access.
+func IsImageCached(ctx context.Context, fileID string) (bool, error) {
+	var entry *ImageCacheEntry
+	err := view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		entry, err = getImageCacheEntry(tx.Bucket(imageCacheBucket), fileID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return entry != nil, nil
+}
+
+// GetCachedImage returns the path to the cached copy of the passed file or an empty string if it's not cached
+func GetCachedImage(ctx context.Context, fileID string) (string, error) {
+	var entry *ImageCacheEntry
+	err := view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		entry, err = getImageCacheEntry(tx.Bucket(imageCacheBucket), fileID)
+		return err
+	})
+	if err != nil || entry == nil {
+		return "", err
+	}
+
+	cachePath := filepath.Join(ImageCacheFolder(ctx), entry.File)
+	_, err = os.Stat(cachePath)
+	if err != nil {
+		// The entry will be removed during the next cleanup
+		return "", nil
+	}
+
+	if time.Since(entry.LastAccessed) > imageCacheTouchInterval {
+		entry.LastAccessed = time.Now()
+		err = update(ctx, func(tx *bolt.Tx) error {
+			return putImageCacheEntry(tx.Bucket(imageCacheBucket), fileID, entry)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return cachePath, nil
+}
+
+// AddCachedImage moves the file at srcPath into the image cache and evicts the least recently used images if the cache
+// exceeds its size limit. srcPath should be inside ImageCacheFolder() to avoid copying the file. Returns the new path
+// or an empty string if the cache is disabled.
+func AddCachedImage(ctx context.Context, fileID, srcPath, ext string) (string, error) {
+	limit, err := GetImageCacheLimit(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if limit == 0 {
+		return "", os.Remove(srcPath)
+	}
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to inspect %s", srcPath)
+	}
+
+	entry := &ImageCacheEntry{
+		File:         "img_" + hex.EncodeToString([]byte(fileID)) + ext,
+		Size:         info.Size(),
+		LastAccessed: time.Now(),
+	}
+
+	cachePath := filepath.Join(ImageCacheFolder(ctx), entry.File)
+	err = os.Rename(srcPath, cachePath)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to move %s to %s", srcPath, cachePath)
+	}
+
+	var evicted []string
+	err = update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imageCacheBucket)
+		err := putImageCacheEntry(bucket, fileID, entry)
+		if err != nil {
+			return err
+		}
+
+		evicted, err = evictImageCache(bucket, limit)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	removeCachedImages(ctx, evicted)
+	for _, name := range evicted {
+		if name == entry.File {
+			// The image alone is bigger than the limit
+			return "", nil
+		}
+	}
+
+	return cachePath, nil
+}
+
+// evictImageCache removes the least recently used entries until the cache fits into limit and returns the files which
+// have to be deleted once the transaction is committed
+func evictImageCache(bucket *bolt.Bucket, limit int64) ([]string, error) {
+	type cacheItem struct {
+		key   []byte
+		entry ImageCacheEntry
+	}
+
+	items := make([]cacheItem, 0)
+	total := int64(0)
+	err := bucket.ForEach(func(k, v []byte) error {
+		item := cacheItem{key: append([]byte{}, k...)}
+		err := json.Unmarshal(v, &item.entry)
+		if err != nil {
+			// Broken entries sort first and are thus evicted first
+			item.entry = ImageCacheEntry{}
+		}
+
+		total += item.entry.Size
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if total <= limit {
+		return nil, nil
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].entry.LastAccessed.Before(items[j].entry.LastAccessed)
+	})
+
+	stale := make([][]byte, 0)
+	files := make([]string, 0)
+	for _, item := range items {
+		if total <= limit {
+			break
+		}
+
+		stale = append(stale, item.key)
+		if item.entry.File != "" {
+			files = append(files, item.entry.File)
+		}
+		total -= item.entry.Size
+	}
+
+	return files, deleteKeys(bucket, stale)
+}
+
+func removeCachedImages(ctx context.Context, files []string) {
+	folder := ImageCacheFolder(ctx)
+	for _, name := range files {
+		err := os.Remove(filepath.Join(folder, name))
+		if err != nil && !eris.Is(err, os.ErrNotExist) {
+			api.Log(ctx, api.LogWarn, "Failed to remove cached image %s: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestEvictImageCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	entries := map[string]*ImageCacheEntry{
+		"old":    {File: "img_old.png", Size: 40, LastAccessed: now.Add(-3 * time.Hour)},
+		"recent": {File: "img_recent.png", Size: 40, LastAccessed: now.Add(-time.Hour)},
+		"new":    {File: "img_new.png", Size: 40, LastAccessed: now},
+	}
+
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(imageCacheBucket)
+		if err != nil {
+			return err
+		}
+
+		for key, entry := range entries {
+			err = putImageCacheEntry(bucket, key, entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imageCacheBucket)
+		files, err := evictImageCache(bucket, 100)
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(files, []string{"img_old.png"}) {
+			t.Errorf("expected only img_old.png to be evicted but got %v", files)
+		}
+
+		for key := range entries {
+			entry, err := getImageCacheEntry(bucket, key)
+			if err != nil {
+				return err
+			}
+
+			if (entry == nil) != (key == "old") {
+				t.Errorf("unexpected state for entry %s: %v", key, entry)
+			}
+		}
+
+		files, err = evictImageCache(bucket, 100)
+		if err != nil {
+			return err
+		}
+
+		if len(files) != 0 {
+			t.Errorf("evicted %v although the cache fits", files)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"encoding/json"
 	"sort"
+	"sync"
 
 	"github.com/rotisserie/eris"
 	bolt "go.etcd.io/bbolt"
@@ -12,11 +13,29 @@ var indexBucket = []byte("_indexes")
 
 type StringListSorter func(string, []string) error
 
+// StringListIndex maps keys to sorted lists of strings (i.e. mod IDs to versions).
+//
+// Every key is stored as a separate record in a bucket below _indexes so that updates only have to write the lists
+// that actually changed. Reads are served from an in-memory copy. Changes made in a write transaction are only visible
+// to that transaction until it commits; all other readers keep seeing the last committed state.
 type StringListIndex struct {
-	cache     map[string][]string
-	sorter    StringListSorter
-	Name      string
-	batchMode bool
+	Name   string
+	sorter StringListSorter
+
+	lock  sync.RWMutex
+	cache map[string][]string
+
+	// pending holds the changes of the current write transaction. bbolt only allows one write transaction at a time
+	// so there's never more than one.
+	pendingLock sync.Mutex
+	pending     *indexChanges
+}
+
+type indexChanges struct {
+	tx      *bolt.Tx
+	cleared bool
+	// changed lists; nil means that the key was removed
+	values map[string][]string
 }
 
 func NewStringListIndex(name string, sorter StringListSorter) *StringListIndex {
@@ -30,37 +49,116 @@ func NewStringListIndex(name string, sorter StringListSorter) *StringListIndex {
 	return &StringListIndex{
 		Name:   name,
 		sorter: sorter,
+		cache:  make(map[string][]string),
 	}
 }
 
+func copyList(list []string) []string {
+	result := make([]string, len(list))
+	copy(result, list)
+	return result
+}
+
+// Open loads the index from the DB and discards all uncommitted changes
 func (i *StringListIndex) Open(tx *bolt.Tx) error {
-	bucket := tx.Bucket(indexBucket)
-	data := bucket.Get([]byte(i.Name))
-	if data != nil {
-		err := json.Unmarshal(data, &i.cache)
+	cache := make(map[string][]string)
+	bucket := tx.Bucket(indexBucket).Bucket([]byte(i.Name))
+	if bucket != nil {
+		err := bucket.ForEach(func(k, v []byte) error {
+			var list []string
+			err := json.Unmarshal(v, &list)
+			if err != nil {
+				return eris.Wrapf(err, "failed to unserialise entry %s of index %s", k, i.Name)
+			}
+
+			cache[string(k)] = list
+			return nil
+		})
 		if err != nil {
-			return eris.Wrapf(err, "failed to unserialise index %s", i.Name)
+			return err
 		}
 	}
 
+	i.lock.Lock()
+	i.cache = cache
+	i.lock.Unlock()
+
+	i.pendingLock.Lock()
+	i.pending = nil
+	i.pendingLock.Unlock()
 	return nil
 }
 
-func (i *StringListIndex) StartBatch() {
-	i.batchMode = true
-}
+// changesFor returns the pending changes for tx or nil if tx didn't change this index. Has to be called with
+// pendingLock held.
+func (i *StringListIndex) changesFor(tx *bolt.Tx) *indexChanges {
+	if tx == nil || i.pending == nil || i.pending.tx != tx {
+		return nil
+	}
 
-func (i *StringListIndex) FinishBatch(tx *bolt.Tx) error {
-	i.batchMode = false
-	return i.Save(tx)
+	return i.pending
 }
 
-func (i *StringListIndex) Lookup(key string) []string {
+// lookup returns the current list for key as seen by tx. Has to be called with pendingLock held.
+func (i *StringListIndex) lookup(tx *bolt.Tx, key string) []string {
+	changes := i.changesFor(tx)
+	if changes != nil {
+		if list, ok := changes.values[key]; ok {
+			return list
+		}
+		if changes.cleared {
+			return nil
+		}
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
 	return i.cache[key]
 }
 
-func (i *StringListIndex) ForEach(cb func(string, []string) error) error {
-	for k, v := range i.cache {
+// Lookup returns a copy of the list stored for key. tx may be nil; it's only needed to see uncommitted changes.
+func (i *StringListIndex) Lookup(tx *bolt.Tx, key string) []string {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	list := i.lookup(tx, key)
+	if list == nil {
+		return nil
+	}
+	return copyList(list)
+}
+
+// snapshot returns a copy of the whole index as seen by tx
+func (i *StringListIndex) snapshot(tx *bolt.Tx) map[string][]string {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	result := make(map[string][]string)
+	changes := i.changesFor(tx)
+	if changes == nil || !changes.cleared {
+		i.lock.RLock()
+		for k, v := range i.cache {
+			result[k] = copyList(v)
+		}
+		i.lock.RUnlock()
+	}
+
+	if changes != nil {
+		for k, v := range changes.values {
+			if v == nil {
+				delete(result, k)
+			} else {
+				result[k] = copyList(v)
+			}
+		}
+	}
+
+	return result
+}
+
+// ForEach calls cb for every key in the index. The callback receives copies so it may modify the index.
+func (i *StringListIndex) ForEach(tx *bolt.Tx, cb func(string, []string) error) error {
+	for k, v := range i.snapshot(tx) {
 		err := cb(k, v)
 		if err != nil {
 			return err
@@ -70,132 +168,180 @@ func (i *StringListIndex) ForEach(cb func(string, []string) error) error {
 	return nil
 }
 
-func (i *StringListIndex) Clear() {
-	i.cache = make(map[string][]string)
-}
+// begin returns the pending changes for tx and creates them if necessary. Has to be called with pendingLock held.
+func (i *StringListIndex) begin(tx *bolt.Tx) *indexChanges {
+	if !tx.Writable() {
+		panic("index " + i.Name + " modified in a read-only transaction")
+	}
 
-func (i *StringListIndex) BatchedAdd(key, value string) {
-	if !i.batchMode {
-		panic("BatchedAdd() called on an index that wasn't in batch mode!")
+	changes := i.changesFor(tx)
+	if changes != nil {
+		return changes
 	}
 
-	if i.cache == nil {
-		i.Clear()
+	// Any previous changes belong to a transaction which was rolled back since OnCommit() would have removed them
+	// otherwise.
+	changes = &indexChanges{
+		tx:     tx,
+		values: make(map[string][]string),
 	}
-	i.cache[key] = append(i.cache[key], value)
+	i.pending = changes
+
+	tx.OnCommit(func() {
+		i.pendingLock.Lock()
+		defer i.pendingLock.Unlock()
+
+		i.lock.Lock()
+		defer i.lock.Unlock()
+
+		if changes.cleared {
+			i.cache = make(map[string][]string)
+		}
+		for k, v := range changes.values {
+			if v == nil {
+				delete(i.cache, k)
+			} else {
+				i.cache[k] = v
+			}
+		}
+
+		if i.pending == changes {
+			i.pending = nil
+		}
+	})
+
+	return changes
 }
 
-func (i *StringListIndex) BatchedRemove(key, value string) {
-	if !i.batchMode {
-		panic("BatchedAdd() called on an index that wasn't in batch mode!")
+func (i *StringListIndex) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	bucket, err := tx.Bucket(indexBucket).CreateBucketIfNotExists([]byte(i.Name))
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to open bucket for index %s", i.Name)
 	}
 
-	if i.cache == nil {
-		return
+	return bucket, nil
+}
+
+// put stores the new list for key. Has to be called with pendingLock held.
+func (i *StringListIndex) put(tx *bolt.Tx, key string, list []string) error {
+	changes := i.begin(tx)
+	bucket, err := i.bucket(tx)
+	if err != nil {
+		return err
 	}
 
-	for idx, item := range i.cache[key] {
-		if item == value {
-			i.cache[key] = append(i.cache[key][:idx], i.cache[key][idx+1:]...)
-			break
+	if len(list) == 0 {
+		changes.values[key] = nil
+		err = bucket.Delete([]byte(key))
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete %s from index %s", key, i.Name)
 		}
+		return nil
 	}
-}
 
-func (i *StringListIndex) BatchedRemoveAll(key string) {
-	if !i.batchMode {
-		panic("BatchedAdd() called on an index that wasn't in batch mode!")
+	err = i.sorter(key, list)
+	if err != nil {
+		return err
 	}
 
-	if i.cache == nil {
-		return
+	// Remove duplicates
+	last := list[len(list)-1]
+	for idx := len(list) - 2; idx >= 0; idx-- {
+		if list[idx] == last {
+			list = append(list[:idx], list[idx+1:]...)
+		} else {
+			last = list[idx]
+		}
 	}
 
-	delete(i.cache, key)
-}
-
-func (i *StringListIndex) Add(tx *bolt.Tx, key, value string) error {
-	if i.cache == nil {
-		i.Clear()
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return eris.Wrapf(err, "failed to serialise %s in index %s", key, i.Name)
 	}
-	i.cache[key] = append(i.cache[key], value)
 
-	if !i.batchMode {
-		return i.Save(tx)
+	err = bucket.Put([]byte(key), encoded)
+	if err != nil {
+		return eris.Wrapf(err, "failed to save %s in index %s", key, i.Name)
 	}
 
+	changes.values[key] = list
 	return nil
 }
 
-func (i *StringListIndex) Remove(tx *bolt.Tx, key, value string) error {
-	if i.cache == nil {
-		i.Clear()
-	}
+// Clear removes all keys from the index
+func (i *StringListIndex) Clear(tx *bolt.Tx) error {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
 
-	for idx, item := range i.cache[key] {
-		if item == value {
-			i.cache[key] = append(i.cache[key][:idx], i.cache[key][idx+1:]...)
-			break
+	changes := i.begin(tx)
+	parent := tx.Bucket(indexBucket)
+	if parent.Bucket([]byte(i.Name)) != nil {
+		err := parent.DeleteBucket([]byte(i.Name))
+		if err != nil {
+			return eris.Wrapf(err, "failed to clear index %s", i.Name)
 		}
 	}
 
-	if !i.batchMode {
-		return i.Save(tx)
-	}
-
+	changes.cleared = true
+	changes.values = make(map[string][]string)
 	return nil
 }
 
-func (i *StringListIndex) RemoveAll(tx *bolt.Tx, key, value string) error {
-	if i.cache == nil {
-		i.Clear()
+func (i *StringListIndex) Add(tx *bolt.Tx, key, value string) error {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	list := copyList(i.lookup(tx, key))
+	for _, item := range list {
+		if item == value {
+			return nil
+		}
 	}
 
-	delete(i.cache, key)
+	return i.put(tx, key, append(list, value))
+}
 
-	if !i.batchMode {
-		return i.Save(tx)
+func (i *StringListIndex) Remove(tx *bolt.Tx, key, value string) error {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	list := copyList(i.lookup(tx, key))
+	for idx, item := range list {
+		if item == value {
+			return i.put(tx, key, append(list[:idx], list[idx+1:]...))
+		}
 	}
 
 	return nil
 }
 
-func (i *StringListIndex) Save(tx *bolt.Tx) error {
-	if i.cache == nil {
-		i.Clear()
+func (i *StringListIndex) RemoveAll(tx *bolt.Tx, key string) error {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	if i.lookup(tx, key) == nil {
+		return nil
 	}
 
-	err := i.ForEach(i.sorter)
+	return i.put(tx, key, nil)
+}
+
+// Replace swaps the contents of the index with the passed lists
+func (i *StringListIndex) Replace(tx *bolt.Tx, contents map[string][]string) error {
+	err := i.Clear(tx)
 	if err != nil {
 		return err
 	}
 
-	// Remove duplicates
-	for k, v := range i.cache {
-		if len(v) > 0 {
-			last := v[len(v)-1]
-			for idx := len(v) - 2; idx >= 0; idx-- {
-				if v[idx] == last {
-					v = append(v[:idx], v[idx+1:]...)
-				} else {
-					last = v[idx]
-				}
-			}
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
 
-			i.cache[k] = v
+	for key, list := range contents {
+		err = i.put(tx, key, copyList(list))
+		if err != nil {
+			return err
 		}
 	}
 
-	encoded, err := json.Marshal(i.cache)
-	if err != nil {
-		return eris.Wrapf(err, "failed to serialise index %s", i.Name)
-	}
-
-	bucket := tx.Bucket(indexBucket)
-	err = bucket.Put([]byte(i.Name), encoded)
-	if err != nil {
-		return eris.Wrapf(err, "failed to save index %s", i.Name)
-	}
-
 	return nil
 }
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+// buildModIndexes reads the passed mod bucket and returns the contents the version and type indexes should have
+func buildModIndexes(bucket *bolt.Bucket) (map[string][]string, map[string][]string, error) {
+	versions := make(map[string][]string)
+	types := make(map[string][]string)
+	if bucket == nil {
+		return versions, types, nil
+	}
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(k) == 0 || k[0] == '#' {
+			return nil
+		}
+
+		if isReleaseKey(k) {
+			var rel common.Release
+			err := proto.Unmarshal(v, &rel)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise release %s", k)
+			}
+
+			versions[rel.Modid] = append(versions[rel.Modid], rel.Version)
+			return nil
+		}
+
+		var mod common.ModMeta
+		err := proto.Unmarshal(v, &mod)
+		if err != nil {
+			return eris.Wrapf(err, "failed to deserialise mod %s", k)
+		}
+
+		types[mod.Type.String()] = append(types[mod.Type.String()], mod.Modid)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return versions, types, nil
+}
+
+// compareIndex returns a description of every difference between the index and the expected contents
+func compareIndex(tx *bolt.Tx, idx *StringListIndex, expected map[string][]string) []string {
+	problems := make([]string, 0)
+	actual := idx.snapshot(tx)
+
+	for key, list := range expected {
+		present := make(map[string]bool)
+		for _, item := range actual[key] {
+			present[item] = true
+		}
+
+		for _, item := range list {
+			if !present[item] {
+				problems = append(problems, fmt.Sprintf("%s: %s is missing %s", idx.Name, key, item))
+			}
+		}
+	}
+
+	for key, list := range actual {
+		present := make(map[string]bool)
+		for _, item := range expected[key] {
+			present[item] = true
+		}
+
+		for _, item := range list {
+			if !present[item] {
+				problems = append(problems, fmt.Sprintf("%s: %s contains unknown entry %s", idx.Name, key, item))
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// checkModIndexes compares the provider's indexes with its mod bucket and rebuilds them if repair is set and they
+// don't match
+func checkModIndexes(tx *bolt.Tx, provider genericModProvider, repair bool) ([]string, error) {
+	versions, types, err := buildModIndexes(tx.Bucket(provider.bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	problems := compareIndex(tx, provider.versionIndex, versions)
+	problems = append(problems, compareIndex(tx, provider.typeIndex, types)...)
+
+	if repair && len(problems) > 0 {
+		err = provider.versionIndex.Replace(tx, versions)
+		if err != nil {
+			return nil, err
+		}
+
+		err = provider.typeIndex.Replace(tx, types)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return problems, nil
+}
+
+// CheckModIndexes verifies that the local and remote mod indexes match the stored mods. If repair is set, broken
+// indexes are rebuilt.
+func CheckModIndexes(ctx context.Context, repair bool) (*client.IndexCheckReport, error) {
+	report := &client.IndexCheckReport{
+		Problems: make([]string, 0),
+	}
+
+	cb := func(tx *bolt.Tx) error {
+		for _, provider := range []genericModProvider{LocalMods, RemoteMods} {
+			problems, err := checkModIndexes(tx, provider, repair)
+			if err != nil {
+				return err
+			}
+
+			report.Problems = append(report.Problems, problems...)
+		}
+
+		problems, err := checkRemoteSearchIndexes(tx, repair)
+		if err != nil {
+			return err
+		}
+
+		report.Problems = append(report.Problems, problems...)
+		return nil
+	}
+
+	var err error
+	if repair {
+		err = update(ctx, cb)
+	} else {
+		err = view(ctx, cb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report.Rebuilt = repair && len(report.Problems) > 0
+	for _, problem := range report.Problems {
+		api.Log(ctx, api.LogWarn, "Index problem: %s", problem)
+	}
+
+	return report, nil
+}
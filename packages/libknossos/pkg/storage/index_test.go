@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/common"
+)
+
+func openIndexTestDB(t *testing.T) *bolt.DB {
+	t.Helper()
+
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(indexBucket)
+		return err
+	})
+	return testDB
+}
+
+func TestStringListIndexTransactions(t *testing.T) {
+	t.Parallel()
+
+	testDB := openIndexTestDB(t)
+	idx := NewStringListIndex("test", nil)
+	errRollback := eris.New("rollback")
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		for _, value := range []string{"b", "a", "b"} {
+			err := idx.Add(tx, "key", value)
+			if err != nil {
+				return err
+			}
+		}
+
+		if got := idx.Lookup(tx, "key"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Errorf("expected [a b] inside the transaction but got %v", got)
+		}
+		if got := idx.Lookup(nil, "key"); got != nil {
+			t.Errorf("uncommitted changes are visible to other readers: %v", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := idx.Lookup(nil, "key"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("expected [a b] after commit but got %v", got)
+	}
+
+	err = testDB.Update(func(tx *bolt.Tx) error {
+		err := idx.Clear(tx)
+		if err != nil {
+			return err
+		}
+
+		err = idx.Add(tx, "other", "c")
+		if err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !eris.Is(err, errRollback) {
+		t.Fatal(err)
+	}
+
+	if got := idx.Lookup(nil, "key"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("rolled back clear changed the index: %v", got)
+	}
+	if got := idx.Lookup(nil, "other"); got != nil {
+		t.Fatalf("rolled back add changed the index: %v", got)
+	}
+
+	err = testDB.Update(func(tx *bolt.Tx) error {
+		return idx.Remove(tx, "key", "a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := NewStringListIndex("test", nil)
+	err = testDB.View(reopened.Open)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := reopened.snapshot(nil); !reflect.DeepEqual(got, map[string][]string{"key": {"b"}}) {
+		t.Fatalf("unexpected contents after reopening: %v", got)
+	}
+}
+
+func TestCheckModIndexes(t *testing.T) {
+	t.Parallel()
+
+	testDB := openIndexTestDB(t)
+	provider := genericModProvider{
+		bucket:       []byte("test_mods"),
+		versionIndex: NewStringListIndex("test_versions", modVersionSorter),
+		typeIndex:    NewStringListIndex("test_types", nil),
+	}
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(provider.bucket)
+		if err != nil {
+			return err
+		}
+
+		items := map[string]proto.Message{
+			"mod":        &common.ModMeta{Modid: "mod", Type: common.ModType_MOD},
+			"mod#1.0.0":  &common.Release{Modid: "mod", Version: "1.0.0"},
+			"mod#1.10.0": &common.Release{Modid: "mod", Version: "1.10.0"},
+		}
+		for key, item := range items {
+			encoded, err := proto.Marshal(item)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(key), encoded)
+			if err != nil {
+				return err
+			}
+		}
+
+		// one missing and one dangling entry
+		err = provider.versionIndex.Add(tx, "mod", "1.0.0")
+		if err != nil {
+			return err
+		}
+		return provider.versionIndex.Add(tx, "gone", "1.0.0")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var problems []string
+	err = testDB.Update(func(tx *bolt.Tx) error {
+		problems, err = checkModIndexes(tx, provider, true)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(problems) != 3 {
+		t.Errorf("expected 3 problems but got %v", problems)
+	}
+
+	if got := provider.versionIndex.snapshot(nil); !reflect.DeepEqual(got, map[string][]string{"mod": {"1.0.0", "1.10.0"}}) {
+		t.Errorf("unexpected version index after rebuild: %v", got)
+	}
+	if got := provider.typeIndex.snapshot(nil); !reflect.DeepEqual(got, map[string][]string{"MOD": {"mod"}}) {
+		t.Errorf("unexpected type index after rebuild: %v", got)
+	}
+
+	err = testDB.View(func(tx *bolt.Tx) error {
+		problems, err = checkModIndexes(tx, provider, false)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("rebuilt index still has problems: %v", problems)
+	}
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+var (
+	launchHistoryBucket = []byte("launch_history")
+	// playtimeBucket contains a running total of the launch history for each mod so that GetPlaytimes doesn't have to
+	// read the whole history
+	playtimeBucket = []byte("playtimes")
+)
+
+func launchHistoryKey(entry *client.LaunchHistoryEntry) []byte {
+	// Zero-padding the timestamp keeps the entries for each mod sorted by their start time
+	return []byte(fmt.Sprintf("%s#%020d", entry.Modid, entry.Started.AsTime().UnixNano()))
+}
+
+// launchDuration returns the length of the passed launch in seconds. Launches which are still running count as 0.
+func launchDuration(entry *client.LaunchHistoryEntry) uint64 {
+	if entry.Ended == nil {
+		return 0
+	}
+
+	duration := entry.Ended.AsTime().Sub(entry.Started.AsTime())
+	if duration < 0 {
+		return 0
+	}
+	return uint64(duration.Seconds())
+}
+
+// addToPlaytime updates the running total of entry's mod. previous is the stored version of entry or nil if entry is
+// a new launch.
+func addToPlaytime(tx *bolt.Tx, entry, previous *client.LaunchHistoryEntry) error {
+	bucket := tx.Bucket(playtimeBucket)
+	info := new(client.PlaytimeResponse_ModPlaytime)
+	if encoded := bucket.Get([]byte(entry.Modid)); encoded != nil {
+		err := proto.Unmarshal(encoded, info)
+		if err != nil {
+			return eris.Wrapf(err, "failed to deserialise playtime of %s", entry.Modid)
+		}
+	}
+
+	info.Modid = entry.Modid
+	if previous == nil {
+		info.Launches++
+	} else {
+		prevDuration := launchDuration(previous)
+		if prevDuration > info.Playtime {
+			prevDuration = info.Playtime
+		}
+		info.Playtime -= prevDuration
+	}
+	info.Playtime += launchDuration(entry)
+
+	if info.LastPlayed == nil || entry.Started.AsTime().After(info.LastPlayed.AsTime()) {
+		info.LastPlayed = entry.Started
+	}
+
+	encoded, err := proto.Marshal(info)
+	if err != nil {
+		return eris.Wrapf(err, "failed to serialise playtime of %s", entry.Modid)
+	}
+
+	err = bucket.Put([]byte(entry.Modid), encoded)
+	if err != nil {
+		return eris.Wrapf(err, "failed to save playtime of %s", entry.Modid)
+	}
+
+	return nil
+}
+
+// SaveLaunchHistoryEntry creates or updates the passed entry. Entries are identified by their mod ID and start time.
+func SaveLaunchHistoryEntry(ctx context.Context, entry *client.LaunchHistoryEntry) error {
+	return update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(launchHistoryBucket)
+		key := launchHistoryKey(entry)
+
+		var previous *client.LaunchHistoryEntry
+		if encoded := bucket.Get(key); encoded != nil {
+			previous = new(client.LaunchHistoryEntry)
+			err := proto.Unmarshal(encoded, previous)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise launch history entry %s", key)
+			}
+		}
+
+		encoded, err := proto.Marshal(entry)
+		if err != nil {
+			return eris.Wrapf(err, "failed to serialise launch history entry for %s", entry.Modid)
+		}
+
+		err = bucket.Put(key, encoded)
+		if err != nil {
+			return eris.Wrapf(err, "failed to save launch history entry for %s", entry.Modid)
+		}
+
+		return addToPlaytime(tx, entry, previous)
+	})
+}
+
+// GetLaunchHistory returns all recorded launches for the given mod (or all mods if modID is empty), most recent first
+func GetLaunchHistory(ctx context.Context, modID string) ([]*client.LaunchHistoryEntry, error) {
+	result := make([]*client.LaunchHistoryEntry, 0)
+	err := view(ctx, func(tx *bolt.Tx) error {
+		var prefix []byte
+		if modID != "" {
+			prefix = []byte(modID + "#")
+		}
+
+		cursor := tx.Bucket(launchHistoryBucket).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			entry := new(client.LaunchHistoryEntry)
+			err := proto.Unmarshal(v, entry)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise launch history entry %s", k)
+			}
+
+			result = append(result, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started.AsTime().After(result[j].Started.AsTime())
+	})
+	return result, nil
+}
+
+// GetPlaytimes returns the total playtime, number of launches and last launch of each mod
+func GetPlaytimes(ctx context.Context) (map[string]*client.PlaytimeResponse_ModPlaytime, error) {
+	result := make(map[string]*client.PlaytimeResponse_ModPlaytime)
+	err := view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(playtimeBucket).ForEach(func(k, v []byte) error {
+			info := new(client.PlaytimeResponse_ModPlaytime)
+			err := proto.Unmarshal(v, info)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise playtime of %s", k)
+			}
+
+			result[info.Modid] = info
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
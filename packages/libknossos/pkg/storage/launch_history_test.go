@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ngld/knossos/packages/api/client"
+)
+
+func TestPlaytimes(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	entries := []*client.LaunchHistoryEntry{
+		{Modid: "mva", Started: timestamppb.New(start), Ended: timestamppb.New(start.Add(time.Hour))},
+		{Modid: "mva", Started: timestamppb.New(start.Add(24 * time.Hour))},
+		{Modid: "bp", Started: timestamppb.New(start.Add(2 * time.Hour))},
+	}
+
+	// The launch history of an existing DB; the migration has to pick it up
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(launchHistoryBucket)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := proto.Marshal(entries[0])
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put(launchHistoryKey(entries[0]), encoded)
+		if err != nil {
+			return err
+		}
+
+		return migratePlaytimes(testContext(), tx)
+	})
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		ctx := CtxWithTx(testContext(), tx)
+		for _, entry := range entries[1:] {
+			err := SaveLaunchHistoryEntry(ctx, entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The second mva launch ends after 30 minutes; this updates the existing entry
+		finished := proto.Clone(entries[1]).(*client.LaunchHistoryEntry)
+		finished.Ended = timestamppb.New(start.Add(24*time.Hour + 30*time.Minute))
+		err := SaveLaunchHistoryEntry(ctx, finished)
+		if err != nil {
+			return err
+		}
+
+		playtimes, err := GetPlaytimes(ctx)
+		if err != nil {
+			return err
+		}
+
+		expected := map[string]*client.PlaytimeResponse_ModPlaytime{
+			"mva": {Modid: "mva", Playtime: 5400, Launches: 2, LastPlayed: entries[1].Started},
+			"bp":  {Modid: "bp", Playtime: 0, Launches: 1, LastPlayed: entries[2].Started},
+		}
+		if len(playtimes) != len(expected) {
+			t.Errorf("expected playtimes for %d mods but got %v", len(expected), playtimes)
+		}
+
+		for modID, info := range expected {
+			if !proto.Equal(playtimes[modID], info) {
+				t.Errorf("expected %v for %s but got %v", info, modID, playtimes[modID])
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+var (
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+)
+
+type migration struct {
+	// version is the schema version after this migration ran
+	version     uint32
+	description string
+	migrate     func(context.Context, *bolt.Tx) error
+}
+
+// migrations has to be sorted by version. Never change or remove existing entries; append a new migration instead.
+var migrations = []migration{
+	{1, "drop engine flags without binary fingerprint", migrateEngineFlagFingerprints},
+	{2, "store mod indexes as one record per key", migrateSplitIndexes},
+	{3, "build remote mod search indexes", migrateRemoteSearchIndexes},
+	{4, "import image references of remote mods", migrateRemoteFiles},
+	{5, "assign remote mods to the default repository", migrateRemoteRepositories},
+	{6, "sum up the playtime of each mod", migratePlaytimes},
+}
+
+// currentSchemaVersion is the schema version written by this build
+var currentSchemaVersion = migrations[len(migrations)-1].version
+
+// ErrSchemaTooNew is returned by Open if the state DB was written by a newer version of Knossos
+var ErrSchemaTooNew = eris.New("the state DB was created by a newer version of Knossos")
+
+func readSchemaVersion(tx *bolt.Tx) (uint32, error) {
+	bucket := tx.Bucket(metaBucket)
+	if bucket == nil {
+		return 0, nil
+	}
+
+	encoded := bucket.Get(schemaVersionKey)
+	if encoded == nil {
+		return 0, nil
+	}
+	if len(encoded) != 4 {
+		return 0, eris.Errorf("invalid schema version %v", encoded)
+	}
+
+	return binary.BigEndian.Uint32(encoded), nil
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version uint32) error {
+	bucket, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return eris.Wrap(err, "failed to create meta bucket")
+	}
+
+	encoded := make([]byte, 4)
+	binary.BigEndian.PutUint32(encoded, version)
+	err = bucket.Put(schemaVersionKey, encoded)
+	if err != nil {
+		return eris.Wrap(err, "failed to save schema version")
+	}
+
+	return nil
+}
+
+// isEmptyDB returns true if the DB doesn't contain any buckets yet
+func isEmptyDB(tx *bolt.Tx) bool {
+	key, _ := tx.Cursor().First()
+	return key == nil
+}
+
+// migrateDB brings stateDB up to currentSchemaVersion. Before any migration runs, the DB is copied to
+// <dbPath>.v<old version>.bak.
+func migrateDB(ctx context.Context, stateDB *bolt.DB, dbPath string) error {
+	var version uint32
+	fresh := false
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		var err error
+		fresh = isEmptyDB(tx)
+		version, err = readSchemaVersion(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if fresh {
+		// Nothing to migrate in a new DB
+		return stateDB.Update(func(tx *bolt.Tx) error {
+			return writeSchemaVersion(tx, currentSchemaVersion)
+		})
+	}
+
+	if version > currentSchemaVersion {
+		return eris.Wrapf(ErrSchemaTooNew, "schema version %d is newer than the supported version %d", version,
+			currentSchemaVersion)
+	}
+
+	if version == currentSchemaVersion {
+		return nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d.bak", dbPath, version)
+	api.Log(ctx, api.LogInfo, "Migrating state DB from schema version %d to %d, saving backup to %s", version,
+		currentSchemaVersion, backupPath)
+	err = stateDB.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(backupPath, 0o600)
+	})
+	if err != nil {
+		return eris.Wrapf(err, "failed to back up state DB to %s", backupPath)
+	}
+
+	// All migrations run in one transaction so that a failure leaves the DB untouched
+	err = stateDB.Update(func(tx *bolt.Tx) error {
+		for _, step := range migrations {
+			if step.version <= version {
+				continue
+			}
+
+			api.Log(ctx, api.LogInfo, "Running state DB migration %d: %s", step.version, step.description)
+			err := step.migrate(ctx, tx)
+			if err != nil {
+				return eris.Wrapf(err, "migration %d (%s) failed", step.version, step.description)
+			}
+		}
+
+		return writeSchemaVersion(tx, currentSchemaVersion)
+	})
+	if err != nil {
+		return eris.Wrapf(err, "failed to migrate state DB; a backup is available at %s", backupPath)
+	}
+
+	return nil
+}
+
+// migrateEngineFlagFingerprints removes cached engine flags from before we started storing a fingerprint of the
+// binary. They'd be ignored anyway and just take up space.
+func migrateEngineFlagFingerprints(ctx context.Context, tx *bolt.Tx) error {
+	bucket := tx.Bucket(engineFlagsBucket)
+	if bucket == nil {
+		return nil
+	}
+
+	stale := make([][]byte, 0)
+	err := bucket.ForEach(func(k, v []byte) error {
+		var entry engineFlagsEntry
+		err := json.Unmarshal(v, &entry)
+		if err != nil || entry.Hash == "" || entry.Flags == nil {
+			stale = append(stale, append([]byte{}, k...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range stale {
+		err = bucket.Delete(key)
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete %s", key)
+		}
+	}
+
+	api.Log(ctx, api.LogInfo, "Removed %d outdated engine flag entries", len(stale))
+	return nil
+}
+
+// migrateSplitIndexes replaces the JSON blobs which used to contain a whole index with nested buckets and rebuilds
+// the indexes from the mod buckets. The old local type index was never populated so we can't simply convert them.
+func migrateSplitIndexes(ctx context.Context, tx *bolt.Tx) error {
+	bucket, err := tx.CreateBucketIfNotExists(indexBucket)
+	if err != nil {
+		return eris.Wrap(err, "failed to create index bucket")
+	}
+
+	for _, provider := range []genericModProvider{LocalMods, RemoteMods} {
+		for _, idx := range []*StringListIndex{provider.versionIndex, provider.typeIndex} {
+			if bucket.Get([]byte(idx.Name)) != nil {
+				err = bucket.Delete([]byte(idx.Name))
+				if err != nil {
+					return eris.Wrapf(err, "failed to delete old index %s", idx.Name)
+				}
+			}
+		}
+
+		_, err = checkModIndexes(tx, provider, true)
+		if err != nil {
+			return err
+		}
+
+		api.Log(ctx, api.LogInfo, "Rebuilt indexes %s and %s", provider.versionIndex.Name, provider.typeIndex.Name)
+	}
+
+	return nil
+}
+
+// migrateRemoteSearchIndexes builds the indexes for SearchRemoteMods. Otherwise they'd stay empty until the next sync.
+func migrateRemoteSearchIndexes(_ context.Context, tx *bolt.Tx) error {
+	if tx.Bucket(remoteModsBucket) == nil {
+		return nil
+	}
+
+	_, err := tx.CreateBucketIfNotExists(indexBucket)
+	if err != nil {
+		return eris.Wrap(err, "failed to create index bucket")
+	}
+
+	return updateRemoteSearchIndexes(tx)
+}
+
+// migrateRemoteFiles adds the image references of already synced remote releases to the file bucket. Otherwise the
+// image cache couldn't serve them until the releases change on Nebula.
+func migrateRemoteFiles(_ context.Context, tx *bolt.Tx) error {
+	bucket := tx.Bucket(remoteModsBucket)
+	if bucket == nil {
+		return nil
+	}
+
+	_, err := tx.CreateBucketIfNotExists(fileBucket)
+	if err != nil {
+		return eris.Wrap(err, "failed to create file bucket")
+	}
+
+	return bucket.ForEach(func(k, v []byte) error {
+		if !isReleaseKey(k) {
+			return nil
+		}
+
+		var rel common.Release
+		err := proto.Unmarshal(v, &rel)
+		if err != nil {
+			return eris.Wrapf(err, "failed to deserialise release %s", k)
+		}
+
+		return importRemoteFiles(tx, &rel)
+	})
+}
+
+// migrateRemoteRepositories attributes all synced remote mods to the default repository and moves the modification
+// dates of the synced files to the repository state. Otherwise the first sync would have to download everything again.
+func migrateRemoteRepositories(_ context.Context, tx *bolt.Tx) error {
+	bucket := tx.Bucket(remoteModsBucket)
+	if bucket == nil {
+		return nil
+	}
+
+	repoBucket, err := tx.CreateBucketIfNotExists(remoteModReposBucket)
+	if err != nil {
+		return eris.Wrap(err, "failed to create remote mod repository bucket")
+	}
+
+	stateBucket, err := tx.CreateBucketIfNotExists(remoteRepoStateBucket)
+	if err != nil {
+		return eris.Wrap(err, "failed to create remote repository state bucket")
+	}
+
+	lastModifiedKey := []byte("#last_modifieds")
+	if dates := bucket.Get(lastModifiedKey); dates != nil {
+		err = stateBucket.Put([]byte(DefaultRepositoryName), append([]byte{}, dates...))
+		if err != nil {
+			return eris.Wrap(err, "failed to save last modified dates for the default repository")
+		}
+
+		err = bucket.Delete(lastModifiedKey)
+		if err != nil {
+			return eris.Wrap(err, "failed to delete old last modified dates")
+		}
+	}
+
+	modIDs := make([][]byte, 0)
+	err = bucket.ForEach(func(k, v []byte) error {
+		if len(k) > 0 && bytes.IndexByte(k, '#') == -1 {
+			modIDs = append(modIDs, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, modID := range modIDs {
+		err = repoBucket.Put(modID, []byte(DefaultRepositoryName))
+		if err != nil {
+			return eris.Wrapf(err, "failed to save repository of mod %s", modID)
+		}
+	}
+
+	return nil
+}
+
+// migratePlaytimes builds the running playtime totals from the existing launch history
+func migratePlaytimes(_ context.Context, tx *bolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists(playtimeBucket)
+	if err != nil {
+		return eris.Wrap(err, "failed to create playtime bucket")
+	}
+
+	bucket := tx.Bucket(launchHistoryBucket)
+	if bucket == nil {
+		return nil
+	}
+
+	return bucket.ForEach(func(k, v []byte) error {
+		entry := new(client.LaunchHistoryEntry)
+		err := proto.Unmarshal(v, entry)
+		if err != nil {
+			return eris.Wrapf(err, "failed to deserialise launch history entry %s", k)
+		}
+
+		return addToPlaytime(tx, entry, nil)
+	})
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+func testContext() context.Context {
+	return api.WithKnossosContext(context.Background(), api.KnossosCtxParams{
+		LogCallback: func(api.LogLevel, string, ...interface{}) {},
+	})
+}
+
+func openTestDB(t *testing.T, setup func(*bolt.Tx) error) (*bolt.DB, string) {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "state.db")
+	testDB, err := bolt.Open(dbPath, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+
+	if setup != nil {
+		err = testDB.Update(setup)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return testDB, dbPath
+}
+
+func TestMigrateDB(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		setup      func(*bolt.Tx) error
+		err        error
+		backup     string
+		staleFlags bool
+	}{
+		{
+			name: "fresh DB",
+		},
+		{
+			name: "unversioned DB",
+			setup: func(tx *bolt.Tx) error {
+				bucket, err := tx.CreateBucket(engineFlagsBucket)
+				if err != nil {
+					return err
+				}
+
+				err = bucket.Put([]byte("file#old"), []byte(`{"Version": {"Major": 21}}`))
+				if err != nil {
+					return err
+				}
+
+				return bucket.Put([]byte("file#new"), []byte(`{"Hash": "abc", "Flags": {}}`))
+			},
+			backup:     "state.db.v0.bak",
+			staleFlags: true,
+		},
+		{
+			name: "current DB",
+			setup: func(tx *bolt.Tx) error {
+				return writeSchemaVersion(tx, currentSchemaVersion)
+			},
+		},
+		{
+			name: "newer DB",
+			setup: func(tx *bolt.Tx) error {
+				return writeSchemaVersion(tx, currentSchemaVersion+1)
+			},
+			err: ErrSchemaTooNew,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDB, dbPath := openTestDB(t, test.setup)
+			err := migrateDB(testContext(), testDB, dbPath)
+			if test.err != nil {
+				if !eris.Is(err, test.err) {
+					t.Fatalf("expected %v but got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.backup != "" {
+				_, err = os.Stat(filepath.Join(filepath.Dir(dbPath), test.backup))
+				if err != nil {
+					t.Fatalf("backup is missing: %v", err)
+				}
+			}
+
+			err = testDB.View(func(tx *bolt.Tx) error {
+				version, err := readSchemaVersion(tx)
+				if err != nil {
+					return err
+				}
+				if version != currentSchemaVersion {
+					t.Errorf("expected schema version %d but got %d", currentSchemaVersion, version)
+				}
+
+				if test.staleFlags {
+					bucket := tx.Bucket(engineFlagsBucket)
+					if bucket.Get([]byte("file#old")) != nil {
+						t.Error("outdated engine flags were not removed")
+					}
+					if bucket.Get([]byte("file#new")) == nil {
+						t.Error("current engine flags were removed")
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
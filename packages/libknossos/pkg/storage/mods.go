@@ -59,21 +59,30 @@ func ImportMods(ctx context.Context, callback func(context.Context) error) error
 		bucket := tx.Bucket(localModsBucket)
 
 		// Remove existing entries
+		keys := make([][]byte, 0)
 		err := bucket.ForEach(func(k, _ []byte) error {
-			err := bucket.Delete(k)
-			if err != nil {
-				return eris.Wrapf(err, "failed to delete key %v in local mod storage", k)
-			}
-
+			keys = append(keys, append([]byte{}, k...))
 			return nil
 		})
 		if err != nil {
 			return err
 		}
 
-		// Clear indexes to make sure nothing uses them during the import since they'd be empty during the initial import.
-		localVersionIdx.Clear()
-		localTypeIdx.Clear()
+		err = deleteKeys(bucket, keys)
+		if err != nil {
+			return eris.Wrap(err, "failed to clear local mod storage")
+		}
+
+		// The indexes are rebuilt by the import. Other readers keep seeing the old state until the import is done.
+		err = localVersionIdx.Clear(tx)
+		if err != nil {
+			return err
+		}
+
+		err = localTypeIdx.Clear(tx)
+		if err != nil {
+			return err
+		}
 
 		// Call the actual import function
 		return callback(CtxWithTx(ctx, tx))
@@ -121,6 +130,11 @@ func SaveLocalMod(ctx context.Context, mod *common.ModMeta) error {
 		defer importMutex.Unlock()
 
 		bucket := tx.Bucket(localModsBucket)
+		err := updateTypeIndex(tx, bucket, localTypeIdx, mod)
+		if err != nil {
+			return err
+		}
+
 		encoded, err := proto.Marshal(mod)
 		if err != nil {
 			return eris.Wrapf(err, "failed to serialise mod %s", mod.Modid)
@@ -135,6 +149,23 @@ func SaveLocalMod(ctx context.Context, mod *common.ModMeta) error {
 	})
 }
 
+// updateTypeIndex adds mod to the type index and removes the previous entry if its type changed. Has to be called
+// before the mod is saved to bucket.
+func updateTypeIndex(tx *bolt.Tx, bucket *bolt.Bucket, idx *StringListIndex, mod *common.ModMeta) error {
+	if encoded := bucket.Get([]byte(mod.Modid)); encoded != nil {
+		var previous common.ModMeta
+		err := proto.Unmarshal(encoded, &previous)
+		if err == nil && previous.Type != mod.Type {
+			err = idx.Remove(tx, previous.Type.String(), mod.Modid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return idx.Add(tx, mod.Type.String(), mod.Modid)
+}
+
 func SaveLocalModRelease(ctx context.Context, release *common.Release) error {
 	tx := TxFromCtx(ctx)
 	if tx == nil {
@@ -147,22 +178,9 @@ func SaveLocalModRelease(ctx context.Context, release *common.Release) error {
 	defer importMutex.Unlock()
 
 	bucket := tx.Bucket(localModsBucket)
-	versions := localVersionIdx.Lookup(release.Modid)
-
-	isNew := true
-	for _, ver := range versions {
-		if ver == release.Version {
-			isNew = false
-			break
-		}
-	}
-
-	// Update the version index for new mods
-	if isNew {
-		err := localVersionIdx.Add(tx, release.Modid, release.Version)
-		if err != nil {
-			return err
-		}
+	err := localVersionIdx.Add(tx, release.Modid, release.Version)
+	if err != nil {
+		return err
 	}
 
 	// Finally, we can save the actual mod
@@ -214,6 +232,35 @@ func DeleteLocalModRelease(ctx context.Context, release *common.Release) error {
 	return nil
 }
 
+// DeleteLocalMod removes the metadata for the given mod. Its releases have to be deleted separately.
+func DeleteLocalMod(ctx context.Context, modID string) error {
+	return update(ctx, func(tx *bolt.Tx) error {
+		importMutex.Lock()
+		defer importMutex.Unlock()
+
+		bucket := tx.Bucket(localModsBucket)
+		if encoded := bucket.Get([]byte(modID)); encoded != nil {
+			var mod common.ModMeta
+			err := proto.Unmarshal(encoded, &mod)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise mod %s", modID)
+			}
+
+			err = localTypeIdx.Remove(tx, mod.Type.String(), modID)
+			if err != nil {
+				return eris.Wrapf(err, "failed to remove mod %s from type index", modID)
+			}
+		}
+
+		err := bucket.Delete([]byte(modID))
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete mod %s", modID)
+		}
+
+		return nil
+	})
+}
+
 func (p genericModProvider) GetMods(ctx context.Context) ([]*common.Release, error) {
 	var result []*common.Release
 
@@ -222,7 +269,7 @@ func (p genericModProvider) GetMods(ctx context.Context) ([]*common.Release, err
 		bucket := tx.Bucket(p.bucket)
 		result = make([]*common.Release, 0)
 
-		return p.versionIndex.ForEach(func(modID string, versions []string) error {
+		return p.versionIndex.ForEach(tx, func(modID string, versions []string) error {
 			if len(versions) < 1 {
 				return nil
 			}
@@ -317,16 +364,14 @@ func (p genericModProvider) GetModRelease(ctx context.Context, id string, versio
 }
 
 func (p genericModProvider) GetVersionsForMod(ctx context.Context, id string) ([]string, error) {
-	result := p.versionIndex.Lookup(id)
+	// Lookup() returns a copy so callers can't modify the index
+	result := p.versionIndex.Lookup(TxFromCtx(ctx), id)
 
 	if len(result) < 1 {
 		return nil, eris.Errorf("No versions found for mod %s", id)
 	}
 
-	// Protect against changes
-	resultCopy := make([]string, len(result))
-	copy(resultCopy, result)
-	return resultCopy, nil
+	return result, nil
 }
 
 var _ ModProvider = (*genericModProvider)(nil)
@@ -368,3 +413,60 @@ func GetUserSettingsForMod(ctx context.Context, id, version string) (*client.Use
 
 	return result, nil
 }
+
+// updateUserEngineOptions calls change for the user settings of every mod which uses the given engine. If oldVersion
+// is empty, all versions match.
+func updateUserEngineOptions(ctx context.Context, modID, oldVersion string, change func(*client.UserSettings)) error {
+	return update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(userModSettingsBucket)
+		updates := make(map[string][]byte)
+		err := bucket.ForEach(func(k, v []byte) error {
+			settings := new(client.UserSettings)
+			err := proto.Unmarshal(v, settings)
+			if err != nil {
+				return eris.Wrapf(err, "failed to deserialise user settings %s", k)
+			}
+
+			engOpts := settings.GetEngineOptions()
+			if engOpts.GetModid() != modID || (oldVersion != "" && engOpts.GetVersion() != oldVersion) {
+				return nil
+			}
+
+			change(settings)
+			encoded, err := proto.Marshal(settings)
+			if err != nil {
+				return eris.Wrapf(err, "failed to serialise user settings %s", k)
+			}
+
+			updates[string(k)] = encoded
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Modifying a bucket during ForEach is not allowed so we apply the changes afterwards.
+		for k, encoded := range updates {
+			err = bucket.Put([]byte(k), encoded)
+			if err != nil {
+				return eris.Wrapf(err, "failed to update user settings %s", k)
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReplaceUserEngineVersion updates all mods which use the given engine version to use newVersion instead
+func ReplaceUserEngineVersion(ctx context.Context, modID, oldVersion, newVersion string) error {
+	return updateUserEngineOptions(ctx, modID, oldVersion, func(settings *client.UserSettings) {
+		settings.EngineOptions.Version = newVersion
+	})
+}
+
+// ResetUserEngine switches all mods which use any version of the given engine back to their default engine
+func ResetUserEngine(ctx context.Context, modID string) error {
+	return updateUserEngineOptions(ctx, modID, "", func(settings *client.UserSettings) {
+		settings.EngineOptions = nil
+	})
+}
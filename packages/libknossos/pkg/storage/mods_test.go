@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+)
+
+// Not parallel since it uses the global local indexes which the other tests modify as well.
+func TestDeleteLocalMod(t *testing.T) {
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{indexBucket, localModsBucket} {
+			_, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return localTypeIdx.Open(tx)
+	})
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		ctx := CtxWithTx(testContext(), tx)
+		for _, mod := range []*common.ModMeta{
+			{Modid: "fso", Title: "FSO", Type: common.ModType_ENGINE},
+			{Modid: "custom_fso", Title: "Custom FSO", Type: common.ModType_ENGINE},
+		} {
+			err := SaveLocalMod(ctx, mod)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := DeleteLocalMod(ctx, "custom_fso")
+		if err != nil {
+			return err
+		}
+
+		if tx.Bucket(localModsBucket).Get([]byte("custom_fso")) != nil {
+			t.Error("custom_fso wasn't removed")
+		}
+
+		engines := localTypeIdx.Lookup(tx, common.ModType_ENGINE.String())
+		if len(engines) != 1 || engines[0] != "fso" {
+			t.Errorf("expected only fso in the type index but got %v", engines)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResetUserEngine(t *testing.T) {
+	t.Parallel()
+
+	settings := map[string]*client.UserSettings{
+		"mva#1.0.0": {EngineOptions: &client.UserSettings_EngineOptions{Modid: "custom_fso", Version: "23.1.0-20230401"}},
+		"bp#1.0.0":  {EngineOptions: &client.UserSettings_EngineOptions{Modid: "custom_fso", Version: "22.0.0"}, Cmdline: "-window"},
+		"fs2#1.0.0": {EngineOptions: &client.UserSettings_EngineOptions{Modid: "fso", Version: "23.0.0"}},
+	}
+
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(userModSettingsBucket)
+		if err != nil {
+			return err
+		}
+
+		for key, item := range settings {
+			encoded, err := proto.Marshal(item)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(key), encoded)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		err := ResetUserEngine(CtxWithTx(testContext(), tx), "custom_fso")
+		if err != nil {
+			return err
+		}
+
+		expected := map[string]*client.UserSettings{
+			"mva#1.0.0": {},
+			"bp#1.0.0":  {Cmdline: "-window"},
+			"fs2#1.0.0": settings["fs2#1.0.0"],
+		}
+		for key, item := range expected {
+			result := new(client.UserSettings)
+			err = proto.Unmarshal(tx.Bucket(userModSettingsBucket).Get([]byte(key)), result)
+			if err != nil {
+				return err
+			}
+
+			if !proto.Equal(result, item) {
+				t.Errorf("expected %v for %s but got %v", item, key, result)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
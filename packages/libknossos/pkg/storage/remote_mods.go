@@ -30,7 +30,10 @@ var (
 type RemoteIndexLastModifiedDates map[string][]time.Time
 
 type RemoteImportCallbackParams struct {
-	ForAllVersions    func(func(string, []string) error) error
+	ForAllVersions func(func(string, []string) error) error
+	// ModRepository returns the name of the repository which provided the passed mod or an empty string if the mod
+	// is unknown
+	ModRepository     func(string) string
 	RemoveMod         func(string) error
 	RemoveRelease     func(string, string) error
 	RemoveModReleases func(string) error
@@ -38,33 +41,61 @@ type RemoteImportCallbackParams struct {
 	AddRelease        func(*common.Release) error
 }
 
-func ImportRemoteMods(ctx context.Context, callback func(context.Context, RemoteImportCallbackParams) error) error {
+// removeRemoteMod deletes the passed mod, all of its releases and its provenance
+func removeRemoteMod(tx *bolt.Tx, bucket *bolt.Bucket, id string) error {
+	for _, version := range remoteVersionIdx.Lookup(tx, id) {
+		err := bucket.Delete([]byte(id + "#" + version))
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete mod release entry %s %s", id, version)
+		}
+	}
+
+	err := remoteVersionIdx.RemoveAll(tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Delete([]byte(id))
+	if err != nil {
+		return eris.Wrapf(err, "failed to delete mod entry %s", id)
+	}
+
+	err = tx.Bucket(remoteModReposBucket).Delete([]byte(id))
+	if err != nil {
+		return eris.Wrapf(err, "failed to delete repository of mod %s", id)
+	}
+
+	return nil
+}
+
+func setRemoteModRepository(tx *bolt.Tx, id, repo string) error {
+	err := tx.Bucket(remoteModReposBucket).Put([]byte(id), []byte(repo))
+	if err != nil {
+		return eris.Wrapf(err, "failed to save repository of mod %s", id)
+	}
+
+	return nil
+}
+
+// ImportRemoteMods runs callback in a write transaction and passes it functions to modify the remote mods. All added
+// mods are attributed to the repository repo.
+func ImportRemoteMods(ctx context.Context, repo string, callback func(context.Context, RemoteImportCallbackParams) error) error {
 	return db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(remoteModsBucket)
-
-		remoteVersionIdx.StartBatch()
-		remoteTypeIdx.StartBatch()
+		repoBucket := tx.Bucket(remoteModReposBucket)
 
 		ctx = CtxWithTx(ctx, tx)
 
 		// Call the actual import function
 		err := callback(ctx, RemoteImportCallbackParams{
-			ForAllVersions: remoteVersionIdx.ForEach,
+			ForAllVersions: func(cb func(string, []string) error) error {
+				return remoteVersionIdx.ForEach(tx, cb)
+			},
+			ModRepository: func(id string) string {
+				return string(repoBucket.Get([]byte(id)))
+			},
 			RemoveMod: func(id string) error {
-				for _, version := range remoteVersionIdx.Lookup(id) {
-					err := bucket.Delete([]byte(id + "#" + version))
-					if err != nil {
-						return eris.Wrapf(err, "failed to delete mod release entry %s %s", id, version)
-					}
-				}
-
-				remoteVersionIdx.BatchedRemoveAll(id)
-				err := bucket.Delete([]byte(id))
-				if err != nil {
-					return eris.Wrapf(err, "failed to delete mod entry %s", id)
-				}
-
-				return nil
+				return removeRemoteMod(tx, bucket, id)
 			},
 			RemoveRelease: func(id, version string) error {
 				err := bucket.Delete([]byte(id + "#" + version))
@@ -72,23 +103,31 @@ func ImportRemoteMods(ctx context.Context, callback func(context.Context, Remote
 					return eris.Wrapf(err, "failed to delete mod release %s %s", id, version)
 				}
 
-				remoteVersionIdx.BatchedRemove(id, version)
-				return nil
+				return remoteVersionIdx.Remove(tx, id, version)
 			},
 			RemoveModReleases: func(id string) error {
 				prefix := []byte(id + "#")
-				return bucket.ForEach(func(k, v []byte) error {
+				keys := make([][]byte, 0)
+				err := bucket.ForEach(func(k, v []byte) error {
 					if bytes.HasPrefix(k, prefix) {
-						err := bucket.Delete(k)
-						if err != nil {
-							return eris.Wrapf(err, "failed to delete mod release %s", k)
-						}
+						keys = append(keys, append([]byte{}, k...))
 					}
 
 					return nil
 				})
+				if err != nil {
+					return err
+				}
+
+				return deleteKeys(bucket, keys)
 			},
 			AddMod: func(mod *common.ModMeta) error {
+				// Add this mod to our type index
+				err := updateTypeIndex(tx, bucket, remoteTypeIdx, mod)
+				if err != nil {
+					return err
+				}
+
 				encoded, err := proto.Marshal(mod)
 				if err != nil {
 					return eris.Wrapf(err, "failed to serialise mod %s", mod.Modid)
@@ -99,9 +138,7 @@ func ImportRemoteMods(ctx context.Context, callback func(context.Context, Remote
 					return eris.Wrapf(err, "failed to save mod %s", mod.Modid)
 				}
 
-				// Add this mod to our type index
-				remoteTypeIdx.BatchedAdd(mod.Type.String(), mod.Modid)
-				return nil
+				return setRemoteModRepository(tx, mod.Modid, repo)
 			},
 			AddRelease: func(rel *common.Release) error {
 				encoded, err := proto.Marshal(rel)
@@ -114,67 +151,44 @@ func ImportRemoteMods(ctx context.Context, callback func(context.Context, Remote
 					return eris.Wrapf(err, "failed to save mod release %s %s", rel.Modid, rel.Version)
 				}
 
-				remoteVersionIdx.BatchedAdd(rel.Modid, rel.Version)
-				return nil
-			},
-		})
-		if err != nil {
-			return err
-		}
-
-		err = remoteVersionIdx.ForEach(func(ID string, versions []string) error {
-			count := len(versions)
-			for _, version := range versions {
-				rel := bucket.Get([]byte(ID + "#" + version))
-				if rel == nil {
-					remoteVersionIdx.BatchedRemove(ID, version)
-					count--
+				err = importRemoteFiles(tx, rel)
+				if err != nil {
+					return err
 				}
-			}
 
-			if count == 0 {
-				remoteVersionIdx.BatchedRemoveAll(ID)
-			}
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-
-		err = remoteTypeIdx.ForEach(func(modType string, IDs []string) error {
-			for _, ID := range IDs {
-				info := bucket.Get([]byte(ID))
-				if info == nil {
-					remoteTypeIdx.BatchedRemove(modType, ID)
+				err = setRemoteModRepository(tx, rel.Modid, repo)
+				if err != nil {
+					return err
 				}
-			}
 
-			return nil
+				return remoteVersionIdx.Add(tx, rel.Modid, rel.Version)
+			},
 		})
 		if err != nil {
 			return err
 		}
 
-		err = remoteVersionIdx.FinishBatch(tx)
+		_, err = pruneModIndexes(tx, bucket, remoteVersionIdx, remoteTypeIdx)
 		if err != nil {
 			return err
 		}
 
-		return remoteTypeIdx.FinishBatch(tx)
+		return updateRemoteSearchIndexes(tx)
 	})
 }
 
-func GetRemoteModsLastModifiedDates(ctx context.Context) (RemoteIndexLastModifiedDates, error) {
+// GetRemoteModsLastModifiedDates returns the modification dates of the modsync files we imported from repo
+func GetRemoteModsLastModifiedDates(ctx context.Context, repo string) (RemoteIndexLastModifiedDates, error) {
 	result := make(RemoteIndexLastModifiedDates)
 	err := view(ctx, func(tx *bolt.Tx) error {
-		encoded := tx.Bucket(remoteModsBucket).Get([]byte("#last_modifieds"))
+		encoded := tx.Bucket(remoteRepoStateBucket).Get([]byte(repo))
 		if encoded == nil {
 			return nil
 		}
 
 		err := json.Unmarshal(encoded, &result)
 		if err != nil {
-			return eris.Wrap(err, "failed to deserialise last modified dates for remote mods")
+			return eris.Wrapf(err, "failed to deserialise last modified dates for repository %s", repo)
 		}
 
 		return nil
@@ -186,16 +200,16 @@ func GetRemoteModsLastModifiedDates(ctx context.Context) (RemoteIndexLastModifie
 	return result, nil
 }
 
-func UpdateRemoteModsLastModifiedDates(ctx context.Context, dates RemoteIndexLastModifiedDates) error {
+func UpdateRemoteModsLastModifiedDates(ctx context.Context, repo string, dates RemoteIndexLastModifiedDates) error {
 	return update(ctx, func(tx *bolt.Tx) error {
 		encoded, err := json.Marshal(&dates)
 		if err != nil {
-			return eris.Wrap(err, "failed to serialise last modified dates for remote mods")
+			return eris.Wrapf(err, "failed to serialise last modified dates for repository %s", repo)
 		}
 
-		err = tx.Bucket(remoteModsBucket).Put([]byte("#last_modifieds"), encoded)
+		err = tx.Bucket(remoteRepoStateBucket).Put([]byte(repo), encoded)
 		if err != nil {
-			return eris.Wrap(err, "failed to save last modified dates for remote mods")
+			return eris.Wrapf(err, "failed to save last modified dates for repository %s", repo)
 		}
 
 		return nil
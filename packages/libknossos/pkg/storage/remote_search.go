@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+)
+
+var (
+	remoteTitleIdx     = NewStringListIndex("remote_mod_titles", nil)
+	remoteTagIdx       = NewStringListIndex("remote_mod_tags", nil)
+	remoteStabilityIdx = NewStringListIndex("remote_mod_stabilities", nil)
+	remoteReleasedIdx  = NewStringListIndex("remote_mod_released", nil)
+
+	// remoteSearchIndexes contains all indexes used by SearchRemoteMods. They map normalized title words, tags, the
+	// stability and the release day of the latest release to mod IDs.
+	remoteSearchIndexes = []*StringListIndex{remoteTitleIdx, remoteTagIdx, remoteStabilityIdx, remoteReleasedIdx}
+)
+
+// releasedDayFormat is used for the keys of remoteReleasedIdx. It sorts lexically which allows range lookups.
+const releasedDayFormat = "2006-01-02"
+
+// normalizeSearchTerms splits s into lower case words and drops everything that isn't a letter or digit
+func normalizeSearchTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func normalizeTag(tag string) string {
+	return strings.Join(normalizeSearchTerms(tag), " ")
+}
+
+// buildRemoteSearchIndexes reads the remote mod bucket and returns the contents the search indexes should have
+func buildRemoteSearchIndexes(bucket *bolt.Bucket) (map[*StringListIndex]map[string][]string, error) {
+	contents := make(map[*StringListIndex]map[string][]string)
+	for _, idx := range remoteSearchIndexes {
+		contents[idx] = make(map[string][]string)
+	}
+
+	versions, _, err := buildModIndexes(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	for modID, modVersions := range versions {
+		err = modVersionSorter(modID, modVersions)
+		if err != nil {
+			return nil, err
+		}
+
+		mod, rel, err := loadLatestRelease(bucket, modID, modVersions[len(modVersions)-1])
+		if err != nil {
+			return nil, err
+		}
+		if mod == nil {
+			// orphaned releases are removed during the next cleanup
+			continue
+		}
+
+		for _, word := range normalizeSearchTerms(mod.Title) {
+			contents[remoteTitleIdx][word] = append(contents[remoteTitleIdx][word], modID)
+		}
+
+		for _, tag := range mod.Tags {
+			tag = normalizeTag(tag)
+			if tag != "" {
+				contents[remoteTagIdx][tag] = append(contents[remoteTagIdx][tag], modID)
+			}
+		}
+
+		stability := rel.Stability.String()
+		contents[remoteStabilityIdx][stability] = append(contents[remoteStabilityIdx][stability], modID)
+
+		if rel.Released != nil {
+			day := rel.Released.AsTime().UTC().Format(releasedDayFormat)
+			contents[remoteReleasedIdx][day] = append(contents[remoteReleasedIdx][day], modID)
+		}
+	}
+
+	return contents, nil
+}
+
+// loadLatestRelease returns the mod and the passed release from bucket. The mod is nil if it doesn't exist.
+func loadLatestRelease(bucket *bolt.Bucket, modID, version string) (*common.ModMeta, *common.Release, error) {
+	encoded := bucket.Get([]byte(modID))
+	if encoded == nil {
+		return nil, nil, nil
+	}
+
+	mod := new(common.ModMeta)
+	err := proto.Unmarshal(encoded, mod)
+	if err != nil {
+		return nil, nil, eris.Wrapf(err, "failed to deserialise mod %s", modID)
+	}
+
+	encoded = bucket.Get([]byte(modID + "#" + version))
+	if encoded == nil {
+		return nil, nil, eris.Errorf("release %s %s not found", modID, version)
+	}
+
+	rel := new(common.Release)
+	err = proto.Unmarshal(encoded, rel)
+	if err != nil {
+		return nil, nil, eris.Wrapf(err, "failed to deserialise release %s %s", modID, version)
+	}
+
+	return mod, rel, nil
+}
+
+// updateRemoteSearchIndexes rebuilds the search indexes from the remote mod bucket
+func updateRemoteSearchIndexes(tx *bolt.Tx) error {
+	contents, err := buildRemoteSearchIndexes(tx.Bucket(remoteModsBucket))
+	if err != nil {
+		return err
+	}
+
+	for idx, expected := range contents {
+		err = idx.Replace(tx, expected)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkRemoteSearchIndexes compares the search indexes with the remote mod bucket and rebuilds them if repair is set
+// and they don't match
+func checkRemoteSearchIndexes(tx *bolt.Tx, repair bool) ([]string, error) {
+	contents, err := buildRemoteSearchIndexes(tx.Bucket(remoteModsBucket))
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]string, 0)
+	for _, idx := range remoteSearchIndexes {
+		idxProblems := compareIndex(tx, idx, contents[idx])
+		problems = append(problems, idxProblems...)
+
+		if repair && len(idxProblems) > 0 {
+			err = idx.Replace(tx, contents[idx])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// modIDSet is used to intersect the results of several index lookups. A nil set matches all mods.
+type modIDSet map[string]bool
+
+func (s modIDSet) intersect(ids []string) modIDSet {
+	result := make(modIDSet)
+	for _, id := range ids {
+		if s == nil || s[id] {
+			result[id] = true
+		}
+	}
+
+	return result
+}
+
+func (s modIDSet) union(ids []string) modIDSet {
+	if s == nil {
+		s = make(modIDSet)
+	}
+
+	for _, id := range ids {
+		s[id] = true
+	}
+	return s
+}
+
+func (s modIDSet) list() []string {
+	result := make([]string, 0, len(s))
+	for id := range s {
+		result = append(result, id)
+	}
+
+	return result
+}
+
+// matchRemoteMods returns the IDs of all remote mods matching the request's filters except for the install state.
+// Returns nil if the request doesn't filter anything.
+func matchRemoteMods(tx *bolt.Tx, req *client.SearchRemoteModsRequest) modIDSet {
+	var matches modIDSet
+
+	for _, word := range normalizeSearchTerms(req.Query) {
+		var wordMatches modIDSet
+		_ = remoteTitleIdx.ForEach(tx, func(key string, ids []string) error {
+			if strings.HasPrefix(key, word) {
+				wordMatches = wordMatches.union(ids)
+			}
+			return nil
+		})
+
+		matches = matches.intersect(wordMatches.list())
+	}
+
+	for _, tag := range req.Tags {
+		matches = matches.intersect(remoteTagIdx.Lookup(tx, normalizeTag(tag)))
+	}
+
+	if len(req.Types) > 0 {
+		var typeMatches modIDSet
+		for _, modType := range req.Types {
+			typeMatches = typeMatches.union(remoteTypeIdx.Lookup(tx, modType.String()))
+		}
+
+		matches = matches.intersect(typeMatches.list())
+	}
+
+	if len(req.Stabilities) > 0 {
+		var stabilityMatches modIDSet
+		for _, stability := range req.Stabilities {
+			stabilityMatches = stabilityMatches.union(remoteStabilityIdx.Lookup(tx, stability.String()))
+		}
+
+		matches = matches.intersect(stabilityMatches.list())
+	}
+
+	if req.ReleasedAfter != nil || req.ReleasedBefore != nil {
+		after := ""
+		if req.ReleasedAfter != nil {
+			after = req.ReleasedAfter.AsTime().UTC().Format(releasedDayFormat)
+		}
+
+		before := ""
+		if req.ReleasedBefore != nil {
+			before = req.ReleasedBefore.AsTime().UTC().Format(releasedDayFormat)
+		}
+
+		var dateMatches modIDSet
+		_ = remoteReleasedIdx.ForEach(tx, func(day string, ids []string) error {
+			if (after == "" || day >= after) && (before == "" || day <= before) {
+				dateMatches = dateMatches.union(ids)
+			}
+			return nil
+		})
+
+		matches = matches.intersect(dateMatches.list())
+	}
+
+	return matches
+}
+
+// isNewerVersion returns true if version is newer than installed. Unparsable versions are never newer.
+func isNewerVersion(version, installed string) bool {
+	remote, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+
+	local, err := semver.NewVersion(installed)
+	if err != nil {
+		return false
+	}
+
+	return remote.GreaterThan(local)
+}
+
+type remoteSearchResult struct {
+	item     *client.SimpleModList_Item
+	sortKey  string
+	released time.Time
+}
+
+func searchRemoteMods(tx *bolt.Tx, req *client.SearchRemoteModsRequest) (*client.SearchRemoteModsResponse, error) {
+	bucket := tx.Bucket(remoteModsBucket)
+	matches := matchRemoteMods(tx, req)
+
+	results := make([]remoteSearchResult, 0)
+	err := remoteVersionIdx.ForEach(tx, func(modID string, versions []string) error {
+		if len(versions) < 1 || (matches != nil && !matches[modID]) {
+			return nil
+		}
+
+		item := &client.SimpleModList_Item{
+			Modid:   modID,
+			Version: versions[len(versions)-1],
+		}
+
+		localVersions := localVersionIdx.Lookup(tx, modID)
+		if len(localVersions) > 0 {
+			item.InstalledVersion = localVersions[len(localVersions)-1]
+			item.UpdateAvailable = isNewerVersion(item.Version, item.InstalledVersion)
+		}
+
+		switch req.Installed {
+		case client.SearchRemoteModsRequest_INSTALLED:
+			if item.InstalledVersion == "" {
+				return nil
+			}
+		case client.SearchRemoteModsRequest_NOT_INSTALLED:
+			if item.InstalledVersion != "" {
+				return nil
+			}
+		case client.SearchRemoteModsRequest_UPDATE_AVAILABLE:
+			if !item.UpdateAvailable {
+				return nil
+			}
+		}
+
+		mod, rel, err := loadLatestRelease(bucket, modID, item.Version)
+		if err != nil {
+			return err
+		}
+		if mod == nil {
+			return nil
+		}
+
+		item.Type = mod.Type
+		item.Title = mod.Title
+		item.Teaser = rel.Teaser
+
+		result := remoteSearchResult{
+			item:    item,
+			sortKey: strings.ToLower(mod.Title),
+		}
+		if rel.Released != nil {
+			result.released = rel.Released.AsTime()
+		}
+
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if req.Descending {
+			a, b = b, a
+		}
+
+		if req.Sort == client.SearchRemoteModsRequest_RELEASED && !a.released.Equal(b.released) {
+			return a.released.Before(b.released)
+		}
+
+		if a.sortKey != b.sortKey {
+			return a.sortKey < b.sortKey
+		}
+		return a.item.Modid < b.item.Modid
+	})
+
+	response := &client.SearchRemoteModsResponse{
+		Mods:  make([]*client.SimpleModList_Item, 0),
+		Total: uint32(len(results)),
+	}
+
+	start := int(req.Offset)
+	if start > len(results) {
+		start = len(results)
+	}
+
+	end := len(results)
+	if req.Limit > 0 && start+int(req.Limit) < end {
+		end = start + int(req.Limit)
+	}
+
+	for _, result := range results[start:end] {
+		response.Mods = append(response.Mods, result.item)
+	}
+
+	return response, nil
+}
+
+// SearchRemoteMods returns the latest release of every remote mod matching the passed filters
+func SearchRemoteMods(ctx context.Context, req *client.SearchRemoteModsRequest) (*client.SearchRemoteModsResponse, error) {
+	var response *client.SearchRemoteModsResponse
+	err := view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		response, err = searchRemoteMods(tx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+)
+
+// Not parallel since it uses the global remote and local indexes which the migration tests modify as well.
+func TestSearchRemoteMods(t *testing.T) {
+	released := func(day string) *timestamppb.Timestamp {
+		ts, err := time.Parse(releasedDayFormat, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return timestamppb.New(ts)
+	}
+
+	items := map[string]proto.Message{
+		"mva": &common.ModMeta{Modid: "mva", Title: "MediaVPs", Type: common.ModType_MOD, Tags: []string{"Graphics"}},
+		"mva#4.0.0": &common.Release{
+			Modid: "mva", Version: "4.0.0", Released: released("2020-01-10"),
+		},
+		"mva#4.1.0": &common.Release{
+			Modid: "mva", Version: "4.1.0", Released: released("2021-05-01"),
+		},
+		"bp": &common.ModMeta{Modid: "bp", Title: "Blue Planet: War in Heaven", Type: common.ModType_MOD},
+		"bp#1.0.0": &common.Release{
+			Modid: "bp", Version: "1.0.0", Released: released("2019-03-02"),
+		},
+		"fso": &common.ModMeta{Modid: "fso", Title: "FSO", Type: common.ModType_ENGINE},
+		"fso#23.0.0": &common.Release{
+			Modid: "fso", Version: "23.0.0", Stability: common.ReleaseStability_NIGHTLY,
+		},
+	}
+
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{indexBucket, remoteModsBucket, localModsBucket} {
+			_, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		bucket := tx.Bucket(remoteModsBucket)
+		for key, item := range items {
+			encoded, err := proto.Marshal(item)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(key), encoded)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := checkModIndexes(tx, RemoteMods, true)
+		if err != nil {
+			return err
+		}
+
+		err = localVersionIdx.Clear(tx)
+		if err != nil {
+			return err
+		}
+
+		// mva has an update, bp is up to date
+		err = localVersionIdx.Add(tx, "mva", "4.0.0")
+		if err != nil {
+			return err
+		}
+
+		err = localVersionIdx.Add(tx, "bp", "1.0.0")
+		if err != nil {
+			return err
+		}
+
+		return updateRemoteSearchIndexes(tx)
+	})
+
+	tests := []struct {
+		name     string
+		req      *client.SearchRemoteModsRequest
+		expected []string
+		total    uint32
+	}{
+		{
+			name:     "everything sorted by title",
+			req:      &client.SearchRemoteModsRequest{},
+			expected: []string{"bp", "fso", "mva"},
+		},
+		{
+			name:     "title prefix",
+			req:      &client.SearchRemoteModsRequest{Query: "blue HEAV"},
+			expected: []string{"bp"},
+		},
+		{
+			name:     "no title match",
+			req:      &client.SearchRemoteModsRequest{Query: "heaven sent"},
+			expected: []string{},
+		},
+		{
+			name:     "tag",
+			req:      &client.SearchRemoteModsRequest{Tags: []string{"graphics"}},
+			expected: []string{"mva"},
+		},
+		{
+			name:     "type",
+			req:      &client.SearchRemoteModsRequest{Types: []common.ModType{common.ModType_ENGINE}},
+			expected: []string{"fso"},
+		},
+		{
+			name: "stability",
+			req: &client.SearchRemoteModsRequest{
+				Stabilities: []common.ReleaseStability{common.ReleaseStability_STABLE},
+			},
+			expected: []string{"bp", "mva"},
+		},
+		{
+			name:     "released after uses the latest release",
+			req:      &client.SearchRemoteModsRequest{ReleasedAfter: released("2021-01-01")},
+			expected: []string{"mva"},
+		},
+		{
+			name: "sorted by release date",
+			req: &client.SearchRemoteModsRequest{
+				Sort:       client.SearchRemoteModsRequest_RELEASED,
+				Descending: true,
+			},
+			expected: []string{"mva", "bp", "fso"},
+		},
+		{
+			name:     "not installed",
+			req:      &client.SearchRemoteModsRequest{Installed: client.SearchRemoteModsRequest_NOT_INSTALLED},
+			expected: []string{"fso"},
+		},
+		{
+			name:     "update available",
+			req:      &client.SearchRemoteModsRequest{Installed: client.SearchRemoteModsRequest_UPDATE_AVAILABLE},
+			expected: []string{"mva"},
+		},
+		{
+			name:     "pagination",
+			req:      &client.SearchRemoteModsRequest{Offset: 1, Limit: 1},
+			expected: []string{"fso"},
+			total:    3,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := testDB.View(func(tx *bolt.Tx) error {
+				response, err := searchRemoteMods(tx, test.req)
+				if err != nil {
+					return err
+				}
+
+				ids := make([]string, 0)
+				for _, item := range response.Mods {
+					ids = append(ids, item.Modid)
+				}
+
+				if !reflect.DeepEqual(ids, test.expected) {
+					t.Errorf("expected %v but got %v", test.expected, ids)
+				}
+
+				total := test.total
+				if total == 0 {
+					total = uint32(len(test.expected))
+				}
+				if response.Total != total {
+					t.Errorf("expected %d total results but got %d", total, response.Total)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
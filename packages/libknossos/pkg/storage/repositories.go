@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/rotisserie/eris"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+)
+
+var (
+	// remoteModReposBucket maps remote mod IDs to the name of the repository they were synced from
+	remoteModReposBucket = []byte("remote_mod_repos")
+	// remoteRepoStateBucket contains the last modified dates of the modsync files imported from each repository
+	remoteRepoStateBucket = []byte("remote_repo_state")
+)
+
+// DefaultRepositoryName is the name of the repository which is used if the settings don't list any
+const DefaultRepositoryName = "Nebula"
+
+// DefaultRepository returns the repository which is used if the settings don't list any
+func DefaultRepository() *client.ModRepository {
+	return &client.ModRepository{
+		Name:    DefaultRepositoryName,
+		Url:     api.SyncEndpoint,
+		Enabled: true,
+	}
+}
+
+// ValidateRepositories makes sure that every repository has a unique name and a valid URL
+func ValidateRepositories(repos []*client.ModRepository) error {
+	seen := make(map[string]bool)
+	for _, repo := range repos {
+		if repo.Name == "" {
+			return eris.New("repository names must not be empty")
+		}
+
+		if seen[repo.Name] {
+			return eris.Errorf("the repository name %s is used more than once", repo.Name)
+		}
+		seen[repo.Name] = true
+
+		parsed, err := url.Parse(repo.Url)
+		if err != nil {
+			return eris.Wrapf(err, "failed to parse the URL of repository %s", repo.Name)
+		}
+
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return eris.Errorf("the URL of repository %s has to start with http:// or https://", repo.Name)
+		}
+	}
+
+	return nil
+}
+
+// GetEnabledRepositories returns the enabled repositories sorted by priority (highest first). Repositories with the
+// same priority keep the order from the settings.
+func GetEnabledRepositories(ctx context.Context) ([]*client.ModRepository, error) {
+	settings, err := GetSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(settings.Repositories) == 0 {
+		return []*client.ModRepository{DefaultRepository()}, nil
+	}
+
+	result := make([]*client.ModRepository, 0, len(settings.Repositories))
+	for _, repo := range settings.Repositories {
+		if repo.Enabled {
+			repo.Url = strings.TrimSuffix(repo.Url, "/")
+			result = append(result, repo)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Priority > result[j].Priority
+	})
+	return result, nil
+}
+
+// GetRemoteModRepository returns the name of the repository which provided the passed remote mod or an empty string
+// if the mod is unknown
+func GetRemoteModRepository(ctx context.Context, modID string) (string, error) {
+	var repo string
+	err := view(ctx, func(tx *bolt.Tx) error {
+		repo = string(tx.Bucket(remoteModReposBucket).Get([]byte(modID)))
+		return nil
+	})
+	return repo, err
+}
+
+func pruneRemoteRepositories(tx *bolt.Tx, keep []string) (int, error) {
+	kept := make(map[string]bool)
+	for _, name := range keep {
+		kept[name] = true
+	}
+
+	stale := make([]string, 0)
+	err := tx.Bucket(remoteModReposBucket).ForEach(func(k, v []byte) error {
+		if !kept[string(v)] {
+			stale = append(stale, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	bucket := tx.Bucket(remoteModsBucket)
+	for _, modID := range stale {
+		err = removeRemoteMod(tx, bucket, modID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	staleStates := make([][]byte, 0)
+	stateBucket := tx.Bucket(remoteRepoStateBucket)
+	err = stateBucket.ForEach(func(k, v []byte) error {
+		if !kept[string(k)] {
+			staleStates = append(staleStates, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = deleteKeys(stateBucket, staleStates)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	_, err = pruneModIndexes(tx, bucket, remoteVersionIdx, remoteTypeIdx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(stale), updateRemoteSearchIndexes(tx)
+}
+
+// PruneRemoteRepositories removes all remote mods which weren't provided by one of the passed repositories and
+// forgets the sync state of all other repositories. Returns the number of removed mods.
+func PruneRemoteRepositories(ctx context.Context, keep []string) (int, error) {
+	removed := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		removed, err = pruneRemoteRepositories(tx, keep)
+		return err
+	})
+	return removed, err
+}
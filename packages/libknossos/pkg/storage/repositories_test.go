@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/api/common"
+)
+
+func TestValidateRepositories(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		repos []*client.ModRepository
+		valid bool
+	}{
+		{
+			name:  "empty",
+			valid: true,
+		},
+		{
+			name: "valid",
+			repos: []*client.ModRepository{
+				{Name: "Nebula", Url: "https://nu.fsnebula.org/sync"},
+				{Name: "Staging", Url: "http://localhost:8200/sync"},
+			},
+			valid: true,
+		},
+		{
+			name:  "missing name",
+			repos: []*client.ModRepository{{Url: "https://nu.fsnebula.org/sync"}},
+		},
+		{
+			name: "duplicate name",
+			repos: []*client.ModRepository{
+				{Name: "Nebula", Url: "https://nu.fsnebula.org/sync"},
+				{Name: "Nebula", Url: "https://example.com/sync"},
+			},
+		},
+		{
+			name:  "unsupported scheme",
+			repos: []*client.ModRepository{{Name: "Local", Url: "file:///tmp/sync"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateRepositories(tt.repos)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateRepositories() = %v, expected valid = %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+// Not parallel since it uses the global remote indexes which the other tests modify as well.
+func TestPruneRemoteRepositories(t *testing.T) {
+	items := map[string]proto.Message{
+		"mva":       &common.ModMeta{Modid: "mva", Title: "MediaVPs", Type: common.ModType_MOD},
+		"mva#4.1.0": &common.Release{Modid: "mva", Version: "4.1.0"},
+		"bp":        &common.ModMeta{Modid: "bp", Title: "Blue Planet", Type: common.ModType_MOD},
+		"bp#1.0.0":  &common.Release{Modid: "bp", Version: "1.0.0"},
+	}
+
+	testDB, _ := openTestDB(t, func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{indexBucket, remoteModsBucket} {
+			_, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		bucket := tx.Bucket(remoteModsBucket)
+		for key, item := range items {
+			encoded, err := proto.Marshal(item)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(key), encoded)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := bucket.Put([]byte("#last_modifieds"), []byte("{}"))
+		if err != nil {
+			return err
+		}
+
+		_, err = checkModIndexes(tx, RemoteMods, true)
+		return err
+	})
+
+	err := testDB.Update(func(tx *bolt.Tx) error {
+		err := migrateRemoteRepositories(testContext(), tx)
+		if err != nil {
+			return err
+		}
+
+		if tx.Bucket(remoteModsBucket).Get([]byte("#last_modifieds")) != nil {
+			t.Error("old last modified dates weren't removed")
+		}
+
+		if tx.Bucket(remoteRepoStateBucket).Get([]byte(DefaultRepositoryName)) == nil {
+			t.Error("last modified dates weren't moved to the default repository")
+		}
+
+		for _, modID := range []string{"mva", "bp"} {
+			repo := string(tx.Bucket(remoteModReposBucket).Get([]byte(modID)))
+			if repo != DefaultRepositoryName {
+				t.Errorf("%s was assigned to %q instead of the default repository", modID, repo)
+			}
+		}
+
+		// Pretend that bp came from a second repository which has been disabled since
+		err = setRemoteModRepository(tx, "bp", "Community")
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(remoteRepoStateBucket).Put([]byte("Community"), []byte("{}"))
+		if err != nil {
+			return err
+		}
+
+		removed, err := pruneRemoteRepositories(tx, []string{DefaultRepositoryName})
+		if err != nil {
+			return err
+		}
+
+		if removed != 1 {
+			t.Errorf("pruneRemoteRepositories() removed %d mods, expected 1", removed)
+		}
+
+		bucket := tx.Bucket(remoteModsBucket)
+		for _, key := range []string{"bp", "bp#1.0.0"} {
+			if bucket.Get([]byte(key)) != nil {
+				t.Errorf("%s wasn't removed", key)
+			}
+		}
+
+		for _, key := range []string{"mva", "mva#4.1.0"} {
+			if bucket.Get([]byte(key)) == nil {
+				t.Errorf("%s was removed", key)
+			}
+		}
+
+		if len(remoteVersionIdx.Lookup(tx, "bp")) != 0 {
+			t.Error("bp is still in the version index")
+		}
+
+		if tx.Bucket(remoteRepoStateBucket).Get([]byte("Community")) != nil {
+			t.Error("the state of the disabled repository wasn't removed")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
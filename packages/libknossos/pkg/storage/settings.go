@@ -31,6 +31,11 @@ func GetSettings(ctx context.Context) (*client.Settings, error) {
 }
 
 func SaveSettings(ctx context.Context, settings *client.Settings) error {
+	err := ValidateRepositories(settings.Repositories)
+	if err != nil {
+		return err
+	}
+
 	encoded, err := json.Marshal(settings)
 	if err != nil {
 		return eris.Wrap(err, "failed to serialise settings")
@@ -15,6 +15,14 @@ type txCtxKey struct{}
 
 var db *bolt.DB
 
+// knownBuckets lists all buckets used by Knossos. Clean() deletes every other bucket.
+var knownBuckets = [][]byte{
+	localModsBucket, remoteModsBucket, indexBucket, fileBucket, settingsBucket, userModSettingsBucket,
+	engineFlagsBucket, httpCacheBucket, launchHistoryBucket, fsoProfilesBucket, customEnginesBucket,
+	metaBucket, imageCacheBucket, remoteModReposBucket, remoteRepoStateBucket,
+	checksumPackBucket, playtimeBucket,
+}
+
 func Open(ctx context.Context) error {
 	var err error
 
@@ -26,12 +34,14 @@ func Open(ctx context.Context) error {
 		return eris.Wrap(err, "failed to open state DB")
 	}
 
-	buckets := [][]byte{
-		localModsBucket, remoteModsBucket, indexBucket, fileBucket, settingsBucket, userModSettingsBucket,
-		engineFlagsBucket, httpCacheBucket,
+	err = migrateDB(ctx, newDB, dbPath)
+	if err != nil {
+		newDB.Close()
+		return err
 	}
+
 	err = newDB.Update(func(tx *bolt.Tx) error {
-		for _, bucket := range buckets {
+		for _, bucket := range knownBuckets {
 			_, err := tx.CreateBucketIfNotExists(bucket)
 			if err != nil {
 				return eris.Wrapf(err, "failed to create bucket %s", bucket)
@@ -46,31 +56,38 @@ func Open(ctx context.Context) error {
 
 	db = newDB
 
-	return db.View(func(tx *bolt.Tx) error {
-		err := localVersionIdx.Open(tx)
-		if err != nil {
-			return err
-		}
+	return db.View(openIndexes)
+}
 
-		err = localTypeIdx.Open(tx)
-		if err != nil {
-			return err
-		}
+func openIndexes(tx *bolt.Tx) error {
+	err := localVersionIdx.Open(tx)
+	if err != nil {
+		return err
+	}
 
-		err = remoteVersionIdx.Open(tx)
+	err = localTypeIdx.Open(tx)
+	if err != nil {
+		return err
+	}
+
+	err = remoteVersionIdx.Open(tx)
+	if err != nil {
+		return err
+	}
+
+	err = remoteTypeIdx.Open(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range remoteSearchIndexes {
+		err = idx.Open(tx)
 		if err != nil {
 			return err
 		}
+	}
 
-		return remoteTypeIdx.Open(tx)
-	})
-}
-
-func Clean(ctx context.Context) error {
-	// TODO This should delete all unknown keys and if possible unreferenced entries from the DB
-	return db.Update(func(tx *bolt.Tx) error {
-		return cleanEngineFlags(ctx, tx)
-	})
+	return nil
 }
 
 func Close(ctx context.Context) {
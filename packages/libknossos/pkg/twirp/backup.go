@@ -0,0 +1,35 @@
+package twirp
+
+import (
+	"context"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/mods"
+)
+
+func (kn *knossosServer) ExportLibrary(ctx context.Context, req *client.ExportLibraryRequest) (*client.SuccessResponse, error) {
+	err := mods.ExportLibrary(ctx, req.Path, req.IncludeManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) ImportLibrary(ctx context.Context, req *client.ImportLibraryRequest) (*client.SuccessResponse, error) {
+	// Check the backup before starting the task so that obviously broken files are reported right away
+	_, _, _, err := mods.ReadLibraryBackup(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	api.RunTask(ctx, req.Ref, func(ctx context.Context) error {
+		err := mods.ImportLibrary(ctx, req.Path, req.LibraryPath, req.InstallMissing)
+		api.Log(ctx, api.LogInfo, "Done")
+
+		return err
+	})
+
+	return &client.SuccessResponse{Success: true}, nil
+}
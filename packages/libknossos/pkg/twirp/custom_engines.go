@@ -0,0 +1,41 @@
+package twirp
+
+import (
+	"context"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/mods"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func (kn *knossosServer) RegisterCustomEngine(ctx context.Context, req *client.RegisterCustomEngineRequest) (*client.CustomEngine, error) {
+	if req.Path == "" {
+		return nil, eris.New("no build folder specified")
+	}
+
+	return mods.RegisterCustomEngine(ctx, req.Path, req.Title)
+}
+
+func (kn *knossosServer) ListCustomEngines(ctx context.Context, _ *client.NullMessage) (*client.CustomEngineList, error) {
+	engines, err := storage.GetCustomEngines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.CustomEngineList{Engines: engines}, nil
+}
+
+func (kn *knossosServer) RefreshCustomEngine(ctx context.Context, req *client.CustomEngineRequest) (*client.CustomEngine, error) {
+	return mods.RefreshCustomEngine(ctx, req.Modid)
+}
+
+func (kn *knossosServer) RemoveCustomEngine(ctx context.Context, req *client.CustomEngineRequest) (*client.SuccessResponse, error) {
+	err := mods.RemoveCustomEngine(ctx, req.Modid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
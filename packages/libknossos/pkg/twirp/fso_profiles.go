@@ -0,0 +1,107 @@
+package twirp
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func (kn *knossosServer) ListFSOSettingsProfiles(ctx context.Context, req *client.NullMessage) (*client.FSOSettingsProfileList, error) {
+	profiles, err := storage.GetFSOSettingsProfiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.FSOSettingsProfileList{Profiles: profiles}, nil
+}
+
+func (kn *knossosServer) CreateFSOSettingsProfile(ctx context.Context, req *client.FSOSettingsProfile) (*client.SuccessResponse, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, eris.New("the profile name must not be empty")
+	}
+	if req.Settings == nil {
+		return nil, eris.New("the profile must contain settings")
+	}
+
+	existing, err := storage.GetFSOSettingsProfile(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, eris.Errorf("a profile named %s already exists", req.Name)
+	}
+
+	err = storage.SaveFSOSettingsProfile(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) EditFSOSettingsProfile(ctx context.Context, req *client.FSOSettingsProfile) (*client.SuccessResponse, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Settings == nil {
+		return nil, eris.New("the profile must contain settings")
+	}
+
+	existing, err := storage.GetFSOSettingsProfile(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, eris.Errorf("profile %s not found", req.Name)
+	}
+
+	err = storage.SaveFSOSettingsProfile(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) DeleteFSOSettingsProfile(ctx context.Context, req *client.FSOSettingsProfileRequest) (*client.SuccessResponse, error) {
+	err := storage.DeleteFSOSettingsProfile(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) AssignFSOSettingsProfile(ctx context.Context, req *client.AssignFSOSettingsProfileRequest) (*client.SuccessResponse, error) {
+	if req.Profile != "" {
+		profile, err := storage.GetFSOSettingsProfile(ctx, req.Profile)
+		if err != nil {
+			return nil, err
+		}
+		if profile == nil {
+			return nil, eris.Errorf("profile %s not found", req.Profile)
+		}
+	}
+
+	// Make sure the release exists
+	_, err := storage.LocalMods.GetModRelease(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	userSettings, err := storage.GetUserSettingsForMod(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	userSettings.FsoSettingsProfile = req.Profile
+	err = storage.SaveUserSettingsForMod(ctx, req.Modid, req.Version, userSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
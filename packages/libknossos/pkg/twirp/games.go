@@ -0,0 +1,58 @@
+package twirp
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/mods"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func (kn *knossosServer) ListRunningGames(ctx context.Context, req *client.NullMessage) (*client.RunningGamesResponse, error) {
+	return &client.RunningGamesResponse{Games: mods.GetRunningGames()}, nil
+}
+
+func (kn *knossosServer) KillGame(ctx context.Context, req *client.KillGameRequest) (*client.SuccessResponse, error) {
+	err := mods.KillGame(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) GetCrashDiagnosis(ctx context.Context, req *client.CrashDiagnosisRequest) (*client.CrashDiagnosis, error) {
+	return mods.GetCrashDiagnosis(ctx, req.Reanalyze)
+}
+
+func (kn *knossosServer) GetLaunchHistory(ctx context.Context, req *client.LaunchHistoryRequest) (*client.LaunchHistoryResponse, error) {
+	entries, err := storage.GetLaunchHistory(ctx, req.Modid)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Limit > 0 && len(entries) > int(req.Limit) {
+		entries = entries[:req.Limit]
+	}
+
+	return &client.LaunchHistoryResponse{Entries: entries}, nil
+}
+
+func (kn *knossosServer) GetPlaytime(ctx context.Context, req *client.NullMessage) (*client.PlaytimeResponse, error) {
+	playtimes, err := storage.GetPlaytimes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*client.PlaytimeResponse_ModPlaytime, 0, len(playtimes))
+	for _, info := range playtimes {
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastPlayed.AsTime().After(result[j].LastPlayed.AsTime())
+	})
+
+	return &client.PlaytimeResponse{Mods: result}, nil
+}
@@ -0,0 +1,20 @@
+package twirp
+
+import (
+	"context"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func (kn *knossosServer) CleanStorage(ctx context.Context, _ *client.NullMessage) (*client.CleanupReport, error) {
+	return storage.Clean(ctx)
+}
+
+func (kn *knossosServer) GetHTTPCacheStats(ctx context.Context, _ *client.NullMessage) (*client.HTTPCacheStats, error) {
+	return storage.GetHTTPCacheStats(ctx)
+}
+
+func (kn *knossosServer) CheckModIndexes(ctx context.Context, req *client.CheckModIndexesRequest) (*client.IndexCheckReport, error) {
+	return storage.CheckModIndexes(ctx, req.Repair)
+}
@@ -0,0 +1,52 @@
+package twirp
+
+import (
+	"context"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/ngld/knossos/packages/api/client"
+	"github.com/ngld/knossos/packages/libknossos/pkg/api"
+	"github.com/ngld/knossos/packages/libknossos/pkg/mods"
+	"github.com/ngld/knossos/packages/libknossos/pkg/storage"
+)
+
+func (kn *knossosServer) ExportLockfile(ctx context.Context, req *client.ExportLockfileRequest) (*client.SuccessResponse, error) {
+	rel, err := storage.LocalMods.GetModRelease(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	userSettings, err := storage.GetUserSettingsForMod(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := mods.BuildLockfile(ctx, rel, userSettings)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to build lockfile for %s %s", req.Modid, req.Version)
+	}
+
+	err = mods.WriteLockfile(lock, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) ImportLockfile(ctx context.Context, req *client.ImportLockfileRequest) (*client.SuccessResponse, error) {
+	lock, err := mods.ReadLockfile(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	api.RunTask(ctx, req.Ref, func(ctx context.Context) error {
+		err := mods.ImportLockfile(ctx, lock)
+		api.Log(ctx, api.LogInfo, "Done")
+
+		return err
+	})
+
+	return &client.SuccessResponse{Success: true}, nil
+}
@@ -2,7 +2,6 @@ package twirp
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -108,104 +107,29 @@ func buildModList(ctx context.Context, modProvider storage.ModProvider) (*client
 }
 
 func (kn *knossosServer) UpdateLocalModList(ctx context.Context, req *client.TaskRequest) (*client.SuccessResponse, error) {
-	api.RunTask(ctx, req.Ref, func(ctx context.Context) error {
-		fail := false
-		api.Log(ctx, api.LogInfo, "Looking for knmod.json files")
-
-		settings, err := storage.GetSettings(ctx)
-		if err != nil {
-			return eris.Wrap(err, "failed to load settings")
-		}
-
-		parentFolders, err := os.ReadDir(settings.LibraryPath)
-		if err != nil {
-			return eris.Wrapf(err, "failed to read directory %s", settings.LibraryPath)
-		}
-
-		modInfos := []*common.ModMeta{}
-		releaseInfos := []*common.Release{}
-		for _, parent := range parentFolders {
-			if !parent.IsDir() {
-				continue
-			}
+	api.RunTask(ctx, req.Ref, mods.ReloadLocalMods)
 
-			subDir := filepath.Join(settings.LibraryPath, parent.Name())
-			items, err := os.ReadDir(subDir)
-			if err != nil {
-				return eris.Wrapf(err, "failed to list contents of %s", subDir)
-			}
-
-			for _, item := range items {
-				if item.IsDir() {
-					releasePath := filepath.Join(subDir, item.Name(), "knrelease.json")
-					encodedData, err := os.ReadFile(releasePath)
-					if err != nil {
-						if eris.Is(err, os.ErrNotExist) {
-							// Ignore file not found errors
-							continue
-						}
-
-						return eris.Wrapf(err, "failed to read %s", releasePath)
-					}
-
-					var relaseInfo common.Release
-					err = json.Unmarshal(encodedData, &relaseInfo)
-					if err != nil {
-						return eris.Wrapf(err, "failed to parse %s", releasePath)
-					}
-
-					releaseInfos = append(releaseInfos, &relaseInfo)
-				} else if strings.HasPrefix(item.Name(), "knmod-") && strings.HasSuffix(item.Name(), ".json") {
-					modPath := filepath.Join(subDir, item.Name())
-					encodedData, err := os.ReadFile(modPath)
-					if err != nil {
-						return eris.Wrapf(err, "failed to read %s", modPath)
-					}
-
-					var modInfo common.ModMeta
-					err = json.Unmarshal(encodedData, &modInfo)
-					if err != nil {
-						return eris.Wrapf(err, "failed to parse %s", modPath)
-					}
-
-					modInfos = append(modInfos, &modInfo)
-				}
-			}
-		}
-
-		err = storage.ImportMods(ctx, func(ctx context.Context) error {
-			for _, modInfo := range modInfos {
-				err := storage.SaveLocalMod(ctx, modInfo)
-				if err != nil {
-					return err
-				}
-			}
+	return &client.SuccessResponse{Success: true}, nil
+}
 
-			for _, releaseInfo := range releaseInfos {
-				err := storage.SaveLocalModRelease(ctx, releaseInfo)
-				if err != nil {
-					return err
-				}
-			}
+func (kn *knossosServer) GetLocalMods(ctx context.Context, _ *client.NullMessage) (*client.SimpleModList, error) {
+	list, err := buildModList(ctx, storage.LocalMods)
+	if err != nil {
+		return nil, err
+	}
 
-			return nil
-		})
-		if err != nil {
-			return eris.Wrap(err, "failed to import mod metadata")
-		}
+	playtimes, err := storage.GetPlaytimes(ctx)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to load launch history")
+	}
 
-		if fail {
-			return eris.New("some files or folders could not be handled correctly; check the previous messages for details")
+	for _, item := range list.Mods {
+		if info, ok := playtimes[item.Modid]; ok {
+			item.LastPlayed = info.LastPlayed
 		}
+	}
 
-		return nil
-	})
-
-	return &client.SuccessResponse{Success: true}, nil
-}
-
-func (kn *knossosServer) GetLocalMods(ctx context.Context, _ *client.NullMessage) (*client.SimpleModList, error) {
-	return buildModList(ctx, storage.LocalMods)
+	return list, nil
 }
 
 func (kn *knossosServer) GetModInfo(ctx context.Context, req *client.ModInfoRequest) (*client.ModInfoResponse, error) {
@@ -229,30 +153,15 @@ func (kn *knossosServer) GetModInfo(ctx context.Context, req *client.ModInfoRequ
 
 	tools := make([]*client.ToolInfo, 0)
 	if mod.Type != common.ModType_ENGINE {
-		engine, err := mods.GetEngineForMod(ctx, release)
-		if err == nil {
-			for _, pkg := range mods.FilterUnsupportedPackages(ctx, engine.Packages) {
-				for _, exe := range pkg.Executables {
-					found := false
-					for _, t := range tools {
-						if t.Label == exe.Label {
-							found = true
-							break
-						}
-					}
-
-					if !found {
-						tools = append(tools, &client.ToolInfo{
-							Label: exe.Label,
-							Id:    engine.Modid,
-							Debug: exe.Debug,
-							Fred:  strings.Contains(exe.Label, "FRED"),
-						})
-					}
-				}
-			}
-		} else {
+		userSettings, err := storage.GetUserSettingsForMod(ctx, req.Id, req.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		tools, err = mods.GetToolsForMod(ctx, release, userSettings)
+		if err != nil {
 			api.Log(ctx, api.LogWarn, "Could not resolve engine for mod %s (%s): %s", mod.Title, release.Version, eris.ToString(err, true))
+			tools = make([]*client.ToolInfo, 0)
 		}
 	}
 
@@ -288,6 +197,58 @@ func (kn *knossosServer) GetModDependencies(ctx context.Context, req *client.Mod
 	}, nil
 }
 
+func (kn *knossosServer) GetDependencyGraph(ctx context.Context, req *client.DependencyGraphRequest) (*client.DependencyGraphResponse, error) {
+	var (
+		modProvider storage.ModProvider
+		snapshot    mods.DependencySnapshot
+		engOpts     *client.UserSettings_EngineOptions
+	)
+
+	if req.Remote {
+		modProvider = storage.RemoteMods
+	} else {
+		modProvider = storage.LocalMods
+	}
+
+	rel, err := modProvider.GetModRelease(ctx, req.Id, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Remote {
+		// Remote mods don't have a dependency snapshot so we have to resolve one
+		snapshot, err = mods.GetDependencySnapshot(ctx, storage.RemoteMods, rel)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		snapshot = rel.DependencySnapshot
+
+		userSettings, err := storage.GetUserSettingsForMod(ctx, req.Id, req.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		engOpts = userSettings.GetEngineOptions()
+	}
+
+	graph, err := mods.BuildDependencyGraph(ctx, modProvider, rel, snapshot, engOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := mods.FormatDependencyGraphJSON(graph)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.DependencyGraphResponse{
+		Graph: graph,
+		Json:  encoded,
+		Dot:   mods.FormatDependencyGraphDOT(graph),
+	}, nil
+}
+
 func (kn *knossosServer) GetModFlags(ctx context.Context, req *client.ModInfoRequest) (*client.FlagInfo, error) {
 	mod, err := storage.LocalMods.GetModRelease(ctx, req.Id, req.Version)
 	if err != nil {
@@ -341,6 +302,31 @@ func (kn *knossosServer) SaveModFlags(ctx context.Context, req *client.SaveFlags
 	return &client.SuccessResponse{Success: true}, nil
 }
 
+func (kn *knossosServer) GetUserSettings(ctx context.Context, req *client.ModInfoRequest) (*client.UserSettings, error) {
+	return storage.GetUserSettingsForMod(ctx, req.Id, req.Version)
+}
+
+func (kn *knossosServer) SaveUserSettings(ctx context.Context, req *client.SaveUserSettingsRequest) (*client.SuccessResponse, error) {
+	err := mods.ValidateUserSettings(req.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := storage.GetUserSettingsForMod(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// The launch date is managed by LaunchMod
+	req.Settings.LastPlayed = current.LastPlayed
+	err = storage.SaveUserSettingsForMod(ctx, req.Modid, req.Version, req.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
 func (kn *knossosServer) ResetModFlags(ctx context.Context, req *client.ModInfoRequest) (*client.FlagInfo, error) {
 	mod, err := storage.LocalMods.GetModRelease(ctx, req.Id, req.Version)
 	if err != nil {
@@ -372,6 +358,20 @@ func (kn *knossosServer) ResetModFlags(ctx context.Context, req *client.ModInfoR
 	}, nil
 }
 
+func (kn *knossosServer) ValidateModFlags(ctx context.Context, req *client.ValidateModFlagsRequest) (*client.FlagValidation, error) {
+	mod, err := storage.LocalMods.GetModRelease(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	userSettings, err := storage.GetUserSettingsForMod(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return mods.ValidateModFlags(ctx, mod, userSettings, req.Engine)
+}
+
 func (kn *knossosServer) LaunchMod(ctx context.Context, req *client.LaunchModRequest) (*client.SuccessResponse, error) {
 	mod, err := storage.LocalMods.GetModRelease(ctx, req.Modid, req.Version)
 	if err != nil {
@@ -391,7 +391,45 @@ func (kn *knossosServer) LaunchMod(ctx context.Context, req *client.LaunchModReq
 	return &client.SuccessResponse{Success: true}, nil
 }
 
-func (kn *knossosServer) DepSnapshotChange(ctx context.Context, req *client.DepSnapshotChangeRequest) (*client.SuccessResponse, error) {
+func (kn *knossosServer) GetModTools(ctx context.Context, req *client.ModInfoRequest) (*client.ToolList, error) {
+	mod, err := storage.LocalMods.GetModRelease(ctx, req.Id, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	userSettings, err := storage.GetUserSettingsForMod(ctx, req.Id, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	tools, err := mods.GetToolsForMod(ctx, mod, userSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.ToolList{Tools: tools}, nil
+}
+
+func (kn *knossosServer) LaunchTool(ctx context.Context, req *client.LaunchToolRequest) (*client.SuccessResponse, error) {
+	mod, err := storage.LocalMods.GetModRelease(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	userSettings, err := storage.GetUserSettingsForMod(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mods.LaunchTool(ctx, mod, userSettings, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) DepSnapshotChange(ctx context.Context, req *client.DepSnapshotChangeRequest) (*client.DepSnapshotChangeResponse, error) {
 	rel, err := storage.LocalMods.GetModRelease(ctx, req.Modid, req.Version)
 	if err != nil {
 		return nil, err
@@ -402,22 +440,49 @@ func (kn *knossosServer) DepSnapshotChange(ctx context.Context, req *client.DepS
 		return nil, eris.Errorf("could not find dependency %s in mod %s %s", req.DepModid, req.Modid, req.Version)
 	}
 
+	dep, err := storage.LocalMods.GetMod(ctx, req.DepModid)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &client.DepSnapshotChangeResponse{}
+	if dep.Type == common.ModType_ENGINE {
+		userSettings, err := storage.GetUserSettingsForMod(ctx, req.Modid, req.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		// A user-selected engine takes precedence over the snapshot so the flags only matter if none is set.
+		if userSettings.GetEngineOptions().GetModid() == "" {
+			resp.Flags, err = mods.ValidateModFlags(ctx, rel, userSettings, &client.UserSettings_EngineOptions{
+				Modid:   req.DepModid,
+				Version: req.DepVersion,
+			})
+			if err != nil {
+				return nil, eris.Wrapf(err, "failed to validate the command line of %s against %s %s", req.Modid, req.DepModid, req.DepVersion)
+			}
+		}
+	}
+
 	rel.DependencySnapshot[req.DepModid] = req.DepVersion
 	err = storage.SaveLocalModRelease(ctx, rel)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: Add warning about potential conflicts (if we detect some).
-
-	return &client.SuccessResponse{Success: true}, nil
+	resp.Success = true
+	return resp, nil
 }
 
-func (kn *knossosServer) OpenDebugLog(ctx context.Context, req *client.NullMessage) (*client.TaskResult, error) {
-	prefPath := fsointerop.GetPrefPath(ctx)
-	logPath := filepath.Join(prefPath, "data", "fs2_open.log")
+func (kn *knossosServer) OpenDebugLog(ctx context.Context, req *client.PrefPathRequest) (*client.TaskResult, error) {
+	prefPath, err := getRequestedPrefPath(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	logPath := fsointerop.GetDebugLogPath(prefPath)
 
-	_, err := os.Stat(logPath)
+	_, err = os.Stat(logPath)
 	if eris.Is(err, os.ErrNotExist) {
 		return &client.TaskResult{Error: fmt.Sprintf("Could not find %s", logPath)}, nil
 	}
@@ -19,6 +19,10 @@ func (kn *knossosServer) GetRemoteMods(ctx context.Context, _ *client.NullMessag
 	return buildModList(ctx, storage.RemoteMods)
 }
 
+func (kn *knossosServer) SearchRemoteMods(ctx context.Context, req *client.SearchRemoteModsRequest) (*client.SearchRemoteModsResponse, error) {
+	return storage.SearchRemoteMods(ctx, req)
+}
+
 func (kn *knossosServer) GetRemoteModInfo(ctx context.Context, req *client.ModInfoRequest) (*client.ModInfoResponse, error) {
 	mod, err := storage.RemoteMods.GetMod(ctx, req.Id)
 	if err != nil {
@@ -35,12 +39,18 @@ func (kn *knossosServer) GetRemoteModInfo(ctx context.Context, req *client.ModIn
 		return nil, err
 	}
 
+	repo, err := storage.GetRemoteModRepository(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
 	release.Folder = ""
 	release.Packages = make([]*common.Package, 0)
 
 	return &client.ModInfoResponse{
-		Mod:      mod,
-		Release:  release,
-		Versions: versions,
+		Mod:        mod,
+		Release:    release,
+		Versions:   versions,
+		Repository: repo,
 	}, nil
 }
@@ -7,18 +7,47 @@ import (
 
 	"github.com/ngld/knossos/packages/api/client"
 	"github.com/ngld/knossos/packages/libknossos/pkg/fsointerop"
+	"github.com/ngld/knossos/packages/libknossos/pkg/mods"
+	"github.com/ngld/knossos/packages/libknossos/pkg/pilotfile"
 	"github.com/ngld/knossos/packages/libknossos/pkg/platform"
 	"github.com/ngld/knossos/packages/libopenal"
 	"github.com/rotisserie/eris"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-func (kn *knossosServer) LoadFSOSettings(ctx context.Context, req *client.NullMessage) (*client.FSOSettings, error) {
-	return fsointerop.LoadSettings(ctx)
+func getRequestedPrefPath(ctx context.Context, req *client.PrefPathRequest) (string, error) {
+	if req.GetModid() == "" {
+		return fsointerop.GetPrefPath(ctx), nil
+	}
+
+	return mods.GetPrefPathForMod(ctx, req.Modid, req.Version)
+}
+
+func (kn *knossosServer) LoadFSOSettings(ctx context.Context, req *client.PrefPathRequest) (*client.FSOSettings, error) {
+	prefPath, err := getRequestedPrefPath(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return fsointerop.LoadSettings(ctx, prefPath)
 }
 
 func (kn *knossosServer) SaveFSOSettings(ctx context.Context, req *client.FSOSettings) (*client.SuccessResponse, error) {
-	err := fsointerop.SaveSettings(ctx, req)
+	err := fsointerop.SaveSettings(ctx, fsointerop.GetPrefPath(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
+
+func (kn *knossosServer) SaveModFSOSettings(ctx context.Context, req *client.SaveModFSOSettingsRequest) (*client.SuccessResponse, error) {
+	prefPath, err := mods.GetPrefPathForMod(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fsointerop.SaveSettings(ctx, prefPath, req.Settings)
 	if err != nil {
 		return nil, err
 	}
@@ -109,3 +138,38 @@ func (kn *knossosServer) GetJoystickInfo(ctx context.Context, req *client.NullMe
 
 	return &client.JoystickInfoResponse{Joysticks: joysticks}, nil
 }
+
+func (kn *knossosServer) ListPilots(ctx context.Context, req *client.PrefPathRequest) (*client.ListPilotsResponse, error) {
+	prefPath, err := getRequestedPrefPath(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	pilots, err := pilotfile.ListPilots(prefPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.ListPilotsResponse{
+		PrefPath: prefPath,
+		Pilots:   pilots,
+	}, nil
+}
+
+func (kn *knossosServer) ListPilotSnapshots(ctx context.Context, req *client.PrefPathRequest) (*client.PilotSnapshotList, error) {
+	snapshots, err := mods.ListPilotSnapshots(ctx, req.Modid, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.PilotSnapshotList{Snapshots: snapshots}, nil
+}
+
+func (kn *knossosServer) RestorePilotSnapshot(ctx context.Context, req *client.RestorePilotSnapshotRequest) (*client.SuccessResponse, error) {
+	err := mods.RestorePilotSnapshot(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SuccessResponse{Success: true}, nil
+}
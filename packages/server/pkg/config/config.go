@@ -31,6 +31,9 @@ type Config struct {
 	}
 	Keys struct {
 		VersionUpdateKey string `usage:"The token used by the CI to update release versions"`
+		// ModsyncSigningKeys lists all keys used to sign modsync files. Clients accept files signed by any key they
+		// know which allows adding a new key here, shipping a client which pins it and only then removing the old key.
+		ModsyncSigningKeys []string `usage:"Paths to PEM encoded ed25519 private keys used to sign the modsync files"`
 	}
 	Mail struct {
 		From       string `usage:"Mail sender"`
@@ -22,7 +22,7 @@ import (
 
 const packSize = 10
 
-func buildReleaseFromRow(ctx context.Context, q queries.Querier, row queries.GetPublicModReleasesByAidRow, storagePath string) (*common.Release, error) {
+func buildReleaseFromRow(ctx context.Context, q queries.Querier, row queries.GetPublicModReleasesByAidRow, storagePath string, signer *Signer) (*common.Release, error) {
 	rel := &common.Release{
 		Modid:         *row.Modid,
 		Version:       *row.Version,
@@ -229,17 +229,46 @@ func buildReleaseFromRow(ctx context.Context, q queries.Querier, row queries.Get
 		pack.Archives[*archive.Label] = ar
 	}
 
-	encoded, err := proto.Marshal(pack)
+	err = writeSignedFile(storagePath, fmt.Sprintf("c.%s.%s", *row.Modid, *row.Version), pack, signer)
 	if err != nil {
-		return nil, eris.Wrapf(err, "failed to serialise checksum pack for release %d", *row.ID)
+		return nil, eris.Wrapf(err, "failed to write checksum pack for release %d", *row.ID)
 	}
 
-	err = ioutil.WriteFile(filepath.Join(storagePath, fmt.Sprintf("c.%s.%s", *row.Modid, *row.Version)), encoded, 0o660)
+	return rel, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to filePath and moves it into place afterwards. Clients
+// syncing during an export therefore either see the old or the new file but never a partially written one.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
 	if err != nil {
-		return nil, eris.Wrapf(err, "failed to write checksum pack for release %d", *row.ID)
+		return eris.Wrapf(err, "failed to create temporary file for %s", filePath)
 	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
 
-	return rel, nil
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return eris.Wrapf(err, "failed to write %s", tmpPath)
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return eris.Wrapf(err, "failed to close %s", tmpPath)
+	}
+
+	err = os.Chmod(tmpPath, 0o660)
+	if err != nil {
+		return eris.Wrapf(err, "failed to set permissions on %s", tmpPath)
+	}
+
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		return eris.Wrapf(err, "failed to move %s to %s", tmpPath, filePath)
+	}
+
+	return nil
 }
 
 func calcVersionsChecksum(_ context.Context, versions []string) ([]byte, error) {
@@ -270,7 +299,7 @@ func writePack(_ context.Context, modID string, storagePath string, packnum uint
 	}
 
 	packPath := filepath.Join(storagePath, fmt.Sprintf("m.%s.%03d", modID, packnum))
-	err = ioutil.WriteFile(packPath, encoded, 0o660)
+	err = writeFileAtomic(packPath, encoded)
 	if err != nil {
 		return eris.Wrapf(err, "failed to write pack %d for release %d", packnum, relID)
 	}
@@ -278,7 +307,7 @@ func writePack(_ context.Context, modID string, storagePath string, packnum uint
 	return nil
 }
 
-func buildModIndex(ctx context.Context, q queries.Querier, modID string, aID int32, storagePath string) (*common.ModIndex_Mod, error) {
+func buildModIndex(ctx context.Context, q queries.Querier, modID string, aID int32, storagePath string, signer *Signer) (*common.ModIndex_Mod, error) {
 	entry := new(common.ModIndex_Mod)
 	entry.Modid = modID
 	entry.LastModified = timestamppb.Now()
@@ -295,7 +324,7 @@ func buildModIndex(ctx context.Context, q queries.Querier, modID string, aID int
 	for idx, release := range releases {
 		versionNumbers[idx] = *release.Version
 
-		pbRel, err := buildReleaseFromRow(ctx, q, release, storagePath)
+		pbRel, err := buildReleaseFromRow(ctx, q, release, storagePath, signer)
 		if err != nil {
 			return nil, eris.Wrapf(err, "failed to process release %d", *release.ID)
 		}
@@ -329,7 +358,7 @@ func buildModIndex(ctx context.Context, q queries.Querier, modID string, aID int
 	return entry, nil
 }
 
-func updateModIndex(ctx context.Context, q queries.Querier, entry *common.ModIndex_Mod, aID int32, storagePath string) error {
+func updateModIndex(ctx context.Context, q queries.Querier, entry *common.ModIndex_Mod, aID int32, storagePath string, signer *Signer) error {
 	var lastUpdate time.Time
 
 	for _, modifiedRaw := range entry.PacksLastModified {
@@ -363,7 +392,7 @@ func updateModIndex(ctx context.Context, q queries.Querier, entry *common.ModInd
 		if *release.Deleted {
 			deletedRels = append(deletedRels, *release.Version)
 		} else {
-			pbRel, err := buildReleaseFromRow(ctx, q, queries.GetPublicModReleasesByAidRow(release), storagePath)
+			pbRel, err := buildReleaseFromRow(ctx, q, queries.GetPublicModReleasesByAidRow(release), storagePath, signer)
 			if err != nil {
 				return err
 			}
@@ -423,7 +452,7 @@ func updateModIndex(ctx context.Context, q queries.Querier, entry *common.ModInd
 				return eris.Wrapf(err, "failed to serialise pack %d from mod %s", packnum, entry.Modid)
 			}
 
-			err = ioutil.WriteFile(packPath, encoded, 0o660)
+			err = writeFileAtomic(packPath, encoded)
 			if err != nil {
 				return eris.Wrapf(err, "failed to write pack %d from mod %s", packnum, entry.Modid)
 			}
@@ -487,6 +516,36 @@ func updateModIndex(ctx context.Context, q queries.Querier, entry *common.ModInd
 	return err
 }
 
+func fileChecksum(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read %s", filePath)
+	}
+
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
+
+// updateFileChecksums stores the digests of the mod's metadata and pack files in the index entry. The signed index
+// thereby covers every file the client imports.
+func updateFileChecksums(entry *common.ModIndex_Mod, storagePath string) error {
+	var err error
+	entry.MetaChecksum, err = fileChecksum(filepath.Join(storagePath, fmt.Sprintf("m.%s", entry.Modid)))
+	if err != nil {
+		return eris.Wrapf(err, "failed to hash metadata for mod %s", entry.Modid)
+	}
+
+	entry.PackChecksums = make([][]byte, len(entry.PacksLastModified))
+	for packnum := range entry.PacksLastModified {
+		entry.PackChecksums[packnum], err = fileChecksum(filepath.Join(storagePath, fmt.Sprintf("m.%s.%03d", entry.Modid, packnum)))
+		if err != nil {
+			return eris.Wrapf(err, "failed to hash pack %d from mod %s", packnum, entry.Modid)
+		}
+	}
+
+	return nil
+}
+
 func writeModMetaFile(_ context.Context, mod queries.GetPublicModUpdatedDatesRow, storagePath string) error {
 	// We always update the mod metadata files since they're small and fast to write and we don't have a marker
 	// to check for updates.
@@ -516,7 +575,7 @@ func writeModMetaFile(_ context.Context, mod queries.GetPublicModUpdatedDatesRow
 		return eris.Wrapf(err, "failed to serialise metadata for mod %s", *mod.Modid)
 	}
 
-	err = ioutil.WriteFile(filepath.Join(storagePath, fmt.Sprintf("m.%s", *mod.Modid)), encoded, 0o660)
+	err = writeFileAtomic(filepath.Join(storagePath, fmt.Sprintf("m.%s", *mod.Modid)), encoded)
 	if err != nil {
 		return eris.Wrapf(err, "failed to write mod metadata file for mod %s", *mod.Modid)
 	}
@@ -524,19 +583,17 @@ func writeModMetaFile(_ context.Context, mod queries.GetPublicModUpdatedDatesRow
 	return nil
 }
 
-func UpdateModsyncExport(ctx context.Context, q queries.Querier, storagePath string) error {
+// UpdateModsyncExport updates the modsync files in storagePath. The index and checksum packs are signed with signer.
+// Every file is replaced atomically and the index is written last so a client which fetched the new index always finds
+// the packs it lists.
+func UpdateModsyncExport(ctx context.Context, q queries.Querier, storagePath string, signer *Signer) error {
 	indexFile := filepath.Join(storagePath, "index")
-	data, err := os.ReadFile(indexFile)
-	if err != nil && !eris.Is(err, os.ErrNotExist) {
-		return eris.Wrapf(err, "failed to read index from %s", indexFile)
+	index, err := readSignedIndex(indexFile)
+	if err != nil {
+		return err
 	}
-
-	var index common.ModIndex
-	if data != nil {
-		err = proto.Unmarshal(data, &index)
-		if err != nil {
-			return eris.Wrapf(err, "failed to parse %s", indexFile)
-		}
+	if index == nil {
+		index = new(common.ModIndex)
 	}
 
 	modlist, err := q.GetPublicModUpdatedDates(ctx)
@@ -555,7 +612,7 @@ func UpdateModsyncExport(ctx context.Context, q queries.Querier, storagePath str
 		idxEntry, found := modMap[*mod.Modid]
 		if !found {
 			// The mod isn't listed in the index. Build the pack files and append it.
-			entry, err := buildModIndex(ctx, q, *mod.Modid, *mod.Aid, storagePath)
+			entry, err := buildModIndex(ctx, q, *mod.Modid, *mod.Aid, storagePath, signer)
 			if err != nil {
 				return eris.Wrapf(err, "failed to build index for %s", *mod.Modid)
 			}
@@ -588,7 +645,7 @@ func UpdateModsyncExport(ctx context.Context, q queries.Querier, storagePath str
 
 		// Check if the mod metadata has changed since the last time we updated packs for this mod.
 		if mod.ReleaseUpdated.Time.After(lastIdxUpdate) {
-			err = updateModIndex(ctx, q, idxEntry, *mod.Aid, storagePath)
+			err = updateModIndex(ctx, q, idxEntry, *mod.Aid, storagePath, signer)
 			if err != nil {
 				return err
 			}
@@ -605,12 +662,14 @@ func UpdateModsyncExport(ctx context.Context, q queries.Querier, storagePath str
 		}
 	}
 
-	encoded, err := proto.Marshal(&index)
-	if err != nil {
-		return eris.Wrap(err, "failed to serialize mod index")
+	for _, entry := range index.Mods {
+		err = updateFileChecksums(entry, storagePath)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = ioutil.WriteFile(indexFile, encoded, 0o660)
+	err = writeSignedFile(storagePath, "index", index, signer)
 	if err != nil {
 		return eris.Wrapf(err, "failed to write mod index to %s", indexFile)
 	}
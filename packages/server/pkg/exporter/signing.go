@@ -0,0 +1,154 @@
+package exporter
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+
+	"github.com/ngld/knossos/packages/api/common"
+	"github.com/rotisserie/eris"
+	"google.golang.org/protobuf/proto"
+)
+
+// signaturePrefix is prepended to the signed data. The client uses the same prefix to verify signatures.
+const signaturePrefix = "knossos-modsync\x00"
+
+type signingKey struct {
+	id  string
+	key ed25519.PrivateKey
+}
+
+// Signer signs modsync files with one or more ed25519 keys. Signing with multiple keys allows clients to pin a new
+// key before the old one is retired.
+type Signer struct {
+	keys []signingKey
+}
+
+// KeyID returns the ID which identifies the passed key in signatures
+func KeyID(pub ed25519.PublicKey) string {
+	digest := sha256.Sum256(pub)
+	return hex.EncodeToString(digest[:8])
+}
+
+// LoadSigner reads the PEM encoded (PKCS #8) ed25519 private keys from the passed files. Such a key can be generated
+// with "openssl genpkey -algorithm ed25519".
+func LoadSigner(keyFiles []string) (*Signer, error) {
+	if len(keyFiles) == 0 {
+		return nil, eris.New("no modsync signing keys configured")
+	}
+
+	signer := &Signer{
+		keys: make([]signingKey, 0, len(keyFiles)),
+	}
+	for _, keyFile := range keyFiles {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to read signing key %s", keyFile)
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, eris.Errorf("failed to decode signing key %s: no PEM block found", keyFile)
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to parse signing key %s", keyFile)
+		}
+
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, eris.Errorf("signing key %s is not an ed25519 key", keyFile)
+		}
+
+		signer.keys = append(signer.keys, signingKey{
+			id:  KeyID(key.Public().(ed25519.PublicKey)),
+			key: key,
+		})
+	}
+
+	return signer, nil
+}
+
+// KeyIDs returns the IDs of all keys used by this signer
+func (s *Signer) KeyIDs() []string {
+	result := make([]string, len(s.keys))
+	for idx, key := range s.keys {
+		result[idx] = key.id
+	}
+	return result
+}
+
+// Sign wraps the passed payload in a SignedMessage. name is the file name of the message (i.e. "index") and is
+// included in the signature.
+func (s *Signer) Sign(name string, payload []byte) *common.SignedMessage {
+	data := make([]byte, 0, len(signaturePrefix)+len(name)+1+len(payload))
+	data = append(data, signaturePrefix...)
+	data = append(data, name...)
+	data = append(data, 0)
+	data = append(data, payload...)
+
+	msg := &common.SignedMessage{
+		Payload:    payload,
+		Signatures: make([]*common.SignedMessage_Signature, len(s.keys)),
+	}
+	for idx, key := range s.keys {
+		msg.Signatures[idx] = &common.SignedMessage_Signature{
+			KeyId:     key.id,
+			Signature: ed25519.Sign(key.key, data),
+		}
+	}
+
+	return msg
+}
+
+// writeSignedFile serialises and signs msg and writes the result to storagePath/name
+func writeSignedFile(storagePath, name string, msg proto.Message, signer *Signer) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return eris.Wrapf(err, "failed to serialise %s", name)
+	}
+
+	encoded, err := proto.Marshal(signer.Sign(name, payload))
+	if err != nil {
+		return eris.Wrapf(err, "failed to serialise signed %s", name)
+	}
+
+	err = writeFileAtomic(filepath.Join(storagePath, name), encoded)
+	if err != nil {
+		return eris.Wrapf(err, "failed to write %s", name)
+	}
+
+	return nil
+}
+
+// readSignedIndex loads the index written by the last export. A missing or unsigned index is reported as nil which
+// causes a full rebuild.
+func readSignedIndex(indexFile string) (*common.ModIndex, error) {
+	data, err := os.ReadFile(indexFile)
+	if err != nil {
+		if eris.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, eris.Wrapf(err, "failed to read index from %s", indexFile)
+	}
+
+	var signed common.SignedMessage
+	err = proto.Unmarshal(data, &signed)
+	if err != nil || len(signed.Signatures) == 0 {
+		// Most likely an index from before we signed modsync files
+		return nil, nil
+	}
+
+	index := new(common.ModIndex)
+	err = proto.Unmarshal(signed.Payload, index)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse %s", indexFile)
+	}
+
+	return index, nil
+}
@@ -104,15 +104,24 @@ func startMux(pool *pgxpool.Pool, q *queries.DBQuerier, cfg *config.Config) erro
 		WriteTimeout: 15 * time.Second,
 	}
 
-	go func() {
-		log.Info().Msg("Updating modsync files")
-		err := exporter.UpdateModsyncExport(context.Background(), q, syncRoot)
+	if len(cfg.Keys.ModsyncSigningKeys) == 0 {
+		log.Warn().Msg("No modsync signing keys configured; skipping modsync update")
+	} else {
+		signer, err := exporter.LoadSigner(cfg.Keys.ModsyncSigningKeys)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to update modsync files")
-		} else {
-			log.Info().Msg("modsync update finished")
+			return err
 		}
-	}()
+
+		go func() {
+			log.Info().Strs("keys", signer.KeyIDs()).Msg("Updating modsync files")
+			err := exporter.UpdateModsyncExport(context.Background(), q, syncRoot, signer)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to update modsync files")
+			} else {
+				log.Info().Msg("modsync update finished")
+			}
+		}()
+	}
 
 	err = muxServer.ListenAndServe()
 	if err != nil {